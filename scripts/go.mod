@@ -0,0 +1,7 @@
+module changkun.de/x/infloop/scripts
+
+go 1.18
+
+require changkun.de/x/infloop/tools v0.0.0
+
+replace changkun.de/x/infloop/tools => ../tools
@@ -6,6 +6,9 @@
 
 // This script parses ratings distributions of cherry-picked model IDs from
 // the field study. All data are generated into data/ratingdist folder.
+// Variants that were never rated, i.e. with a negative score, are
+// excluded. Scores off the scale of the study, e.g. the 99 in session
+// 33cc17f4, are kept as they are so that the distributions reproduce.
 //
 // Usage:
 //
@@ -21,6 +24,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
 )
 
 // Cherry-picked from the field study.
@@ -53,7 +58,7 @@ func main() {
 		conf := &base{}
 		err = json.Unmarshal(b, conf)
 		if err != nil {
-			panic(err)
+			panic(fmt.Errorf("%s: %w", path, err))
 		}
 
 		f, err := os.Create(fmt.Sprintf("./data/ratingdist/%s.csv", id))
@@ -64,7 +69,11 @@ func main() {
 		sorted := []row{}
 
 		for modelId, rating := range conf.Variants {
-			sorted = append(sorted, row{Root: id, Model: modelId, UnixTime: all[modelId].Unix(), Rating: int(rating)})
+			r := polyreduce.Rating(rating)
+			if r < polyreduce.Skip { // Unrated
+				continue
+			}
+			sorted = append(sorted, row{Root: id, Model: modelId, UnixTime: all[modelId].Unix(), Rating: r})
 		}
 		sort.SliceStable(sorted, func(i, j int) bool {
 			return sorted[i].UnixTime < sorted[j].UnixTime
@@ -72,10 +81,7 @@ func main() {
 
 		iteration := 0
 		for _, r := range sorted {
-			if r.Rating < 0 {
-				continue
-			}
-			fmt.Fprintf(f, "%v,%v,%v,%v,%v\n", r.Root, r.Model, r.UnixTime, iteration/4, r.Rating)
+			fmt.Fprintf(f, "%v,%v,%v,%v,%d\n", r.Root, r.Model, r.UnixTime, iteration/4, r.Rating)
 			iteration++
 		}
 		f.Close()
@@ -119,7 +125,7 @@ type base struct {
 type row struct {
 	Root, Model string
 	UnixTime    int64
-	Rating      int
+	Rating      polyreduce.Rating
 }
 
 type Scanner struct {
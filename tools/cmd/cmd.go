@@ -6,80 +6,231 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"strconv"
 
 	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
 	"github.com/spf13/cobra"
 )
 
-func Ping(cmd *cobra.Command, args []string) {
-	c := polyreduce.NewClient()
+func Ping(cmd *cobra.Command, args []string) error {
+	c := newClient()
 	o, err := c.Ping(context.Background())
 	if err != nil {
-		log.Fatalf("failed to ping polyreduce service: %v", err)
+		return fmt.Errorf("failed to ping polyreduce service: %w", err)
 	}
 
-	log.Println("OK")
-	log.Printf("%+#v", o)
+	return printResult(cmd, o, func(w io.Writer) {
+		fmt.Fprintln(w, "OK")
+		fmt.Fprintf(w, "%+#v\n", o)
+	})
+}
+
+// modelResult is the result of a command on a model.
+type modelResult struct {
+	ModelID string `json:"model_id"`
+	Message string `json:"message,omitempty"`
+	// Reused is true if the model was uploaded before.
+	Reused bool `json:"reused,omitempty"`
 }
 
-func Upload(cmd *cobra.Command, args []string) {
-	mp := args[0]
+func Upload(cmd *cobra.Command, args []string, force bool) error {
+	c := newClient()
+	bar := newProgressBar("uploading")
+	r, err := uploadModel(context.Background(), c, args[0], force, bar.Func())
+	bar.Done()
+	if err != nil {
+		return fmt.Errorf("failed to upload: %w", err)
+	}
 
-	c := polyreduce.NewClient()
-	o, err := c.PolyredUpload(context.Background(), &polyreduce.PolyredUploadInput{
-		ModelPath: mp,
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintln(w, r.Message)
+		fmt.Fprintln(w, r.ModelID)
 	})
+}
+
+// uploadModel uploads a model and records the upload in the local
+// history. A model that was uploaded from the same content to the same
+// service before is reused instead, unless force is set.
+func uploadModel(ctx context.Context, c *polyreduce.Client, path string, force bool, progress polyreduce.ProgressFunc) (*modelResult, error) {
+	sum, err := hashFile(path)
 	if err != nil {
-		log.Fatalf("failed to upload: %v", err)
+		return nil, err
+	}
+	if !force {
+		if e := reusableModel(ctx, c, sum); e != nil {
+			return &modelResult{ModelID: e.ID, Message: "model is already uploaded from " + e.Source, Reused: true}, nil
+		}
 	}
 
-	log.Println(o.Message)
-	log.Println(o.ModelId)
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{
+		ModelPath: path,
+		Progress:  progress,
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordHistory(c, &historyEvent{Kind: kindModel, ID: o.ModelId, Action: "upload", Source: path, SHA256: sum})
+	return &modelResult{ModelID: o.ModelId, Message: o.Message}, nil
 }
-func Config(cmd *cobra.Command, args []string) {
+
+// reusableModel returns the latest model of the local history that was
+// uploaded from a file with the given hash to the service of the client
+// and still exists there, or nil if the upload cannot be reused.
+func reusableModel(ctx context.Context, c *polyreduce.Client, sha256 string) *historyEntry {
+	if !reuseUploads {
+		return nil
+	}
+	e := uploadedModel(c.Endpoint(), sha256)
+	if e == nil {
+		return nil
+	}
+	// The model may be gone from the service since it was uploaded.
+	ok, err := c.PolyredExists(ctx, &polyreduce.PolyredExistsInput{ModelID: e.ID})
+	if err != nil {
+		log.Printf("cannot reuse model %s: %v", e.ID, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return e
+}
+
+// hashFile returns the hex encoded SHA-256 of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// configResult is the result of the config command.
+type configResult struct {
+	ModelID        string             `json:"model_id"`
+	ReductionRatio map[string]float64 `json:"reduction_ratio"`
+}
+
+func newConfigCmd() *cobra.Command {
+	f := &layerFlags{}
+	configCmd := &cobra.Command{
+		Use:               "config [id] [mesh_name] [target_reduction_ratio]",
+		Short:             "Config the simplification target",
+		ValidArgsFunction: completeIDs(kindModel),
+		Long: `Config the simplification target.
+
+The reduction ratio of a single layer can be given as arguments, or of
+many layers using the flags, e.g.:
+
+  config [id] --all 50 --layer 'Wheel*=30' --layer Body=80
+
+A layer takes the ratio of its name over a matching glob pattern over
+--all. The layer names are resolved from the meshes of the model, and
+a name or pattern that matches no mesh is an error.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 && len(args) != 3 {
+				return fmt.Errorf("accepts 1 or 3 arg(s), received %d", len(args))
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Config(cmd, args, f)
+		},
+	}
+	f.register(configCmd.Flags())
+	return configCmd
+}
+
+func Config(cmd *cobra.Command, args []string, f *layerFlags) error {
 	id := args[0]
-	name := args[1]
-	ratio, err := strconv.ParseFloat(args[2], 64)
+	ratios, err := f.ratios(cmd.Flags())
 	if err != nil {
-		log.Fatalf("cannot parse reduction ratio: %v", ratio)
+		return err
+	}
+	if len(args) == 3 {
+		ratio, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return &usageError{fmt.Errorf("cannot parse reduction ratio: %w", err)}
+		}
+		ratios = append(ratios, polyreduce.LayerRatio{Pattern: args[1], Ratio: ratio})
 	}
 
-	c := polyreduce.NewClient()
-	err = c.PolyredConfig(context.Background(), &polyreduce.PolyredConfigInput{
+	ctx := context.Background()
+	c := newClient()
+	config, err := resolveLayers(ctx, c, id, "", ratios)
+	if err != nil {
+		return err
+	}
+	err = c.PolyredConfig(ctx, &polyreduce.PolyredConfigInput{
 		ModelID:        id,
-		ReductionRatio: map[string]float64{name: ratio},
+		ReductionRatio: config,
 	})
 	if err != nil {
-		log.Fatalf("failed to config the reduction task: %v", err)
+		return fmt.Errorf("failed to config the reduction task: %w", err)
 	}
+	recordHistory(c, &historyEvent{Kind: kindModel, ID: id, Action: "config", Config: config})
 
-	log.Println("configuration was successful.")
+	r := &configResult{ModelID: id, ReductionRatio: config}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintln(w, "configuration was successful.")
+		fmt.Fprintln(w, formatConfig(config))
+	})
 }
-func Run(cmd *cobra.Command, args []string) {
+func Run(cmd *cobra.Command, args []string) error {
 	id := args[0]
 
-	c := polyreduce.NewClient()
+	c := newClient()
+	stop := spin("simplifying")
 	err := c.PolyredRun(context.Background(), &polyreduce.PolyredRunInput{ModelID: id})
+	stop()
 	if err != nil {
-		log.Fatalf("failed to run: %v", err)
+		return fmt.Errorf("failed to run: %w", err)
 	}
+	recordHistory(c, &historyEvent{Kind: kindModel, ID: id, Action: "run"})
 
-	log.Println("simplification is complete.")
+	return printResult(cmd, &modelResult{ModelID: id, Message: "simplification is complete"}, func(w io.Writer) {
+		fmt.Fprintln(w, "simplification is complete.")
+	})
+}
+
+// downloadResult is the result of a downloaded model.
+type downloadResult struct {
+	ModelID string `json:"model_id"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
 }
-func Download(cmd *cobra.Command, args []string) {
+
+func Download(cmd *cobra.Command, args []string) error {
 	id := args[0]
 	sp := args[1]
 
-	c := polyreduce.NewClient()
-	err := c.PolyredDownload(context.Background(), &polyreduce.DownloadInput{
-		ModelID: id,
-		Path:    sp,
+	c := newClient()
+	bar := newProgressBar("downloading")
+	o, err := c.PolyredDownload(context.Background(), &polyreduce.DownloadInput{
+		ModelID:  id,
+		Path:     sp,
+		Progress: bar.Func(),
 	})
+	bar.Done()
 	if err != nil {
-		log.Fatalf("failed to download: %v", err)
+		return fmt.Errorf("failed to download: %w", err)
 	}
+	recordHistory(c, &historyEvent{Kind: kindModel, ID: id, Action: "download", Output: o.Path})
 
-	log.Printf("model is saved to: %s", sp)
+	r := &downloadResult{ModelID: id, Path: o.Path, Size: o.Size, SHA256: o.SHA256}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "model is saved to: %s (%d bytes, sha256: %s)\n", o.Path, o.Size, o.SHA256)
+	})
 }
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+const testModel = "../polyreduce-sdk-go/testdata/monkey.fbx"
+
+// stateDirs are the state directories of the tests, which keep the local
+// history across the commands of a test.
+var stateDirs = map[*testing.T]string{}
+
+// execute runs the command line tool with the given arguments against
+// the fake server and returns the logged output.
+func execute(t *testing.T, s *polyreducetest.Server, args ...string) (string, error) {
+	t.Helper()
+	return executeInput(t, s, "", args...)
+}
+
+// executeInput is like execute, but the command reads the given input.
+func executeInput(t *testing.T, s *polyreducetest.Server, input string, args ...string) (string, error) {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	err := executeStreams(t, s, input, buf, buf, args...)
+	return buf.String(), err
+}
+
+// executeStreams is like executeInput, but writes the output of the
+// command to out and the log to logs.
+func executeStreams(t *testing.T, s *polyreducetest.Server, input string, out, logs io.Writer, args ...string) error {
+	t.Helper()
+
+	// Ignore the configuration of the machine that runs the tests.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if _, ok := stateDirs[t]; !ok {
+		stateDirs[t] = t.TempDir()
+		t.Cleanup(func() { delete(stateDirs, t) })
+	}
+	t.Setenv("XDG_STATE_HOME", stateDirs[t])
+	for _, env := range []string{envConfig, envProfile, envEndpoint, envUsername, envPassword, envTimeout, envOutput, envHistory} {
+		t.Setenv(env, "")
+	}
+
+	clientOptions = []polyreduce.Option{
+		polyreduce.WithEndpoint(s.URL),
+		polyreduce.WithPollInterval(10 * time.Millisecond),
+	}
+	defer func() { clientOptions = nil }()
+
+	log.SetOutput(logs)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+
+	root := New()
+	root.SetArgs(args)
+	root.SetIn(strings.NewReader(input))
+	root.SetOut(out)
+	return root.Execute()
+}
+
+func TestCommands(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	out, err := execute(t, s, "ping")
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+	if !strings.Contains(out, polyreducetest.Version) {
+		t.Fatalf("ping should print the service version, got: %s", out)
+	}
+
+	out, err = execute(t, s, "upload", testModel)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	id := lines[len(lines)-1]
+	if _, ok := s.Model(id); !ok {
+		t.Fatalf("upload should print the model ID, got: %s", out)
+	}
+
+	if _, err := execute(t, s, "config", id, "default", "20"); err != nil {
+		t.Fatalf("failed to config: %v", err)
+	}
+	if _, err := execute(t, s, "run", id); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "out.fbx")
+	if _, err := execute(t, s, "download", id, path); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+}
+
+func TestCommands_Errors(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	if _, err := execute(t, s, "config", "missing", "default", "abc"); err == nil {
+		t.Fatalf("config should reject an invalid ratio")
+	}
+	if _, err := execute(t, s, "run", "missing"); err == nil {
+		t.Fatalf("run should fail for a missing model")
+	}
+}
+
+func TestCommands_Cassette(t *testing.T) {
+	dir := t.TempDir()
+	s := polyreducetest.NewServer()
+
+	out, err := execute(t, s, "upload", testModel, "--record", dir)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	s.Close()
+
+	replayed, err := execute(t, s, "upload", testModel, "--replay", dir)
+	if err != nil {
+		t.Fatalf("failed to replay upload: %v", err)
+	}
+	if replayed != out {
+		t.Fatalf("replay should reproduce the output, want: %s, got: %s", out, replayed)
+	}
+	if _, err := execute(t, s, "ping", "--replay", dir); err == nil {
+		t.Fatalf("ping is not recorded and should fail")
+	}
+}
+
+func TestCommands_ConfigLayers(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"Body", "Wheel_FL", "Wheel_FR", "Window"}
+
+	out, err := execute(t, s, "upload", testModel)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	id := lines[len(lines)-1]
+
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "ratios.yaml")
+	if err := os.WriteFile(yamlFile, []byte("all: 90\nlayers:\n  Window: 10\n  Wheel*: 40\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "ratios.json")
+	if err := os.WriteFile(jsonFile, []byte(`{"layers": {"Body": 70, "Wheel_FL": 60}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want map[string]float64
+	}{
+		{
+			args: []string{id, "Body", "20"},
+			want: map[string]float64{"Body": 20},
+		},
+		{
+			args: []string{id, "--all", "50", "--layer", "Wheel*=30", "--layer", "Wheel_FR=20"},
+			want: map[string]float64{"Body": 50, "Wheel_FL": 30, "Wheel_FR": 20, "Window": 50},
+		},
+		{
+			// The flags override the file.
+			args: []string{id, "--file", yamlFile, "--all", "80", "--layer", "Wheel*=30"},
+			want: map[string]float64{"Body": 80, "Wheel_FL": 30, "Wheel_FR": 30, "Window": 10},
+		},
+		{
+			args: []string{id, "--file", jsonFile},
+			want: map[string]float64{"Body": 70, "Wheel_FL": 60},
+		},
+	}
+	for _, tt := range tests {
+		if _, err := execute(t, s, append([]string{"config"}, tt.args...)...); err != nil {
+			t.Fatalf("failed to config %v: %v", tt.args, err)
+		}
+		m, _ := s.Model(id)
+		if !reflect.DeepEqual(m.Config, tt.want) {
+			t.Fatalf("config %v: want %v, got %v", tt.args, tt.want, m.Config)
+		}
+	}
+
+	for _, args := range [][]string{
+		{id, "--layer", "Tire*=30"},
+		{id, "Wheel", "30"},
+		{id},
+		{id, "Body"},
+		{id, "--layer", "Body"},
+	} {
+		_, err := execute(t, s, append([]string{"config"}, args...)...)
+		if ExitCode(err) != ExitUsage {
+			t.Fatalf("config %v should fail with a usage error, got: %v", args, err)
+		}
+	}
+
+	// A service without the layers API only accepts mesh names.
+	s.Handle(polyreducetest.RoutePolyredLayers, http.NotFoundHandler())
+	defer s.Handle(polyreducetest.RoutePolyredLayers, nil)
+	if _, err := execute(t, s, "config", id, "Window", "15"); err != nil {
+		t.Fatalf("config of a mesh name should not need the layers API: %v", err)
+	}
+	if m, _ := s.Model(id); !reflect.DeepEqual(m.Config, map[string]float64{"Window": 15}) {
+		t.Fatalf("unexpected config: %v", m.Config)
+	}
+	if _, err := execute(t, s, "config", id, "--layer", "Wheel*=30"); ExitCode(err) != ExitNotFound {
+		t.Fatalf("a pattern should require the layers API, got: %v", err)
+	}
+}
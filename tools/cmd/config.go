@@ -0,0 +1,235 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the configuration file of the command line tool, which is
+// ~/.config/infloop/config.yaml by default:
+//
+//	profile: staging
+//	profiles:
+//	  production:
+//	    endpoint: https://polyreduce.com
+//	  staging:
+//	    endpoint: https://staging.polyreduce.com
+//	    username: user
+//	    password: pass
+//	    timeout: 10m
+type config struct {
+	// Profile is the profile that is used if no profile is selected,
+	// "default" if empty.
+	Profile string `yaml:"profile"`
+	// Profiles are the named profiles.
+	Profiles map[string]*profile `yaml:"profiles"`
+}
+
+// profile configures the client for a deployment of the service.
+type profile struct {
+	Endpoint string        `yaml:"endpoint"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// The environment variables that override the configuration file.
+const (
+	envConfig   = "INFLOOP_CONFIG"
+	envProfile  = "INFLOOP_PROFILE"
+	envEndpoint = "INFLOOP_ENDPOINT"
+	envUsername = "INFLOOP_USERNAME"
+	envPassword = "INFLOOP_PASSWORD"
+	envTimeout  = "INFLOOP_TIMEOUT"
+	envOutput   = "INFLOOP_OUTPUT"
+)
+
+// defaultProfile is the profile that is used if no profile is selected
+// by the flags, the environment or the configuration file.
+const defaultProfile = "default"
+
+// configError is an invalid configuration.
+type configError struct{ err error }
+
+func (e *configError) Error() string { return e.err.Error() }
+func (e *configError) Unwrap() error { return e.err }
+
+// defaultConfigPath returns the path of the configuration file in the
+// XDG config directory, ~/.config/infloop/config.yaml by default.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "infloop", "config.yaml")
+}
+
+// loadConfig reads the configuration file. A missing file is an empty
+// configuration, unless the file is required.
+func loadConfig(path string, required bool) (*config, error) {
+	c := &config{}
+	if path == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config: %w", err)
+	}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("cannot parse config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// rootFlags are the persistent flags of the root command.
+type rootFlags struct {
+	config   string
+	profile  string
+	endpoint string
+	username string
+	password string
+	timeout  time.Duration
+	output   string
+	record   string
+	replay   string
+}
+
+func (f *rootFlags) register(cmd *cobra.Command) {
+	pf := cmd.PersistentFlags()
+	pf.StringVar(&f.config, "config", "", "config file, $"+envConfig+" or ~/.config/infloop/config.yaml by default")
+	pf.StringVarP(&f.profile, "profile", "p", "", "profile of the config file, $"+envProfile+" or the profile of the config file by default")
+	pf.StringVar(&f.endpoint, "endpoint", "", "endpoint of the service, overrides $"+envEndpoint+" and the profile")
+	pf.StringVar(&f.username, "username", "", "username of the service, overrides $"+envUsername+" and the profile")
+	pf.StringVar(&f.password, "password", "", "password of the service, overrides $"+envPassword+" and the profile")
+	pf.DurationVar(&f.timeout, "timeout", 0, "timeout of every request, overrides $"+envTimeout+" and the profile")
+	pf.StringVarP(&f.output, "output", "o", "", "output format, text or json, overrides $"+envOutput)
+	pf.StringVar(&f.record, "record", "", "record all interactions with the service into a cassette directory")
+	pf.StringVar(&f.replay, "replay", "", "replay the interactions of a cassette directory instead of using the service")
+}
+
+// settings are the resolved settings of a command.
+type settings struct {
+	profile
+	// Name is the name of the used profile, if any.
+	Name   string
+	Output string
+}
+
+// resolve merges the configuration file, the environment and the flags
+// in the order of increasing precedence.
+func (f *rootFlags) resolve(cmd *cobra.Command, getenv func(string) string) (*settings, error) {
+	path, required := f.config, true
+	if path == "" {
+		path = getenv(envConfig)
+	}
+	if path == "" {
+		path, required = defaultConfigPath(), false
+	}
+	c, err := loadConfig(path, required)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &settings{Output: outputText}
+	s.Name = f.profile
+	if s.Name == "" {
+		s.Name = getenv(envProfile)
+	}
+	explicit := s.Name != ""
+	if s.Name == "" {
+		s.Name = c.Profile
+		explicit = s.Name != ""
+	}
+	if s.Name == "" {
+		s.Name = defaultProfile
+	}
+	if p, ok := c.Profiles[s.Name]; ok && p != nil {
+		s.profile = *p
+	} else if explicit {
+		return nil, fmt.Errorf("profile %q does not exist in config %s", s.Name, path)
+	} else {
+		s.Name = ""
+	}
+
+	for _, v := range []struct {
+		env string
+		dst *string
+	}{
+		{envEndpoint, &s.Endpoint},
+		{envUsername, &s.Username},
+		{envPassword, &s.Password},
+		{envOutput, &s.Output},
+	} {
+		if val := getenv(v.env); val != "" {
+			*v.dst = val
+		}
+	}
+	if val := getenv(envTimeout); val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid $%s: %w", envTimeout, err)
+		}
+		s.Timeout = d
+	}
+
+	flags := cmd.Flags()
+	for _, v := range []struct {
+		name string
+		src  string
+		dst  *string
+	}{
+		{"endpoint", f.endpoint, &s.Endpoint},
+		{"username", f.username, &s.Username},
+		{"password", f.password, &s.Password},
+		{"output", f.output, &s.Output},
+	} {
+		if flags.Changed(v.name) {
+			*v.dst = v.src
+		}
+	}
+	if flags.Changed("timeout") {
+		s.Timeout = f.timeout
+	}
+
+	if s.Output != outputText && s.Output != outputJSON {
+		return nil, fmt.Errorf("invalid output format %q, expect text or json", s.Output)
+	}
+	if s.Timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout %v", s.Timeout)
+	}
+	return s, nil
+}
+
+// options returns the client options of the settings.
+func (s *settings) options() []polyreduce.Option {
+	var opts []polyreduce.Option
+	if s.Endpoint != "" {
+		opts = append(opts, polyreduce.WithEndpoint(s.Endpoint))
+	}
+	if s.Username != "" || s.Password != "" {
+		opts = append(opts, polyreduce.WithCredentials(s.Username, s.Password))
+	}
+	if s.Timeout > 0 {
+		opts = append(opts, polyreduce.WithTimeout(s.Timeout))
+	}
+	return opts
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+	"github.com/spf13/cobra"
+)
+
+const testConfig = `profile: staging
+profiles:
+  production:
+    endpoint: https://polyreduce.com
+  staging:
+    endpoint: https://staging.polyreduce.com
+    username: user
+    password: pass
+    timeout: 10m
+`
+
+func TestConfig_Resolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		want  settings
+		fails bool
+	}{
+		{
+			name: "default profile",
+			args: []string{"--config", path},
+			want: settings{Name: "staging", Output: outputText, profile: profile{
+				Endpoint: "https://staging.polyreduce.com", Username: "user", Password: "pass", Timeout: 10 * time.Minute,
+			}},
+		},
+		{
+			name: "profile from env",
+			env:  map[string]string{envConfig: path, envProfile: "production"},
+			want: settings{Name: "production", Output: outputText, profile: profile{Endpoint: "https://polyreduce.com"}},
+		},
+		{
+			name: "flags override env",
+			args: []string{"--config", path, "-p", "production", "--endpoint", "http://localhost:8080", "--timeout", "1s", "-o", "json"},
+			env:  map[string]string{envEndpoint: "http://env", envUsername: "env", envTimeout: "1m", envOutput: "text"},
+			want: settings{Name: "production", Output: outputJSON, profile: profile{
+				Endpoint: "http://localhost:8080", Username: "env", Timeout: time.Second,
+			}},
+		},
+		{
+			name: "no config",
+			env:  map[string]string{envEndpoint: "http://env"},
+			want: settings{Output: outputText, profile: profile{Endpoint: "http://env"}},
+		},
+		{name: "missing config", args: []string{"--config", path + ".missing"}, fails: true},
+		{name: "unknown profile", args: []string{"--config", path, "-p", "dev"}, fails: true},
+		{name: "invalid output", args: []string{"-o", "yaml"}, fails: true},
+		{name: "invalid timeout", env: map[string]string{envTimeout: "soon"}, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			f := &rootFlags{}
+			cmd := &cobra.Command{}
+			f.register(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("failed to parse flags: %v", err)
+			}
+			s, err := f.resolve(cmd, func(k string) string { return tt.env[k] })
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("resolve should fail, got: %+v", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to resolve: %v", err)
+			}
+			if *s != tt.want {
+				t.Fatalf("unexpected settings, want %+v, got %+v", tt.want, *s)
+			}
+		})
+	}
+}
+
+func TestCommands_Output(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	out, err := execute(t, s, "ping", "--output", "json")
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+	ping := polyreduce.PingOutput{}
+	if err := json.Unmarshal([]byte(out), &ping); err != nil || ping.Version != polyreducetest.Version {
+		t.Fatalf("ping should print JSON, got %q: %v", out, err)
+	}
+
+	out, err = execute(t, s, "session", "upload", testModel, "-o", "json")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	r := sessionResult{}
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("upload should print JSON, got %q: %v", out, err)
+	}
+	if _, ok := s.Session(r.SessionID); !ok {
+		t.Fatalf("upload should print the session ID, got: %s", out)
+	}
+}
+
+func TestCommands_TextOutput(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	// A reused upload logs a diagnostic, the result goes to the output.
+	if _, err := execute(t, s, "upload", testModel); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	s.Inject(polyreducetest.RoutePolyredDownload, polyreducetest.Fault{Status: http.StatusInternalServerError})
+	defer s.ClearFaults()
+
+	out, logs := new(bytes.Buffer), new(bytes.Buffer)
+	if err := executeStreams(t, s, "", out, logs, "upload", testModel); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("upload should print the result to the output, got %q", out)
+	}
+	if _, ok := s.Model(lines[1]); !ok {
+		t.Fatalf("upload should print the model ID, got %q", out)
+	}
+	if !strings.Contains(logs.String(), "cannot reuse model") {
+		t.Fatalf("diagnostics should be logged, got %q", logs)
+	}
+}
+
+func TestCommands_ExitCode(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(config, []byte("profiles:\n  default:\n    endpoint: "+s.URL+"\n    username: user\n    password: wrong\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"ping"}, ExitOK},
+		{[]string{"ping", "--unknown"}, ExitUsage},
+		{[]string{"run"}, ExitUsage},
+		{[]string{"config", "id", "default", "abc"}, ExitUsage},
+		{[]string{"ping", "--output", "yaml"}, ExitConfig},
+		{[]string{"ping", "--config", config + ".missing"}, ExitConfig},
+		{[]string{"run", "missing"}, ExitNotFound},
+		{[]string{"upload", testModel, "--config", config}, ExitUnauthorized},
+		{[]string{"ping", "--endpoint", "http://127.0.0.1:1"}, ExitUnavailable},
+		{[]string{"session", "loop", testModel, "--mode", "vote"}, ExitUsage},
+	}
+	for _, tt := range tests {
+		_, err := execute(t, s, append(tt.args, "--timeout", "5s")...)
+		if got := ExitCode(err); got != tt.want {
+			t.Errorf("%v: want exit code %d, got %d: %v", tt.args, tt.want, got, err)
+		}
+	}
+	if ExitCode(errors.New("unknown")) != ExitError {
+		t.Fatalf("an unknown error should exit with %d", ExitError)
+	}
+}
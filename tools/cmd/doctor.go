@@ -0,0 +1,354 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+)
+
+func newDoctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the connection to polyred service",
+		Long: `Diagnose the connection to polyred service.
+
+The doctor checks the endpoint, the proxy, DNS, TCP and TLS, the
+version of the service, the clock and the credentials, and suggests a
+fix for every problem it finds. Nothing is retried, hence a flaky
+connection shows up as a failure.`,
+		Args: cobra.NoArgs,
+		RunE: Doctor,
+	}
+}
+
+// The statuses of a check of the doctor.
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// Limits of the checks of the doctor.
+const (
+	doctorTimeout     = 10 * time.Second
+	clockSkewWarning  = time.Minute
+	clockSkewFailure  = 5 * time.Minute
+	certExpiryWarning = 14 * 24 * time.Hour
+)
+
+// doctorProxy returns the proxy of a request. It is a variable for
+// tests, since http.ProxyFromEnvironment reads the environment once.
+var doctorProxy = http.ProxyFromEnvironment
+
+// doctorCheck is the result of a single check of the doctor.
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+
+	err error
+}
+
+// doctorResult is the result of the doctor command.
+type doctorResult struct {
+	Endpoint string         `json:"endpoint"`
+	Client   string         `json:"client"`
+	Checks   []*doctorCheck `json:"checks"`
+}
+
+// check appends the result of a check.
+func (r *doctorResult) check(name, status, detail, fix string, err error) *doctorCheck {
+	c := &doctorCheck{Name: name, Status: status, Detail: detail, Fix: fix, err: err}
+	r.Checks = append(r.Checks, c)
+	return c
+}
+
+func Doctor(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	c := newClient(polyreduce.WithRetryPolicy(polyreduce.NoRetry))
+	r := &doctorResult{Endpoint: c.Endpoint(), Client: polyreduce.ClientVersion}
+	diagnose(ctx, c, r)
+
+	err := printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "client %s, endpoint %s\n", r.Client, r.Endpoint)
+		for _, c := range r.Checks {
+			fmt.Fprintf(w, "%-6s %-8s %s\n", "["+c.Status+"]", c.Name, c.Detail)
+			if c.Fix != "" {
+				fmt.Fprintf(w, "%-15s fix: %s\n", "", c.Fix)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	var first error
+	for _, c := range r.Checks {
+		if c.Status == checkFail {
+			failed++
+			if first == nil {
+				first = c.err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed: %w", failed, len(r.Checks), first)
+	}
+	return nil
+}
+
+// diagnose runs all checks in order. A check that depends on a failed
+// check is skipped.
+func diagnose(ctx context.Context, c *polyreduce.Client, r *doctorResult) {
+	u, err := url.Parse(c.Endpoint())
+	if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+		err = errors.New("expect an http or https URL")
+	}
+	if err != nil {
+		r.check("endpoint", checkFail, fmt.Sprintf("invalid endpoint %q: %v", c.Endpoint(), err),
+			"set a URL such as "+polyreduce.DefaultEndpoint+" by --endpoint, $"+envEndpoint+" or the profile",
+			&configError{err})
+		return
+	}
+	r.check("endpoint", checkOK, u.String(), "", nil)
+
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	proxy, err := doctorProxy(&http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}})
+	switch {
+	case err != nil:
+		r.check("proxy", checkFail, fmt.Sprintf("invalid proxy: %v", err),
+			"fix or unset $HTTPS_PROXY and $HTTP_PROXY", err)
+		return
+	case proxy != nil:
+		r.check("proxy", checkOK, "requests go through "+proxy.Redacted(), "", nil)
+		// The connection to the service is tunneled through the proxy.
+		host, port = proxy.Hostname(), proxy.Port()
+		if port == "" {
+			port = "80"
+		}
+	default:
+		r.check("proxy", checkOK, "no proxy", "", nil)
+	}
+
+	reachable := checkNetwork(ctx, r, host, port, proxy != nil)
+	if reachable && u.Scheme == "https" && proxy == nil {
+		checkTLS(ctx, r, u.Hostname(), port)
+	} else if u.Scheme != "https" {
+		status, fix := checkOK, ""
+		if ip := net.ParseIP(host); !(host == "localhost" || ip != nil && ip.IsLoopback()) {
+			status, fix = checkWarn, "use an https endpoint, since the credentials are sent in plain text"
+		}
+		r.check("tls", status, "the endpoint does not use TLS", fix, nil)
+	} else if proxy != nil {
+		r.check("tls", checkSkip, "the TLS handshake is tunneled through the proxy", "", nil)
+	}
+	if !reachable {
+		for _, name := range []string{"ping", "version", "clock", "auth"} {
+			r.check(name, checkSkip, "the service is unreachable", "", nil)
+		}
+		return
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	start := time.Now()
+	o, err := c.Ping(tctx)
+	cancel()
+	if err != nil {
+		fix := "check that the endpoint is a polyred service"
+		switch {
+		case errors.Is(err, polyreduce.ErrServerBusy):
+			fix = "the service is busy, try again later"
+		case errors.As(err, new(net.Error)):
+			fix = "check the network and the proxy, and that the service is running"
+		}
+		r.check("ping", checkFail, err.Error(), fix, err)
+		r.check("version", checkSkip, "the ping failed", "", nil)
+		r.check("clock", checkSkip, "the ping failed", "", nil)
+	} else {
+		r.check("ping", checkOK, fmt.Sprintf("%s in %v", o.Message, o.Latency.Round(time.Millisecond)), "", nil)
+		checkVersion(r, o)
+		checkClock(r, o, start)
+	}
+
+	tctx, cancel = context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	checkAuth(tctx, r, c)
+}
+
+// checkAuth checks the credentials by asking for a model that does not
+// exist, hence accepted credentials result in not found. Since a missing
+// route results in not found as well, the route must be known to
+// require credentials by rejecting an anonymous request first.
+func checkAuth(ctx context.Context, r *doctorResult, c *polyreduce.Client) {
+	const nilModel = "00000000-0000-0000-0000-000000000000"
+	fix := "set the credentials by --username and --password, $" + envUsername + " and $" + envPassword + ", or the profile"
+
+	anonymous := newClient(polyreduce.WithCredentials("", ""), polyreduce.WithRetryPolicy(polyreduce.NoRetry))
+	_, err := anonymous.PolyredExists(ctx, &polyreduce.PolyredExistsInput{ModelID: nilModel})
+	if !errors.Is(err, polyreduce.ErrUnauthorized) {
+		if err == nil {
+			err = errors.New("an anonymous request is not rejected")
+		}
+		r.check("auth", checkWarn, fmt.Sprintf("cannot check the credentials: %v", err),
+			"check that the endpoint and the base path are of a polyred service", nil)
+		return
+	}
+
+	_, err = c.PolyredExists(ctx, &polyreduce.PolyredExistsInput{ModelID: nilModel})
+	switch {
+	case err == nil:
+		r.check("auth", checkOK, "the credentials are accepted", "", nil)
+	case errors.Is(err, polyreduce.ErrUnauthorized):
+		r.check("auth", checkFail, "the service rejected the credentials", fix, err)
+	default:
+		r.check("auth", checkWarn, fmt.Sprintf("cannot check the credentials: %v", err), "", nil)
+	}
+}
+
+// checkNetwork resolves the host and connects to it, and reports
+// whether the host is reachable.
+func checkNetwork(ctx context.Context, r *doctorResult, host, port string, proxy bool) bool {
+	target := "service"
+	if proxy {
+		target = "proxy"
+	}
+	tctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	if net.ParseIP(host) != nil {
+		r.check("dns", checkOK, host+" is an IP address", "", nil)
+	} else {
+		addrs, err := net.DefaultResolver.LookupHost(tctx, host)
+		if err != nil {
+			r.check("dns", checkFail, fmt.Sprintf("cannot resolve the %s: %v", target, err),
+				"check the host name "+host+", and the DNS resolver of the network", err)
+			r.check("tcp", checkSkip, "the host is not resolved", "", nil)
+			return false
+		}
+		r.check("dns", checkOK, fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", ")), "", nil)
+	}
+
+	addr := net.JoinHostPort(host, port)
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(tctx, "tcp", addr)
+	if err != nil {
+		r.check("tcp", checkFail, fmt.Sprintf("cannot connect to the %s: %v", target, err),
+			fmt.Sprintf("check that the %s is running at %s, and that no firewall blocks port %s", target, addr, port), err)
+		return false
+	}
+	conn.Close()
+	r.check("tcp", checkOK, fmt.Sprintf("connected to %s in %v", addr, time.Since(start).Round(time.Millisecond)), "", nil)
+	return true
+}
+
+// checkTLS verifies the certificate of the service.
+func checkTLS(ctx context.Context, r *doctorResult, host, port string) {
+	tctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	d := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+	conn, err := d.DialContext(tctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fix := "check the TLS configuration of the service and of the network"
+		var (
+			authority x509.UnknownAuthorityError
+			hostname  x509.HostnameError
+			invalid   x509.CertificateInvalidError
+		)
+		switch {
+		case errors.As(err, &authority):
+			fix = "the certificate is signed by an unknown authority, install the CA certificate of the service or of the network into the trust store of the system"
+		case errors.As(err, &hostname):
+			fix = "the certificate is not valid for " + host + ", check the endpoint"
+		case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+			fix = "the certificate is expired or not yet valid, check the clock of this machine, or ask the operator to renew the certificate"
+		}
+		r.check("tls", checkFail, err.Error(), fix, err)
+		return
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	version := map[uint16]string{
+		tls.VersionTLS10: "TLS 1.0",
+		tls.VersionTLS11: "TLS 1.1",
+		tls.VersionTLS12: "TLS 1.2",
+		tls.VersionTLS13: "TLS 1.3",
+	}[state.Version]
+	expiry := state.PeerCertificates[0].NotAfter
+	detail := fmt.Sprintf("%s, the certificate is valid until %s", version, expiry.Format("2006-01-02"))
+	if time.Until(expiry) < certExpiryWarning {
+		r.check("tls", checkWarn, detail, "ask the operator to renew the certificate", nil)
+		return
+	}
+	r.check("tls", checkOK, detail, "", nil)
+}
+
+// checkVersion checks the version of the service against the client.
+func checkVersion(r *doctorResult, o *polyreduce.PingOutput) {
+	c := polyreduce.CheckCompatibility(polyreduce.ClientVersion, o.Version)
+	detail := c.Reason
+	if o.BuildTime != "" {
+		detail += fmt.Sprintf(", service built at %s", o.BuildTime)
+	}
+	switch c.Status {
+	case polyreduce.Compatible:
+		r.check("version", checkOK, detail, "", nil)
+	case polyreduce.Incompatible:
+		r.check("version", checkFail, detail, c.Fix, errors.New(c.Reason))
+	default:
+		r.check("version", checkWarn, detail, c.Fix, nil)
+	}
+}
+
+// checkClock compares the clock of the service to the local clock. The
+// Date header has a resolution of a second.
+func checkClock(r *doctorResult, o *polyreduce.PingOutput, start time.Time) {
+	if o.Date.IsZero() {
+		r.check("clock", checkSkip, "the service does not report its clock", "", nil)
+		return
+	}
+	skew := o.Date.Sub(start.Add(o.Latency / 2)).Round(time.Second)
+	if skew > -2*time.Second && skew < 2*time.Second {
+		r.check("clock", checkOK, "the clock agrees with the service", "", nil)
+		return
+	}
+	abs, dir := skew, "behind"
+	if skew < 0 {
+		abs, dir = -skew, "ahead of"
+	}
+	detail := fmt.Sprintf("the clock is %v %s the service", abs, dir)
+	fix := "synchronize the clock of this machine, e.g. by enabling NTP"
+	switch {
+	case abs >= clockSkewFailure:
+		r.check("clock", checkFail, detail, fix, errors.New(detail))
+	case abs >= clockSkewWarning:
+		r.check("clock", checkWarn, detail, fix, nil)
+	default:
+		r.check("clock", checkOK, detail, "", nil)
+	}
+}
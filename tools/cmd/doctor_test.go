@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestDoctor(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	// doctor runs the doctor and returns the statuses of the checks.
+	doctor := func(args ...string) (map[string]string, error) {
+		t.Helper()
+		out, err := execute(t, s, append([]string{"doctor", "-o", "json"}, args...)...)
+		var r doctorResult
+		if i := strings.Index(out, "{"); i < 0 || json.Unmarshal([]byte(out[i:]), &r) != nil {
+			t.Fatalf("doctor should print JSON, got: %s", out)
+		}
+		statuses := map[string]string{}
+		for _, c := range r.Checks {
+			statuses[c.Name] = c.Status
+			if c.Status == checkFail && c.Fix == "" {
+				t.Fatalf("the failed check %s should suggest a fix: %+v", c.Name, c)
+			}
+		}
+		return statuses, err
+	}
+	expect := func(got map[string]string, want map[string]string) {
+		t.Helper()
+		for name, status := range want {
+			if got[name] != status {
+				t.Fatalf("want %s to be %s, got: %v", name, status, got)
+			}
+		}
+	}
+
+	got, err := doctor()
+	if err != nil {
+		t.Fatalf("the doctor should pass: %v, %v", err, got)
+	}
+	expect(got, map[string]string{
+		"endpoint": checkOK, "proxy": checkOK, "dns": checkOK, "tcp": checkOK, "tls": checkOK,
+		"ping": checkOK, "version": checkOK, "clock": checkOK, "auth": checkOK,
+	})
+	out, err := execute(t, s, "doctor")
+	if err != nil || !strings.Contains(out, "[ok]   auth     the credentials are accepted") {
+		t.Fatalf("the doctor should print the checks, got: %v\n%s", err, out)
+	}
+
+	s.SetCredentials("someone", "else")
+	got, err = doctor()
+	expect(got, map[string]string{"ping": checkOK, "auth": checkFail})
+	if ExitCode(err) != ExitUnauthorized {
+		t.Fatalf("rejected credentials should exit with %d, got: %v", ExitUnauthorized, err)
+	}
+	s.SetCredentials(polyreducetest.Username, polyreducetest.Password)
+
+	// A route that does not exist proves nothing about the credentials.
+	s.Inject(polyreducetest.RoutePolyredDownload, polyreducetest.Fault{Status: http.StatusNotFound})
+	got, err = doctor()
+	expect(got, map[string]string{"ping": checkOK, "auth": checkWarn})
+	if err != nil {
+		t.Fatalf("an unchecked credential should not fail the doctor: %v", err)
+	}
+	s.ClearFaults()
+
+	s.SetVersion("v0.1.0", "2030-01-01T00:00:00Z")
+	s.SetClockSkew(-10 * time.Minute)
+	got, err = doctor()
+	expect(got, map[string]string{"version": checkFail, "clock": checkFail, "auth": checkOK})
+	if ExitCode(err) != ExitError {
+		t.Fatalf("an incompatible service should exit with %d, got: %v", ExitError, err)
+	}
+	s.SetVersion("dev", "")
+	s.SetClockSkew(2 * time.Minute)
+	got, err = doctor()
+	expect(got, map[string]string{"version": checkWarn, "clock": checkWarn})
+	if err != nil {
+		t.Fatalf("warnings should not fail the doctor: %v", err)
+	}
+
+	// The proxy is not resolved, hence the service is unreachable.
+	doctorProxy = func(*http.Request) (*url.URL, error) { return url.Parse("http://proxy.invalid:3128") }
+	got, err = doctor()
+	doctorProxy = http.ProxyFromEnvironment
+	expect(got, map[string]string{"proxy": checkOK, "dns": checkFail, "tcp": checkSkip, "ping": checkSkip, "auth": checkSkip})
+	if ExitCode(err) != ExitUnavailable {
+		t.Fatalf("an unreachable proxy should exit with %d, got: %v", ExitUnavailable, err)
+	}
+
+	got, err = doctor("--endpoint", "ftp://example.com")
+	expect(got, map[string]string{"endpoint": checkFail})
+	if ExitCode(err) != ExitConfig {
+		t.Fatalf("an invalid endpoint should exit with %d, got: %v", ExitConfig, err)
+	}
+
+	s.Close()
+	got, err = doctor()
+	expect(got, map[string]string{"tcp": checkFail, "ping": checkSkip})
+	if ExitCode(err) != ExitUnavailable {
+		t.Fatalf("a stopped service should exit with %d, got: %v", ExitUnavailable, err)
+	}
+}
+
+func TestDoctor_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	r := &doctorResult{}
+	checkTLS(context.Background(), r, u.Hostname(), u.Port())
+	if len(r.Checks) != 1 || r.Checks[0].Status != checkFail || !strings.Contains(r.Checks[0].Fix, "unknown authority") {
+		t.Fatalf("a self-signed certificate should fail, got: %+v", r.Checks[0])
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+)
+
+// envHistory is the environment variable of the local history file.
+const envHistory = "INFLOOP_HISTORY"
+
+// useHistory enables the recording of the local history, and
+// reuseUploads enables the deduplication of uploads by the local history.
+// Both are set by the flags of the root command.
+var useHistory, reuseUploads = true, true
+
+// The kinds of the entries of the local history.
+const (
+	kindModel   = "model"
+	kindSession = "session"
+)
+
+// historyPath returns the path of the local history, which is
+// $INFLOOP_HISTORY, or infloop/history.jsonl in the XDG state directory,
+// ~/.local/state/infloop/history.jsonl by default.
+func historyPath() string {
+	if p := os.Getenv(envHistory); p != "" {
+		return p
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "infloop", "history.jsonl")
+}
+
+// historyEvent is a single line of the local history.
+type historyEvent struct {
+	Time     time.Time          `json:"time"`
+	Kind     string             `json:"kind"`
+	ID       string             `json:"id"`
+	Action   string             `json:"action"`
+	Endpoint string             `json:"endpoint,omitempty"`
+	Source   string             `json:"source,omitempty"`
+	SHA256   string             `json:"sha256,omitempty"`
+	Config   map[string]float64 `json:"config,omitempty"`
+	Output   string             `json:"output,omitempty"`
+	// Parent is the session that was copied into a new session.
+	Parent string `json:"parent,omitempty"`
+	// IDs are the variants of a run phase of a session.
+	IDs []string `json:"ids,omitempty"`
+}
+
+// historyMu serializes the events of concurrent jobs.
+var historyMu sync.Mutex
+
+// recordHistory appends an event to the local history. The history is
+// a convenience, hence a failure is only logged.
+func recordHistory(c *polyreduce.Client, e *historyEvent) {
+	path := historyPath()
+	if !useHistory || path == "" {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.Endpoint = c.Endpoint()
+	if e.Source != "" {
+		if abs, err := filepath.Abs(e.Source); err == nil {
+			e.Source = abs
+		}
+	}
+	if e.Output != "" {
+		if abs, err := filepath.Abs(e.Output); err == nil {
+			e.Output = abs
+		}
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		var b []byte
+		if b, err = json.Marshal(e); err == nil {
+			var f *os.File
+			if f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+				_, err = f.Write(append(b, '\n'))
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("failed to record history: %v", err)
+	}
+}
+
+// historyEntry is a model or a session of the local history, which
+// aggregates all of its events.
+type historyEntry struct {
+	ID       string             `json:"id"`
+	Kind     string             `json:"kind"`
+	Endpoint string             `json:"endpoint"`
+	Source   string             `json:"source,omitempty"`
+	SHA256   string             `json:"sha256,omitempty"`
+	Created  time.Time          `json:"created"`
+	Updated  time.Time          `json:"updated"`
+	Action   string             `json:"last_action"`
+	Config   map[string]float64 `json:"config,omitempty"`
+	Outputs  []string           `json:"outputs,omitempty"`
+	Events   []*historyEvent    `json:"events,omitempty"`
+}
+
+// loadHistory reads the local history, where the entries are sorted by
+// the time they were created. Malformed lines, e.g. of an interrupted
+// write, are skipped.
+func loadHistory() ([]*historyEntry, error) {
+	path := historyPath()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read history: %w", err)
+	}
+	defer f.Close()
+
+	var entries []*historyEntry
+	byID := map[string]*historyEntry{}
+	outputs := map[*historyEntry]map[string]bool{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		ev := &historyEvent{}
+		if err := json.Unmarshal(sc.Bytes(), ev); err != nil || ev.ID == "" {
+			continue
+		}
+		key := ev.Endpoint + " " + ev.ID
+		e, ok := byID[key]
+		if !ok {
+			e = &historyEntry{ID: ev.ID, Kind: ev.Kind, Endpoint: ev.Endpoint, Created: ev.Time}
+			byID[key] = e
+			entries = append(entries, e)
+		}
+		e.Updated, e.Action = ev.Time, ev.Action
+		if ev.Source != "" {
+			e.Source, e.SHA256 = ev.Source, ev.SHA256
+		}
+		if ev.Config != nil {
+			e.Config = ev.Config
+		}
+		if ev.Output != "" && !outputs[e][ev.Output] {
+			if outputs[e] == nil {
+				outputs[e] = map[string]bool{}
+			}
+			outputs[e][ev.Output] = true
+			e.Outputs = append(e.Outputs, ev.Output)
+		}
+		e.Events = append(e.Events, ev)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read history: %w", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Created.Before(entries[j].Created) })
+	return entries, nil
+}
+
+// findHistory returns the entry with the given ID, or the only entry
+// whose ID starts with the given prefix.
+func findHistory(entries []*historyEntry, id string) (*historyEntry, error) {
+	var found []*historyEntry
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+		if strings.HasPrefix(e.ID, id) {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s is not in the local history", polyreduce.ErrNotFound, id)
+	case 1:
+		return found[0], nil
+	}
+	return nil, &usageError{fmt.Errorf("%s is ambiguous, it is a prefix of %d IDs", id, len(found))}
+}
+
+// uploadedModel returns the latest model of the local history that was
+// uploaded from a file with the given hash to the given endpoint.
+func uploadedModel(endpoint, sha256 string) *historyEntry {
+	entries, err := loadHistory()
+	if err != nil {
+		log.Printf("failed to load history: %v", err)
+		return nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Kind == kindModel && e.Endpoint == endpoint && e.SHA256 == sha256 {
+			return e
+		}
+	}
+	return nil
+}
+
+// completeIDs completes the first argument of a command by the IDs of
+// the local history of the given kind, or of any kind if empty.
+func completeIDs(kind string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+		entries, err := loadHistory()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var ids []string
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if kind != "" && e.Kind != kind || !strings.HasPrefix(e.ID, toComplete) {
+				continue
+			}
+			desc := e.Kind
+			if e.Source != "" {
+				desc += " " + filepath.Base(e.Source)
+			}
+			ids = append(ids, e.ID+"\t"+desc)
+		}
+		return ids, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func newLsCmd() *cobra.Command {
+	var kind string
+	lsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List the models and sessions of the local history",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return List(cmd, kind)
+		},
+	}
+	lsCmd.Flags().StringVar(&kind, "kind", "", "list only models or sessions")
+	return lsCmd
+}
+
+func List(cmd *cobra.Command, kind string) error {
+	if kind != "" && kind != kindModel && kind != kindSession {
+		return &usageError{fmt.Errorf("invalid kind %q, expect model or session", kind)}
+	}
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	listed := []*historyEntry{}
+	for _, e := range entries {
+		if kind == "" || e.Kind == kind {
+			// The events are only shown for a single entry.
+			cp := *e
+			cp.Events = nil
+			listed = append(listed, &cp)
+		}
+	}
+
+	return printResult(cmd, listed, func(out io.Writer) {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tKIND\tUPDATED\tLAST\tSOURCE")
+		for _, e := range listed {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.ID, e.Kind, e.Updated.Local().Format("2006-01-02 15:04:05"), e.Action, e.Source)
+		}
+		w.Flush()
+	})
+}
+
+func Show(cmd *cobra.Command, args []string) error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	e, err := findHistory(entries, args[0])
+	if err != nil {
+		return err
+	}
+
+	return printResult(cmd, e, func(out io.Writer) {
+		fmt.Fprintf(out, "%s %s\n", e.Kind, e.ID)
+		fmt.Fprintf(out, "endpoint: %s\n", e.Endpoint)
+		if e.Source != "" {
+			fmt.Fprintf(out, "source:   %s (sha256: %s)\n", e.Source, e.SHA256)
+		}
+		if e.Config != nil {
+			fmt.Fprintf(out, "config:   %s\n", formatConfig(e.Config))
+		}
+		for _, o := range e.Outputs {
+			fmt.Fprintf(out, "output:   %s\n", o)
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, ev := range e.Events {
+			detail := ev.Output
+			switch {
+			case ev.Parent != "":
+				detail = "copy of " + ev.Parent
+			case ev.Config != nil:
+				detail = formatConfig(ev.Config)
+			case len(ev.IDs) > 0:
+				detail = strings.Join(ev.IDs, " ")
+			case ev.Source != "":
+				detail = ev.Source
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", ev.Time.Local().Format("2006-01-02 15:04:05"), ev.Action, detail)
+		}
+		w.Flush()
+	})
+}
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+	"github.com/spf13/cobra"
+)
+
+func TestHistory(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	upload := func(args ...string) modelResult {
+		t.Helper()
+		out, err := execute(t, s, append([]string{"upload", testModel, "-o", "json"}, args...)...)
+		if err != nil {
+			t.Fatalf("failed to upload: %v\n%s", err, out)
+		}
+		var r modelResult
+		if err := json.Unmarshal([]byte(out[strings.Index(out, "{"):]), &r); err != nil {
+			t.Fatalf("upload should print JSON, got %q: %v", out, err)
+		}
+		return r
+	}
+
+	first := upload()
+	if first.Reused {
+		t.Fatalf("the first upload should not be reused")
+	}
+	if r := upload(); r.ModelID != first.ModelID || !r.Reused {
+		t.Fatalf("the same file should reuse model %s, got: %+v", first.ModelID, r)
+	}
+	forced := upload("--force")
+	if forced.ModelID == first.ModelID || forced.Reused {
+		t.Fatalf("a forced upload should upload again, got: %+v", forced)
+	}
+	// A model that is gone from the service is uploaded again.
+	s.Inject(polyreducetest.RoutePolyredDownload, polyreducetest.Fault{Status: http.StatusNotFound, Times: 1})
+	gone := upload()
+	if gone.ModelID == forced.ModelID || gone.Reused {
+		t.Fatalf("a gone model should be uploaded again, got: %+v", gone)
+	}
+	// The reuse relies on the API of every version of the service.
+	s.Handle(polyreducetest.RoutePolyredLayers, http.NotFoundHandler())
+	if r := upload(); r.ModelID != gone.ModelID || !r.Reused {
+		t.Fatalf("a service without the layers API should reuse model %s, got: %+v", gone.ModelID, r)
+	}
+	s.Handle(polyreducetest.RoutePolyredLayers, nil)
+
+	id := first.ModelID
+	path := filepath.Join(t.TempDir(), "out.fbx")
+	for _, args := range [][]string{
+		{"config", id, "default", "20"},
+		{"run", id},
+		{"download", id, path},
+		{"session", "upload", testModel},
+	} {
+		if out, err := execute(t, s, args...); err != nil {
+			t.Fatalf("failed to %v: %v\n%s", args, err, out)
+		}
+	}
+
+	out, err := execute(t, s, "ls", "-o", "json")
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	var entries []*historyEntry
+	if err := json.Unmarshal([]byte(out[strings.Index(out, "["):]), &entries); err != nil {
+		t.Fatalf("ls should print JSON, got %q: %v", out, err)
+	}
+	if len(entries) != 4 || entries[0].ID != id || entries[3].Kind != kindSession {
+		t.Fatalf("unexpected entries: %s", out)
+	}
+	e := entries[0]
+	if e.Action != "download" || e.Config["default"] != 20 || len(e.Outputs) != 1 || e.Outputs[0] != path || e.SHA256 == "" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if out, _ := execute(t, s, "ls", "--kind", "session"); strings.Count(out, "\n") != 2 {
+		t.Fatalf("ls --kind session should list a single session, got:\n%s", out)
+	}
+
+	out, err = execute(t, s, "show", id[:8])
+	if err != nil {
+		t.Fatalf("failed to show: %v", err)
+	}
+	for _, want := range []string{"model " + id, "upload", "config", "run", "download", path} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("show should print %q, got:\n%s", want, out)
+		}
+	}
+	if _, err := execute(t, s, "show", "missing"); ExitCode(err) != ExitNotFound {
+		t.Fatalf("show of a missing ID should fail with not found, got: %v", err)
+	}
+
+	out, err = execute(t, s, "__complete", "run", id[:4])
+	if err != nil {
+		t.Fatalf("failed to complete: %v", err)
+	}
+	if !strings.Contains(out, id+"\tmodel monkey.fbx") || strings.Contains(out, entries[3].ID) {
+		t.Fatalf("run should complete the model IDs, got:\n%s", out)
+	}
+	out, err = execute(t, s, "__complete", "session", "inspect", "")
+	if err != nil {
+		t.Fatalf("failed to complete: %v", err)
+	}
+	if !strings.Contains(out, entries[3].ID) || strings.Contains(out, id) {
+		t.Fatalf("session inspect should complete the session IDs, got:\n%s", out)
+	}
+}
+
+func TestHistory_Reductions(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	out, err := execute(t, s, "upload", testModel, "-o", "json")
+	if err != nil {
+		t.Fatalf("failed to upload: %v\n%s", err, out)
+	}
+	var up modelResult
+	if err := json.Unmarshal([]byte(out[strings.Index(out, "{"):]), &up); err != nil {
+		t.Fatalf("upload should print JSON, got %q: %v", out, err)
+	}
+
+	// The reductions reuse the uploaded model and record their steps.
+	model, err := os.ReadFile(testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "car.fbx"), model, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(dir, "pipeline.yaml")
+	reduced := t.TempDir()
+	if err := os.WriteFile(manifest, []byte("jobs:\n  - model: car.fbx\n    output: "+filepath.Join(reduced, "car_50.fbx")+"\n    all: 50\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"pipeline", "run", manifest},
+		{"sweep", testModel, "--ratios", "10", "--dir", reduced},
+		{"watch", dir, "--out", reduced, "--all", "20", "--once", "--interval", "10ms", "--settle", "20ms"},
+	} {
+		if out, err := execute(t, s, args...); err != nil {
+			t.Fatalf("failed to %v: %v\n%s", args, err, out)
+		}
+	}
+	entries, err := loadHistory()
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != up.ModelID {
+		t.Fatalf("the reductions should reuse model %s, got %d entries", up.ModelID, len(entries))
+	}
+	if e := entries[0]; len(e.Outputs) != 3 || len(e.Events) != 10 {
+		t.Fatalf("the reductions should be recorded, got %d events of outputs %v", len(e.Events), e.Outputs)
+	}
+
+	// The tables are written to the standard output, e.g. for grep.
+	buf := new(bytes.Buffer)
+	cmd := &cobra.Command{}
+	cmd.SetOut(buf)
+	if err := List(cmd, ""); err != nil || !strings.Contains(buf.String(), up.ModelID) {
+		t.Fatalf("ls should write to the standard output, got %q: %v", buf, err)
+	}
+	buf.Reset()
+	if err := Show(cmd, []string{up.ModelID}); err != nil || !strings.Contains(buf.String(), "download") {
+		t.Fatalf("show should write to the standard output, got %q: %v", buf, err)
+	}
+}
+
+func TestHistory_Session(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	dir := t.TempDir()
+	state := filepath.Join(dir, "session.json")
+	args := []string{"session", "loop", testModel, "--events", filepath.Join(dir, "events.jsonl"),
+		"--mode", "best-of-n", "--max-iterations", "1", "--state", state}
+	if out, err := executeInput(t, s, "1\n", args...); err != nil {
+		t.Fatalf("failed to run the loop: %v\n%s", err, out)
+	}
+	ss, err := s.Client().LoadSession(state)
+	if err != nil {
+		t.Fatalf("failed to load the state: %v", err)
+	}
+	for _, args := range [][]string{
+		{"session", "amend", ss.ID, "1", "1=good"},
+		{"session", "retract", ss.ID, "1"},
+		{"session", "pull", ss.ID, "--dir", filepath.Join(dir, "pull")},
+	} {
+		if out, err := execute(t, s, args...); err != nil {
+			t.Fatalf("failed to %v: %v\n%s", args, err, out)
+		}
+	}
+
+	entries, err := loadHistory()
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+	e, err := findHistory(entries, ss.ID)
+	if err != nil {
+		t.Fatalf("the session should be recorded: %v", err)
+	}
+	var actions []string
+	for _, ev := range e.Events {
+		actions = append(actions, ev.Action)
+	}
+	want := "upload run download evaluate amend retract download"
+	if got := strings.Join(actions, " "); got != want {
+		t.Fatalf("want actions %q, got %q", want, got)
+	}
+}
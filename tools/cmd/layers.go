@@ -0,0 +1,90 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// layerFlags are the flags that configure the reduction ratio of the
+// layers of a model.
+type layerFlags struct {
+	layers []string
+	all    float64
+	file   string
+
+	hasAll bool
+}
+
+func (f *layerFlags) register(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&f.layers, "layer", nil, "reduction ratio of the layers that match a name or glob pattern, e.g. 'Wheel*=30', repeatable")
+	fs.Float64Var(&f.all, "all", 0, "reduction ratio of all layers without a more specific ratio")
+	fs.StringVar(&f.file, "file", "", "JSON or YAML file of the reduction ratios, e.g. {\"all\": 50, \"layers\": {\"Wheel*\": 30}}")
+}
+
+// layerFile is the file format of the reduction ratios, in JSON or YAML.
+type layerFile struct {
+	All    *float64           `yaml:"all"`
+	Layers map[string]float64 `yaml:"layers"`
+}
+
+// ratios returns the layer ratios of the flags. The ratios of the file
+// come first, hence the flags override them.
+func (f *layerFlags) ratios(fs *pflag.FlagSet) ([]polyreduce.LayerRatio, error) {
+	var ratios []polyreduce.LayerRatio
+	if f.file != "" {
+		b, err := os.ReadFile(f.file)
+		if err != nil {
+			return nil, &usageError{fmt.Errorf("cannot read reduction ratios: %w", err)}
+		}
+		lf := &layerFile{}
+		if err := yaml.Unmarshal(b, lf); err != nil {
+			return nil, &usageError{fmt.Errorf("cannot parse reduction ratios of %s: %w", f.file, err)}
+		}
+		if lf.All != nil {
+			ratios = append(ratios, polyreduce.LayerRatio{Pattern: "*", Ratio: *lf.All})
+		}
+		patterns := make([]string, 0, len(lf.Layers))
+		for pattern := range lf.Layers {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			ratios = append(ratios, polyreduce.LayerRatio{Pattern: pattern, Ratio: lf.Layers[pattern]})
+		}
+	}
+	if fs.Changed("all") {
+		ratios = append(ratios, polyreduce.LayerRatio{Pattern: "*", Ratio: f.all})
+	}
+	for _, s := range f.layers {
+		r, err := polyreduce.ParseLayerRatio(s)
+		if err != nil {
+			return nil, &usageError{err}
+		}
+		ratios = append(ratios, r)
+	}
+	return ratios, nil
+}
+
+// resolveLayers resolves the layer ratios against the layers of a model,
+// which is uploaded from the given path if known. See ModelLayers.
+func resolveLayers(ctx context.Context, c *polyreduce.Client, id, path string, ratios []polyreduce.LayerRatio) (map[string]float64, error) {
+	layers, err := c.ModelLayers(ctx, id, path, ratios)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the layers of model %s: %w", id, err)
+	}
+	config, err := polyreduce.ResolveReductionRatio(layers, ratios)
+	if err != nil {
+		return nil, &usageError{err}
+	}
+	return config, nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+)
+
+// The output formats of the results of commands.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputFormat is the output format of the running command, which is
+// set by the --output flag.
+var outputFormat = outputText
+
+// printResult prints the result of a command to stdout. In the JSON
+// format, the result is encoded, and otherwise the text function writes
+// it to w. Diagnostics are logged to stderr in both formats.
+func printResult(cmd *cobra.Command, v interface{}, text func(w io.Writer)) error {
+	if outputFormat == outputJSON {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	text(cmd.OutOrStdout())
+	return nil
+}
+
+// The exit codes of the command line tool.
+const (
+	ExitOK           = 0
+	ExitError        = 1 // any other error
+	ExitUsage        = 2 // invalid arguments or flags
+	ExitConfig       = 3 // invalid configuration
+	ExitUnauthorized = 4 // the service rejected the credentials
+	ExitNotFound     = 5 // a model or session does not exist
+	ExitConflict     = 6 // the state of a model or session conflicts
+	ExitUnavailable  = 7 // the service is busy or unreachable
+)
+
+// usageError is an invalid argument or flag.
+type usageError struct{ err error }
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// ExitCode returns the exit code of an error of a command.
+func ExitCode(err error) int {
+	var (
+		uerr *usageError
+		cerr *configError
+		nerr net.Error
+	)
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &uerr):
+		return ExitUsage
+	case errors.As(err, &cerr):
+		return ExitConfig
+	case errors.Is(err, polyreduce.ErrUnauthorized):
+		return ExitUnauthorized
+	case errors.Is(err, polyreduce.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, polyreduce.ErrConflict):
+		return ExitConflict
+	case errors.Is(err, polyreduce.ErrServerBusy), errors.As(err, &nerr):
+		return ExitUnavailable
+	}
+	return ExitError
+}
+
+// Execute runs the command line tool and returns its exit code. A failed
+// command is reported to stderr, as a JSON object in the JSON format.
+func Execute() int {
+	err := New().Execute()
+	code := ExitCode(err)
+	if err == nil {
+		return code
+	}
+	if outputFormat == outputJSON {
+		b, _ := json.Marshal(struct {
+			Error    string `json:"error"`
+			ExitCode int    `json:"exit_code"`
+		}{err.Error(), code})
+		fmt.Fprintln(os.Stderr, string(b))
+	} else {
+		log.Print(err)
+	}
+	return code
+}
+
+// usageArgs turns the errors of an argument validator into usage errors.
+func usageArgs(args cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, a []string) error {
+		if err := args(cmd, a); err != nil {
+			return &usageError{err}
+		}
+		return nil
+	}
+}
+
+// markUsageErrors turns the argument and flag errors of a command and
+// all its subcommands into usage errors.
+func markUsageErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &usageError{err}
+	})
+	if cmd.Args != nil {
+		cmd.Args = usageArgs(cmd.Args)
+	}
+	for _, c := range cmd.Commands() {
+		markUsageErrors(c)
+	}
+}
@@ -0,0 +1,623 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/mesh"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// newPipelineCmd creates the command group of batch pipelines.
+func newPipelineCmd() *cobra.Command {
+	pipelineCmd := &cobra.Command{
+		Use:   "pipeline",
+		Short: "Reduce many models as declared by a manifest",
+	}
+	f := &pipelineFlags{}
+	runCmd := &cobra.Command{
+		Use:   "run [manifest]",
+		Short: "Upload, config, run and download every model of a manifest",
+		Long: `Upload, config, run and download every model of a manifest:
+
+  workers: 4        # number of concurrent jobs, 2 by default
+  rate: 5           # maximum requests per second, unlimited by default
+  output: reduced   # directory of the reduced models
+  jobs:
+    - model: assets/car.fbx
+      all: 50
+      layers:
+        Wheel*: 30
+    - model: assets/props/*.fbx   # a job for every matching model
+      output: reduced/props
+      faces: 5000                 # target face count instead of a ratio
+
+A job reduces its layers like the config command. The output of a job
+is a .fbx file, or a directory where the model is saved under its own
+name. Relative paths are relative to the manifest.
+
+The state of every job is saved to <manifest>.state.json after every
+step, hence an interrupted pipeline continues where it stopped when it
+runs again. A model that was uploaded before is reused like by the
+upload command. A failed job does not stop the other jobs, and is retried
+by the next run.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return PipelineRun(cmd, args, f)
+		},
+	}
+	runCmd.Flags().IntVar(&f.workers, "workers", 0, "number of concurrent jobs, overrides the manifest")
+	runCmd.Flags().Float64Var(&f.rate, "rate", 0, "maximum requests per second, overrides the manifest")
+	runCmd.Flags().StringVar(&f.state, "state", "", "state file, overrides the manifest")
+	pipelineCmd.AddCommand(runCmd)
+	return pipelineCmd
+}
+
+// pipelineFlags are the flags of the pipeline run command.
+type pipelineFlags struct {
+	workers int
+	rate    float64
+	state   string
+}
+
+// pipelineManifest is the manifest of a pipeline.
+type pipelineManifest struct {
+	Workers int             `yaml:"workers"`
+	Rate    float64         `yaml:"rate"`
+	State   string          `yaml:"state"`
+	Output  string          `yaml:"output"`
+	Jobs    []*pipelineSpec `yaml:"jobs"`
+}
+
+// pipelineSpec declares the jobs of a model or a glob pattern of models.
+type pipelineSpec struct {
+	Model         string `yaml:"model"`
+	Output        string `yaml:"output"`
+	reductionSpec `yaml:",inline"`
+}
+
+// reductionSpec declares the reduction ratios of a model, either for all
+// layers or as a target face count, and for the layers matching a
+// pattern.
+type reductionSpec struct {
+	All    *float64           `yaml:"all"`
+	Faces  int                `yaml:"faces"`
+	Layers map[string]float64 `yaml:"layers"`
+}
+
+// ratios returns the layer ratios of the reduction, where the layers are
+// sorted by their pattern.
+func (r *reductionSpec) ratios() ([]polyreduce.LayerRatio, error) {
+	if r.Faces < 0 || r.Faces > 0 && r.All != nil {
+		return nil, errors.New("requires either a ratio for all layers or a positive face count")
+	}
+	var ratios []polyreduce.LayerRatio
+	if r.All != nil {
+		ratios = append(ratios, polyreduce.LayerRatio{Pattern: "*", Ratio: *r.All})
+	}
+	patterns := make([]string, 0, len(r.Layers))
+	for p := range r.Layers {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		ratios = append(ratios, polyreduce.LayerRatio{Pattern: p, Ratio: r.Layers[p]})
+	}
+	if len(ratios) == 0 && r.Faces == 0 {
+		return nil, errors.New("has no reduction ratio")
+	}
+	for _, lr := range ratios {
+		if lr.Ratio < 0 || lr.Ratio > 100 {
+			return nil, fmt.Errorf("has an invalid reduction ratio of %q: %v, expect 0 to 100", lr.Pattern, lr.Ratio)
+		}
+	}
+	return ratios, nil
+}
+
+// pipelineJob is a single model of a pipeline.
+type pipelineJob struct {
+	Model  string
+	Output string
+	Ratios []polyreduce.LayerRatio
+	// Faces is the target face count, zero if not set.
+	Faces int
+	// Fingerprint changes if the model or the configuration changes.
+	Fingerprint string
+}
+
+// readPipeline reads a manifest and expands it to jobs. Relative paths
+// are resolved against the directory of the manifest.
+func readPipeline(path string) (*pipelineManifest, []*pipelineJob, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	m := &pipelineManifest{}
+	if err := yaml.Unmarshal(b, m); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse manifest %s: %w", path, err)
+	}
+	base := filepath.Dir(path)
+	abs := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+	if m.State == "" {
+		m.State = strings.TrimSuffix(path, filepath.Ext(path)) + ".state.json"
+	} else {
+		m.State = abs(m.State)
+	}
+
+	var jobs []*pipelineJob
+	outputs := map[string]string{}
+	for i, spec := range m.Jobs {
+		if spec.Model == "" {
+			return nil, nil, fmt.Errorf("job %d has no model", i+1)
+		}
+		ratios, err := spec.ratios()
+		if err != nil {
+			return nil, nil, fmt.Errorf("job %d of %s %w", i+1, spec.Model, err)
+		}
+
+		models, err := filepath.Glob(abs(spec.Model))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid model pattern of job %d: %w", i+1, err)
+		}
+		if len(models) == 0 {
+			return nil, nil, fmt.Errorf("job %d matches no model: %s", i+1, spec.Model)
+		}
+		output := spec.Output
+		if output == "" {
+			output = m.Output
+		}
+		output = abs(output)
+		if len(models) > 1 && strings.EqualFold(filepath.Ext(output), ".fbx") {
+			return nil, nil, fmt.Errorf("job %d matches %d models, its output must be a directory", i+1, len(models))
+		}
+		for _, model := range models {
+			j := &pipelineJob{Model: model, Output: output, Ratios: ratios, Faces: spec.Faces}
+			if !strings.EqualFold(filepath.Ext(output), ".fbx") {
+				j.Output = filepath.Join(output, filepath.Base(model))
+			}
+			if other, ok := outputs[j.Output]; ok {
+				return nil, nil, fmt.Errorf("models %s and %s have the same output %s", other, model, j.Output)
+			}
+			outputs[j.Output] = model
+			if j.Fingerprint, err = fingerprint(j); err != nil {
+				return nil, nil, err
+			}
+			jobs = append(jobs, j)
+		}
+	}
+	return m, jobs, nil
+}
+
+// fingerprint hashes the model file and the configuration of a job.
+func fingerprint(j *pipelineJob) (string, error) {
+	fi, err := os.Stat(j.Model)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(struct {
+		Size    int64
+		ModTime time.Time
+		Ratios  []polyreduce.LayerRatio
+		Faces   int
+	}{fi.Size(), fi.ModTime().UTC(), j.Ratios, j.Faces})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// The steps of a job, in the order they are completed.
+const (
+	stepNone       = ""
+	stepUploaded   = "uploaded"
+	stepConfigured = "configured"
+	stepReduced    = "reduced"
+	stepDone       = "done"
+)
+
+// jobState is the persisted state of a job.
+type jobState struct {
+	// Step is the last completed step.
+	Step        string             `json:"step"`
+	Fingerprint string             `json:"fingerprint"`
+	ModelID     string             `json:"model_id,omitempty"`
+	Config      map[string]float64 `json:"config,omitempty"`
+	Faces       int                `json:"faces,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	Attempts    int                `json:"attempts"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// pipelineState is the state of all jobs of a pipeline by their output,
+// which is saved after every step.
+type pipelineState struct {
+	path string
+	mu   sync.Mutex
+	Jobs map[string]*jobState `json:"jobs"`
+}
+
+func loadPipelineState(path string) (*pipelineState, error) {
+	s := &pipelineState{path: path, Jobs: map[string]*jobState{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pipeline state: %w", err)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("cannot parse pipeline state %s: %w", path, err)
+	}
+	if s.Jobs == nil {
+		s.Jobs = map[string]*jobState{}
+	}
+	return s, nil
+}
+
+// update changes the state of a job and saves the states of all jobs.
+func (s *pipelineState) update(output string, f func(js *jobState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	js, ok := s.Jobs[output]
+	if !ok {
+		js = &jobState{}
+		s.Jobs[output] = js
+	}
+	f(js)
+	js.UpdatedAt = time.Now().UTC()
+
+	if err := polyreduce.WriteJSONFile(s.path, s); err != nil {
+		log.Printf("failed to save pipeline state: %v", err)
+	}
+}
+
+// limiter limits the rate of events, a nil limiter allows any rate.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next event is allowed.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	t := l.next
+	if t.Before(now) {
+		t = now
+	}
+	l.next = t.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pipeline runs the jobs of a manifest.
+type pipeline struct {
+	c     *polyreduce.Client
+	state *pipelineState
+	limit *limiter
+}
+
+// run runs the remaining steps of a job. Every completed step is saved,
+// and a job whose model is gone from the service starts over.
+func (p *pipeline) run(ctx context.Context, j *pipelineJob) (skipped bool, err error) {
+	var js jobState
+	p.state.update(j.Output, func(s *jobState) {
+		if s.Fingerprint != j.Fingerprint {
+			*s = jobState{Fingerprint: j.Fingerprint}
+		}
+		if s.Step == stepDone {
+			if _, err := os.Stat(j.Output); err != nil {
+				s.Step = stepReduced
+			}
+		}
+		if s.Step != stepDone {
+			s.Attempts++
+		}
+		js = *s
+	})
+	if js.Step == stepDone {
+		return true, nil
+	}
+
+	err = p.steps(ctx, j, &js)
+	p.state.update(j.Output, func(s *jobState) {
+		*s = js
+		s.Error = ""
+		if err != nil {
+			s.Error = err.Error()
+			if errors.Is(err, polyreduce.ErrNotFound) {
+				s.Step, s.ModelID = stepNone, ""
+			}
+		}
+	})
+	return false, err
+}
+
+// steps runs the steps of a job after the last completed step.
+func (p *pipeline) steps(ctx context.Context, j *pipelineJob, js *jobState) error {
+	return reduce(ctx, p.c, p.limit, j, js, func() {
+		p.state.update(j.Output, func(s *jobState) { *s = *js })
+	})
+}
+
+// reduce uploads, configs, runs and downloads the model of a job,
+// starting after the last completed step of the state. The state is
+// saved after every step, and the steps are recorded in the local
+// history. Every step waits for the limiter before it calls the
+// service, and a nil limiter, as used by the watch command, does not
+// limit the rate.
+//
+// The model is reused if it was uploaded before, hence it may be shared
+// with other jobs. Since a model has a single configuration, the steps
+// of a model are serialized, and a resumed job configures the model
+// again.
+func reduce(ctx context.Context, c *polyreduce.Client, limit *limiter, j *pipelineJob, js *jobState, save func()) error {
+	if js.Step == stepNone {
+		if err := limit.wait(ctx); err != nil {
+			return err
+		}
+		r, err := uploadModel(ctx, c, j.Model, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to upload: %w", err)
+		}
+		js.ModelID = r.ModelID
+		js.Step = stepUploaded
+		save()
+	}
+
+	unlock := lockModel(js.ModelID)
+	defer unlock()
+	if js.Step == stepConfigured || js.Step == stepReduced {
+		// Another job may have reconfigured the model since.
+		js.Step = stepUploaded
+	}
+	if js.Step == stepUploaded {
+		ratios := j.Ratios
+		if j.Faces > 0 {
+			m, err := mesh.Load(j.Model)
+			if err != nil {
+				return fmt.Errorf("cannot count the faces of the model: %w", err)
+			}
+			ratio := 100.0
+			if n := m.Faces(); n > j.Faces {
+				ratio = 100 * float64(j.Faces) / float64(n)
+			}
+			ratios = append([]polyreduce.LayerRatio{{Pattern: "*", Ratio: ratio}}, ratios...)
+		}
+		if err := limit.wait(ctx); err != nil {
+			return err
+		}
+		config, err := resolveLayers(ctx, c, js.ModelID, j.Model, ratios)
+		if err != nil {
+			return err
+		}
+		if err := limit.wait(ctx); err != nil {
+			return err
+		}
+		err = c.PolyredConfig(ctx, &polyreduce.PolyredConfigInput{ModelID: js.ModelID, ReductionRatio: config})
+		if err != nil {
+			return fmt.Errorf("failed to config: %w", err)
+		}
+		recordHistory(c, &historyEvent{Kind: kindModel, ID: js.ModelID, Action: "config", Config: config})
+		js.Config = config
+		js.Step = stepConfigured
+		save()
+	}
+	if js.Step == stepConfigured {
+		if err := limit.wait(ctx); err != nil {
+			return err
+		}
+		if err := c.PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: js.ModelID}); err != nil {
+			return fmt.Errorf("failed to run: %w", err)
+		}
+		recordHistory(c, &historyEvent{Kind: kindModel, ID: js.ModelID, Action: "run"})
+		js.Step = stepReduced
+		save()
+	}
+	if err := os.MkdirAll(filepath.Dir(j.Output), 0755); err != nil {
+		return err
+	}
+	if err := limit.wait(ctx); err != nil {
+		return err
+	}
+	_, err := c.PolyredDownload(ctx, &polyreduce.DownloadInput{ModelID: js.ModelID, Path: j.Output})
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	recordHistory(c, &historyEvent{Kind: kindModel, ID: js.ModelID, Action: "download", Output: j.Output})
+	if m, err := mesh.Load(j.Output); err == nil {
+		js.Faces = m.Faces()
+	}
+	js.Step = stepDone
+	save()
+	return nil
+}
+
+// modelLocks are the locks of the models that are reduced by jobs.
+var (
+	modelLocksMu sync.Mutex
+	modelLocks   = map[string]*sync.Mutex{}
+)
+
+// lockModel locks a model until the returned function is called.
+func lockModel(id string) (unlock func()) {
+	modelLocksMu.Lock()
+	mu, ok := modelLocks[id]
+	if !ok {
+		mu = &sync.Mutex{}
+		modelLocks[id] = mu
+	}
+	modelLocksMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// pipelineResult is the result of a job of the pipeline run command.
+type pipelineResult struct {
+	Model   string             `json:"model"`
+	Output  string             `json:"output"`
+	Status  string             `json:"status"`
+	ModelID string             `json:"model_id,omitempty"`
+	Config  map[string]float64 `json:"config,omitempty"`
+	Faces   int                `json:"faces,omitempty"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// pipelineReport is the summary report of the pipeline run command.
+type pipelineReport struct {
+	State    string            `json:"state"`
+	Total    int               `json:"total"`
+	Done     int               `json:"done"`
+	Skipped  int               `json:"skipped"`
+	Failed   int               `json:"failed"`
+	Duration string            `json:"duration"`
+	Jobs     []*pipelineResult `json:"jobs"`
+}
+
+// The statuses of a job in the report.
+const (
+	statusDone    = "done"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+)
+
+func PipelineRun(cmd *cobra.Command, args []string, f *pipelineFlags) error {
+	m, jobs, err := readPipeline(args[0])
+	if err != nil {
+		return &usageError{err}
+	}
+	flags := cmd.Flags()
+	if flags.Changed("workers") {
+		m.Workers = f.workers
+	}
+	if flags.Changed("rate") {
+		m.Rate = f.rate
+	}
+	if flags.Changed("state") {
+		m.State = f.state
+	}
+	if m.Workers < 1 {
+		m.Workers = 2
+	}
+	state, err := loadPipelineState(m.State)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	p := &pipeline{c: newClient(), state: state, limit: newLimiter(m.Rate)}
+	start := time.Now()
+	report := &pipelineReport{State: m.State, Total: len(jobs), Jobs: make([]*pipelineResult, len(jobs))}
+
+	mu := sync.Mutex{}
+	finished := 0
+	next := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < m.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				j := jobs[i]
+				skipped, err := p.run(ctx, j)
+
+				state.mu.Lock()
+				js := *state.Jobs[j.Output]
+				state.mu.Unlock()
+				r := &pipelineResult{
+					Model:   j.Model,
+					Output:  j.Output,
+					Status:  statusDone,
+					ModelID: js.ModelID,
+					Config:  js.Config,
+					Faces:   js.Faces,
+				}
+
+				mu.Lock()
+				finished++
+				switch {
+				case err != nil:
+					r.Status, r.Error = statusFailed, err.Error()
+					report.Failed++
+					log.Printf("[%d/%d] %s failed: %v", finished, len(jobs), j.Model, err)
+				case skipped:
+					r.Status = statusSkipped
+					report.Skipped++
+					log.Printf("[%d/%d] %s is already done: %s", finished, len(jobs), j.Model, j.Output)
+				default:
+					report.Done++
+					log.Printf("[%d/%d] %s is reduced to %d faces: %s", finished, len(jobs), j.Model, js.Faces, j.Output)
+				}
+				report.Jobs[i] = r
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	err = printResult(cmd, report, func(w io.Writer) {
+		fmt.Fprintf(w, "%d jobs: %d done, %d skipped, %d failed in %s\n", report.Total, report.Done, report.Skipped, report.Failed, report.Duration)
+		for _, r := range report.Jobs {
+			if r.Status == statusFailed {
+				fmt.Fprintf(w, "  %s: %s\n", r.Model, r.Error)
+			}
+		}
+		fmt.Fprintf(w, "state is saved to: %s\n", report.State)
+	})
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", report.Failed, report.Total)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestPipelineRun(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"Body", "Wheel"}
+
+	dir := t.TempDir()
+	b, err := os.ReadFile(testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"assets/car.fbx", "assets/props/a.fbx", "assets/props/b.fbx"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := filepath.Join(dir, "pipeline.yaml")
+	err = os.WriteFile(manifest, []byte(`rate: 100
+output: reduced
+jobs:
+  - model: assets/car.fbx
+    output: reduced/car_50.fbx
+    all: 50
+    layers:
+      Wheel: 30
+  - model: assets/props/*.fbx
+    output: reduced/props
+    faces: 1000
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first reduction fails, and the other jobs continue.
+	s.Inject(polyreducetest.RoutePolyredSubmitRun, polyreducetest.Fault{Status: http.StatusBadRequest, Times: 1})
+	out, err := execute(t, s, "pipeline", "run", manifest, "--workers", "1")
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 jobs failed") {
+		t.Fatalf("the pipeline should report the failed job, got: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "3 jobs: 2 done, 0 skipped, 1 failed") {
+		t.Fatalf("the pipeline should print a summary, got:\n%s", out)
+	}
+	state, err := loadPipelineState(filepath.Join(dir, "pipeline.state.json"))
+	if err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	car := state.Jobs[filepath.Join(dir, "reduced/car_50.fbx")]
+	if car == nil || car.Step != stepConfigured || car.Error == "" {
+		t.Fatalf("the failed job should be saved as configured, got: %+v", car)
+	}
+	want := map[string]float64{"Body": 50, "Wheel": 30}
+	if len(car.Config) != 2 || car.Config["Body"] != want["Body"] || car.Config["Wheel"] != want["Wheel"] {
+		t.Fatalf("want config %v, got %v", want, car.Config)
+	}
+
+	// The second run only continues the failed job.
+	out, err = execute(t, s, "pipeline", "run", manifest, "-o", "json")
+	if err != nil {
+		t.Fatalf("failed to run pipeline: %v\n%s", err, out)
+	}
+	var r pipelineReport
+	if err := json.Unmarshal([]byte(out[strings.Index(out, "{"):]), &r); err != nil {
+		t.Fatalf("the pipeline should print JSON, got %q: %v", out, err)
+	}
+	if r.Total != 3 || r.Done != 1 || r.Skipped != 2 || r.Failed != 0 {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+	for _, j := range r.Jobs {
+		if _, err := os.Stat(j.Output); err != nil {
+			t.Fatalf("missing output of %s: %v", j.Model, err)
+		}
+		if j.Faces != 7872 {
+			t.Fatalf("want 7872 faces of %s, got %d", j.Output, j.Faces)
+		}
+	}
+	if got := r.Jobs[1].Config["Body"]; got < 12.7 || got > 12.71 {
+		t.Fatalf("a target of 1000 faces should be a ratio of 12.70, got %v", got)
+	}
+
+	for _, m := range []string{
+		"jobs:\n  - model: assets/missing.fbx\n    all: 50\n",
+		"jobs:\n  - model: assets/car.fbx\n",
+		"jobs:\n  - model: assets/props/*.fbx\n    output: out.fbx\n    all: 50\n",
+		"jobs:\n  - model: assets/car.fbx\n    all: 50\n    faces: 100\n",
+	} {
+		if err := os.WriteFile(manifest, []byte(m), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := execute(t, s, "pipeline", "run", manifest); ExitCode(err) != ExitUsage {
+			t.Fatalf("manifest %q should fail with a usage error, got: %v", m, err)
+		}
+	}
+}
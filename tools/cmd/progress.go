@@ -0,0 +1,145 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+)
+
+// isTerminal reports whether the given file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar renders the progress of a transfer in a single line.
+type progressBar struct {
+	w     io.Writer
+	label string
+	start time.Time
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// newProgressBar returns a progress bar that renders to stdout if stdout
+// is a terminal, or nil otherwise. A nil progress bar renders nothing.
+func newProgressBar(label string) *progressBar {
+	if !isTerminal(os.Stdout) {
+		return nil
+	}
+	return &progressBar{w: os.Stdout, label: label, start: time.Now()}
+}
+
+// Func returns the progress bar as a progress function, or nil if the
+// progress bar is nil.
+func (p *progressBar) Func() polyreduce.ProgressFunc {
+	if p == nil {
+		return nil
+	}
+	return p.update
+}
+
+const progressBarWidth = 30
+
+func (p *progressBar) update(done, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.last) < 100*time.Millisecond && done != total {
+		return
+	}
+	p.last = now
+
+	if total <= 0 {
+		fmt.Fprintf(p.w, "\r%s %s", p.label, formatBytes(done))
+		return
+	}
+
+	frac := float64(done) / float64(total)
+	if frac > 1 {
+		frac = 1
+	}
+	n := int(frac * progressBarWidth)
+	bar := strings.Repeat("=", n) + strings.Repeat(" ", progressBarWidth-n)
+
+	eta := "--"
+	if elapsed := now.Sub(p.start); done > 0 && done < total {
+		rest := time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+		eta = rest.Round(time.Second).String()
+	} else if done >= total {
+		eta = "0s"
+	}
+	fmt.Fprintf(p.w, "\r%s [%s] %3.0f%% %s/%s ETA %s\033[K",
+		p.label, bar, frac*100, formatBytes(done), formatBytes(total), eta)
+}
+
+// Done terminates the line of the progress bar.
+func (p *progressBar) Done() {
+	if p == nil {
+		return
+	}
+	fmt.Fprintln(p.w)
+}
+
+// spin renders a spinner with the elapsed time to stdout until the
+// returned function is called. Nothing is rendered if stdout is not a
+// terminal.
+func spin(label string) (stop func()) {
+	if !isTerminal(os.Stdout) {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		frames := `|/-\`
+		start := time.Now()
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		for i := 0; ; i++ {
+			elapsed := time.Since(start).Round(time.Second)
+			fmt.Fprintf(os.Stdout, "\r%s %c %s\033[K", label, frames[i%len(frames)], elapsed)
+			select {
+			case <-done:
+				fmt.Fprintf(os.Stdout, "\r%s done in %s\033[K\n", label, elapsed)
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// formatBytes formats a number of bytes in a human readable form.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
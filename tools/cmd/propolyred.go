@@ -0,0 +1,317 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+)
+
+// addPropolyredCmds adds the commands of the plain propolyred API to the
+// session command group.
+func addPropolyredCmds(sessionCmd *cobra.Command) {
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "upload [path_to_model]",
+		Short: "Upload .fbx model and create a new session",
+		Args:  cobra.ExactArgs(1),
+		RunE:  SessionUpload,
+	})
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "run [session_id]",
+		Short: "Run the next phase of a session",
+		Args:  cobra.ExactArgs(1),
+		RunE:  SessionRun,
+	})
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "inspect [session_id]",
+		Short: "List the variants of a session that are not evaluated",
+		Args:  cobra.ExactArgs(1),
+		RunE:  SessionInspect,
+	})
+	var ratingFile string
+	evaluateCmd := &cobra.Command{
+		Use:   "evaluate [session_id] [model_id=rating]...",
+		Short: "Rate the variants of the current phase of a session",
+		Long: `Rate the variants of the current phase of a session.
+
+A variant is referred by its model ID or its number in the phase, and
+a rating is either a label, e.g. good, or a number from 0 to 5. The
+ratings can also be read from a JSON file that maps model IDs to
+ratings, e.g. {"<model_id>": "good"}.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return SessionEvaluate(cmd, args, ratingFile)
+		},
+	}
+	evaluateCmd.Flags().StringVarP(&ratingFile, "file", "f", "", "JSON file of the ratings")
+	sessionCmd.AddCommand(evaluateCmd)
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "reset [session_id]",
+		Short: "Reset a session to its root model",
+		Args:  cobra.ExactArgs(1),
+		RunE:  SessionReset,
+	})
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "copy [session_id]",
+		Short: "Copy a session into a new session",
+		Args:  cobra.ExactArgs(1),
+		RunE:  SessionCopy,
+	})
+	var all bool
+	downloadCmd := &cobra.Command{
+		Use:   "download [session_id] [model_id] [path_to_save]",
+		Short: "Download a model of a session",
+		Long: `Download a model of a session.
+
+With --all, the root model and the variants of all phases are saved
+as <model_id>.fbx to the given directory:
+
+  download --all [session_id] [directory]`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all {
+				if len(args) != 2 {
+					return &usageError{fmt.Errorf("expect a session ID and a directory, got %d arguments", len(args))}
+				}
+				return SessionDownloadAll(cmd, args)
+			}
+			if len(args) != 3 {
+				return &usageError{fmt.Errorf("expect a session ID, a model ID and a path, got %d arguments", len(args))}
+			}
+			return SessionDownload(cmd, args)
+		},
+	}
+	downloadCmd.Flags().BoolVar(&all, "all", false, "download all models of the session")
+	sessionCmd.AddCommand(downloadCmd)
+}
+
+// sessionResult is the result of a command on a session.
+type sessionResult struct {
+	SessionID string `json:"session_id"`
+	// Source is the copied session of a copy.
+	Source  string `json:"source,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func SessionUpload(cmd *cobra.Command, args []string) error {
+	c := newClient()
+	bar := newProgressBar("uploading")
+	o, err := c.ProPolyredUpload(context.Background(), &polyreduce.ProPolyredUploadInput{
+		ModelPath: args[0],
+		Progress:  bar.Func(),
+	})
+	bar.Done()
+	if err != nil {
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+	sum, _ := hashFile(args[0])
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: o.SessionId, Action: "upload", Source: args[0], SHA256: sum})
+
+	return printResult(cmd, &sessionResult{SessionID: o.SessionId, Message: o.Message}, func(w io.Writer) {
+		fmt.Fprintln(w, o.Message)
+		fmt.Fprintln(w, o.SessionId)
+	})
+}
+
+// phaseResult is the result of a run phase of a session.
+type phaseResult struct {
+	SessionID      string   `json:"session_id"`
+	IDs            []string `json:"ids"`
+	AssumedOptimal float64  `json:"optimal"`
+}
+
+func SessionRun(cmd *cobra.Command, args []string) error {
+	c := newClient()
+	stop := spin("running")
+	o, err := c.ProPolyredRun(context.Background(), &polyreduce.ProPolyredRunInput{SessionId: args[0]})
+	stop()
+	if err != nil {
+		return err
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: args[0], Action: "run", IDs: o.Phases})
+
+	r := &phaseResult{SessionID: args[0], IDs: o.Phases, AssumedOptimal: o.AssumedOptimal}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "phase is complete, assumed optimal: %v\n", o.AssumedOptimal)
+		for _, id := range o.Phases {
+			fmt.Fprintln(w, id)
+		}
+	})
+}
+
+// inspectResult is the result of the session inspect command.
+type inspectResult struct {
+	SessionID   string   `json:"session_id"`
+	Unevaluated []string `json:"unevaluated"`
+}
+
+func SessionInspect(cmd *cobra.Command, args []string) error {
+	c := newClient()
+	o, err := c.ProPolyredInspect(context.Background(), &polyreduce.ProPolyredInspectInput{SessionId: args[0]})
+	if err != nil {
+		return fmt.Errorf("failed to inspect: %w", err)
+	}
+
+	r := &inspectResult{SessionID: args[0], Unevaluated: o.Unevaluated}
+	if r.Unevaluated == nil {
+		r.Unevaluated = []string{}
+	}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "%d unevaluated variants\n", len(o.Unevaluated))
+		for _, id := range o.Unevaluated {
+			fmt.Fprintln(w, id)
+		}
+	})
+}
+
+// evaluateResult is the result of an evaluated phase of a session.
+type evaluateResult struct {
+	SessionID string                       `json:"session_id"`
+	Phase     int                          `json:"phase"`
+	Ratings   map[string]polyreduce.Rating `json:"ratings"`
+	Left      int                          `json:"left"`
+}
+
+func SessionEvaluate(cmd *cobra.Command, args []string, file string) error {
+	sid := args[0]
+	ctx := context.Background()
+	c := newClient()
+
+	s := c.Session(sid)
+	if err := s.Refresh(ctx); err != nil {
+		return fmt.Errorf("failed to evaluate: %w", err)
+	}
+	p := s.Current()
+	if p == nil {
+		return fmt.Errorf("session %s has no phase to evaluate", sid)
+	}
+
+	rating := map[string]polyreduce.Rating{}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return &usageError{fmt.Errorf("cannot read ratings: %w", err)}
+		}
+		if err := json.Unmarshal(b, &rating); err != nil {
+			return &usageError{fmt.Errorf("cannot parse ratings of %s: %w", file, err)}
+		}
+	}
+	more, err := parseRatings(args[1:], p.IDs)
+	if err != nil {
+		return err
+	}
+	for id, r := range more {
+		rating[id] = r
+	}
+
+	if err := s.Rate(ctx, rating); err != nil {
+		return err
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: sid, Action: "evaluate"})
+	r := &evaluateResult{SessionID: sid, Phase: len(s.Phases), Ratings: rating, Left: len(p.IDs) - len(p.Ratings)}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "%d variants are evaluated, %d variants of phase %d are left\n", len(rating), r.Left, r.Phase)
+	})
+}
+
+func SessionReset(cmd *cobra.Command, args []string) error {
+	c := newClient()
+	o, err := c.ProPolyredReset(context.Background(), &polyreduce.ProPolyredResetInput{SessionId: args[0]})
+	if err != nil {
+		return fmt.Errorf("failed to reset: %w", err)
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: args[0], Action: "reset"})
+
+	return printResult(cmd, &sessionResult{SessionID: args[0], Message: o.Message}, func(w io.Writer) {
+		fmt.Fprintln(w, o.Message)
+	})
+}
+
+func SessionCopy(cmd *cobra.Command, args []string) error {
+	c := newClient()
+	o, err := c.ProPolyredCopy(context.Background(), &polyreduce.ProPolyredCopyInput{SessionId: args[0]})
+	if err != nil {
+		return fmt.Errorf("failed to copy: %w", err)
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: o.SessionId, Action: "copy", Parent: args[0]})
+
+	return printResult(cmd, &sessionResult{SessionID: o.SessionId, Source: args[0], Message: o.Message}, func(w io.Writer) {
+		fmt.Fprintln(w, o.Message)
+		fmt.Fprintln(w, o.SessionId)
+	})
+}
+
+func SessionDownload(cmd *cobra.Command, args []string) error {
+	c := newClient()
+	bar := newProgressBar("downloading")
+	o, err := c.ProPolyredDownload(context.Background(), &polyreduce.ProPolyredDownloadInput{
+		SessionId: args[0],
+		PhaseId:   args[1],
+		Path:      args[2],
+		Progress:  bar.Func(),
+	})
+	bar.Done()
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: args[0], Action: "download", Output: o.Path})
+
+	r := &downloadResult{ModelID: args[1], Path: o.Path, Size: o.Size, SHA256: o.SHA256}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "model is saved to: %s (%d bytes, sha256: %s)\n", o.Path, o.Size, o.SHA256)
+	})
+}
+
+// downloadAllResult is the result of the session download --all command.
+type downloadAllResult struct {
+	SessionID string            `json:"session_id"`
+	Dir       string            `json:"dir"`
+	Models    []*downloadResult `json:"models"`
+}
+
+func SessionDownloadAll(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	dir := args[1]
+	c := newClient()
+
+	s := c.Session(args[0])
+	if err := s.Refresh(ctx); err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	r := &downloadAllResult{SessionID: s.ID, Dir: dir}
+	ids := []string{s.ID}
+	for _, p := range s.Phases {
+		ids = append(ids, p.IDs...)
+	}
+	for i, id := range ids {
+		bar := newProgressBar(fmt.Sprintf("downloading %d/%d", i+1, len(ids)))
+		o, err := c.ProPolyredDownload(ctx, &polyreduce.ProPolyredDownloadInput{
+			SessionId: s.ID,
+			PhaseId:   id,
+			Path:      filepath.Join(dir, id+".fbx"),
+			Progress:  bar.Func(),
+		})
+		bar.Done()
+		if err != nil {
+			return fmt.Errorf("failed to download model %s: %w", id, err)
+		}
+		r.Models = append(r.Models, &downloadResult{ModelID: id, Path: o.Path, Size: o.Size, SHA256: o.SHA256})
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: s.ID, Action: "download", Output: dir})
+
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "%d models are saved to: %s\n", len(ids), dir)
+	})
+}
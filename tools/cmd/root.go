@@ -0,0 +1,139 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/cassette"
+	"github.com/spf13/cobra"
+)
+
+// clientOptions are applied to every client that is created by commands.
+var clientOptions []polyreduce.Option
+
+// flagOptions are the client options derived from the flags of the root
+// command. They are applied after clientOptions.
+var flagOptions []polyreduce.Option
+
+// newClient creates a polyreduce client for a command. The given options
+// are applied last.
+func newClient(extra ...polyreduce.Option) *polyreduce.Client {
+	opts := append(append([]polyreduce.Option(nil), clientOptions...), flagOptions...)
+	return polyreduce.NewClient(append(opts, extra...)...)
+}
+
+// setup configures the clients and the output of a command from the
+// configuration file, the environment and the flags.
+func setup(cmd *cobra.Command, f *rootFlags) error {
+	flagOptions = nil
+	outputFormat = outputText
+	s, err := f.resolve(cmd, os.Getenv)
+	if err != nil {
+		return &configError{err}
+	}
+	outputFormat = s.Output
+	flagOptions = s.options()
+	useHistory, reuseUploads = true, true
+	return setupCassette(f.record, f.replay)
+}
+
+// setupCassette records or replays all interactions with the service
+// if requested by the flags. A cassette must reproduce the interactions
+// of a command, hence uploads are not deduplicated, and replayed
+// interactions are not recorded into the local history.
+func setupCassette(record, replay string) error {
+	switch {
+	case record != "" && replay != "":
+		return &usageError{errors.New("--record and --replay cannot be used together")}
+	case record != "":
+		flagOptions = append(flagOptions, polyreduce.WithTransport(cassette.NewRecorder(record, nil)))
+		reuseUploads = false
+	case replay != "":
+		rep, err := cassette.NewReplayer(replay)
+		if err != nil {
+			return err
+		}
+		// A replayed interaction never changes, hence retries are useless.
+		flagOptions = append(flagOptions,
+			polyreduce.WithTransport(rep),
+			polyreduce.WithRetryPolicy(polyreduce.NoRetry),
+		)
+		useHistory, reuseUploads = false, false
+	}
+	return nil
+}
+
+// New creates the root command of the command line tool.
+func New() *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "polyred",
+		Short: "A polygon reduction service",
+		Long: fmt.Sprintf(`The command line tool of polygon reduction service.
+
+Version:     %s`, polyreduce.ClientVersion),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	f := &rootFlags{}
+	f.register(rootCmd)
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return setup(cmd, f)
+	}
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "ping",
+		Short: "ping polyred service",
+		RunE:  Ping,
+	})
+	rootCmd.AddCommand(newDoctorCmd())
+	var force bool
+	uploadCmd := &cobra.Command{
+		Use:   "upload [path_to_model]",
+		Short: "Upload .fbx model to polyred service",
+		Long: `Upload .fbx model to polyred service.
+
+If the same file was uploaded to the service before, according to the
+local history, the model ID of the previous upload is reused.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Upload(cmd, args, force)
+		},
+	}
+	uploadCmd.Flags().BoolVar(&force, "force", false, "upload even if the file was uploaded before")
+	rootCmd.AddCommand(uploadCmd)
+	rootCmd.AddCommand(newConfigCmd())
+	rootCmd.AddCommand(&cobra.Command{
+		Use:               "run [id]",
+		Short:             "Trigger polygon reduction to specific model",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeIDs(kindModel),
+		RunE:              Run,
+	})
+	rootCmd.AddCommand(&cobra.Command{
+		Use:               "download [id] [path_to_save]",
+		Short:             "Download simplified model from polyred service",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeIDs(kindModel),
+		RunE:              Download,
+	})
+	rootCmd.AddCommand(newSessionCmd())
+	rootCmd.AddCommand(newSweepCmd())
+	rootCmd.AddCommand(newPipelineCmd())
+	rootCmd.AddCommand(newWatchCmd())
+	rootCmd.AddCommand(newLsCmd())
+	rootCmd.AddCommand(&cobra.Command{
+		Use:               "show [id]",
+		Short:             "Show a model or session of the local history",
+		Long:              "Show a model or session of the local history. The ID can be abbreviated to a unique prefix.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeIDs(""),
+		RunE:              Show,
+	})
+	markUsageErrors(rootCmd)
+	return rootCmd
+}
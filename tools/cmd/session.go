@@ -0,0 +1,440 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+)
+
+// newSessionCmd creates the command group of propolyred sessions.
+func newSessionCmd() *cobra.Command {
+	sessionCmd := &cobra.Command{
+		Use:   "session",
+		Short: "Optimize the reduction of a model in a propolyred session",
+	}
+	addPropolyredCmds(sessionCmd)
+	sessionCmd.AddCommand(newSessionLoopCmd())
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "history [session_id]",
+		Short: "List the evaluations of all phases of a session",
+		Args:  cobra.ExactArgs(1),
+		RunE:  SessionHistory,
+	})
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "amend [session_id] [phase] [model_id=rating]...",
+		Short: "Replace the ratings of a past phase of a session",
+		Long: `Replace the ratings of a past phase of a session.
+
+A variant is referred by its model ID or its number in the phase, and
+a rating is either a label, e.g. good, or a number from 0 to 5.`,
+		Args: cobra.MinimumNArgs(3),
+		RunE: SessionAmend,
+	})
+	var pullDir string
+	pullCmd := &cobra.Command{
+		Use:   "pull [session_id]",
+		Short: "Download a session into the layout of the dataset",
+		Long: `Download a session into the layout of the dataset:
+
+  <dir>/<session_id>/base.json
+  <dir>/<session_id>/<model_id>.json
+  <dir>/<session_id>/<model_id>.fbx
+
+Models that were already downloaded are not downloaded again.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return SessionPull(cmd, args, pullDir)
+		},
+	}
+	pullCmd.Flags().StringVar(&pullDir, "dir", ".", "directory of all sessions, e.g. dataset/sessions")
+	sessionCmd.AddCommand(pullCmd)
+	sessionCmd.AddCommand(&cobra.Command{
+		Use:   "retract [session_id] [phase]",
+		Short: "Retract the ratings of a past phase of a session",
+		Args:  cobra.ExactArgs(2),
+		RunE:  SessionRetract,
+	})
+	// Complete the session IDs of all commands on an existing session.
+	for _, c := range sessionCmd.Commands() {
+		if args := strings.Fields(c.Use); len(args) > 1 && args[1] == "[session_id]" {
+			c.ValidArgsFunction = completeIDs(kindSession)
+		}
+	}
+	return sessionCmd
+}
+
+// loopFlags are the flags of the session loop command.
+type loopFlags struct {
+	mode          string
+	maxIterations int
+	tolerance     float64
+	dir           string
+	state         string
+	events        string
+}
+
+func newSessionLoopCmd() *cobra.Command {
+	f := &loopFlags{}
+	loopCmd := &cobra.Command{
+		Use:   "loop [path_to_model]",
+		Short: "Rate the variants of a new session phase by phase",
+		Long: `Rate the variants of a new session phase by phase.
+
+Every phase shows the variants with their reduction configuration and
+mesh statistics against the root model: the number of faces, the
+achieved reduction ratio and the geometric error, which is the
+Hausdorff distance of the vertices relative to the size of the model.
+
+The variants are evaluated with the keyboard, e.g. 5.32 rates four
+variants Excellent, unrated, Fair and Poor. The session can be stopped
+with q, reset with r or forked with f. All events of the loop are
+appended to a local event log.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return SessionLoop(cmd, args, f)
+		},
+	}
+	modes := []string{}
+	for _, m := range polyreduce.EvaluationModes() {
+		modes = append(modes, string(m))
+	}
+	loopCmd.Flags().StringVar(&f.mode, "mode", string(polyreduce.ModeScore), "evaluation mode, one of "+strings.Join(modes, ", "))
+	loopCmd.Flags().IntVar(&f.maxIterations, "max-iterations", 10, "maximum number of rated phases, 0 means no limit")
+	loopCmd.Flags().Float64Var(&f.tolerance, "tolerance", 0, "stop once the assumed optimal ratio changes by at most the tolerance")
+	loopCmd.Flags().StringVar(&f.dir, "dir", "", "directory of the downloaded variants, a temporary directory if empty")
+	loopCmd.Flags().StringVar(&f.state, "state", "", "file that saves the session after every phase, and resumes it if it exists")
+	loopCmd.Flags().StringVar(&f.events, "events", "", "file that the events of the loop are appended to, <session_id>.events.jsonl if empty")
+	return loopCmd
+}
+
+// stopQuit is the reason of a loop that is stopped from the keyboard.
+const stopQuit polyreduce.StopReason = "quit"
+
+func SessionLoop(cmd *cobra.Command, args []string, f *loopFlags) error {
+	mode, err := polyreduce.ParseEvaluationMode(f.mode)
+	if err != nil {
+		return &usageError{err}
+	}
+
+	ctx := context.Background()
+	c := newClient()
+	s, err := loadSession(ctx, c, args[0], f.state)
+	if err != nil {
+		return err
+	}
+	log.Printf("session: %s", s.ID)
+
+	path := f.events
+	if path == "" {
+		path = s.ID + ".events.jsonl"
+	}
+	events, err := openEventLog(path)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+	events.record(&loopEvent{Event: "start", Session: s.ID, Model: args[0]})
+
+	// The screen must not mix with the result in the JSON format.
+	screen := cmd.OutOrStdout()
+	if outputFormat == outputJSON {
+		screen = cmd.ErrOrStderr()
+	}
+	rater := newTerminalRater(c, mode, cmd.InOrStdin(), screen, events)
+	// The runs and downloads of the optimizer are recorded when the
+	// variants of a phase are rated for the first time.
+	presented := map[string]bool{}
+	recordPhase := polyreduce.RaterFunc(func(ctx context.Context, s *polyreduce.Session, variants []polyreduce.Variant) (polyreduce.Evaluation, error) {
+		if len(variants) > 0 && !presented[variants[0].ID] {
+			presented[variants[0].ID] = true
+			ids := make([]string, len(variants))
+			for i, v := range variants {
+				ids[i] = v.ID
+			}
+			recordHistory(c, &historyEvent{Kind: kindSession, ID: s.ID, Action: "run", IDs: ids})
+			if variants[0].Path != "" {
+				recordHistory(c, &historyEvent{Kind: kindSession, ID: s.ID, Action: "download", Output: filepath.Dir(variants[0].Path)})
+			}
+		}
+		return rater.Rate(ctx, s, variants)
+	})
+	rated := 0
+	var reason polyreduce.StopReason
+	for {
+		o := &polyreduce.Optimizer{
+			Session:   s,
+			Rater:     recordPhase,
+			Dir:       f.dir,
+			Tolerance: f.tolerance,
+			Stop: func(s *polyreduce.Session, p *polyreduce.Phase) bool {
+				rated++
+				events.record(&loopEvent{
+					Event:   "evaluate",
+					Session: s.ID,
+					Phase:   len(s.Phases),
+					Mode:    p.Mode,
+					Ratings: p.Ratings,
+					Optimal: p.AssumedOptimal,
+				})
+				recordHistory(c, &historyEvent{Kind: kindSession, ID: s.ID, Action: "evaluate"})
+				log.Printf("phase %d is evaluated, assumed optimal: %v", len(s.Phases), p.AssumedOptimal)
+				saveSession(s, f.state)
+				return false
+			},
+		}
+		if f.maxIterations > 0 {
+			o.MaxIterations = f.maxIterations - rated
+			if o.MaxIterations <= 0 {
+				reason = polyreduce.StopMaxIterations
+				break
+			}
+		}
+
+		reason, err = o.Run(ctx)
+		var action loopAction
+		if !errors.As(err, &action) {
+			break
+		}
+		err = nil
+		if action == actionStop {
+			reason = stopQuit
+			break
+		}
+		if action == actionReset {
+			if err = s.Reset(ctx); err != nil {
+				break
+			}
+			events.record(&loopEvent{Event: "reset", Session: s.ID})
+			recordHistory(c, &historyEvent{Kind: kindSession, ID: s.ID, Action: "reset"})
+			log.Printf("session %s is reset", s.ID)
+		}
+		if action == actionFork {
+			fork, err := s.Fork(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to fork session %s: %w", s.ID, err)
+			}
+			events.record(&loopEvent{Event: "fork", Session: s.ID, Fork: fork.ID})
+			recordHistory(c, &historyEvent{Kind: kindSession, ID: fork.ID, Action: "copy", Parent: s.ID})
+			log.Printf("session %s is forked into %s", s.ID, fork.ID)
+			s = fork
+		}
+		saveSession(s, f.state)
+	}
+	saveSession(s, f.state)
+	if err != nil {
+		return fmt.Errorf("failed to optimize session %s: %w", s.ID, err)
+	}
+	events.record(&loopEvent{Event: "stop", Session: s.ID, Reason: reason, Optimal: lastOptimal(s)})
+
+	r := &loopResult{SessionID: s.ID, Reason: reason, Phases: len(s.Phases), AssumedOptimal: s.AssumedOptimal()}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "stopped (%s) after %d phases\n", reason, len(s.Phases))
+		fmt.Fprintf(w, "assumed optimal: %v\n", s.AssumedOptimal())
+	})
+}
+
+// loopResult is the result of the session loop command.
+type loopResult struct {
+	SessionID      string                `json:"session_id"`
+	Reason         polyreduce.StopReason `json:"reason"`
+	Phases         int                   `json:"phases"`
+	AssumedOptimal []float64             `json:"optimal"`
+}
+
+// lastOptimal returns the latest assumed optimal ratio of a session.
+func lastOptimal(s *polyreduce.Session) float64 {
+	h := s.AssumedOptimal()
+	if len(h) == 0 {
+		return 0
+	}
+	return h[len(h)-1]
+}
+
+func SessionHistory(cmd *cobra.Command, args []string) error {
+	c := newClient()
+	o, err := c.ProPolyredEvaluations(context.Background(), &polyreduce.ProPolyredEvaluationsInput{
+		SessionId: args[0],
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list evaluations: %w", err)
+	}
+
+	return printResult(cmd, o, func(w io.Writer) {
+		for _, p := range o.Phases {
+			mode := string(p.Mode)
+			if mode == "" {
+				mode = "unevaluated"
+			}
+			fmt.Fprintf(w, "phase %d (%s), assumed optimal: %v\n", p.Phase, mode, p.AssumedOptimal)
+			for i, id := range p.IDs {
+				r, ok := p.Ratings[id]
+				if !ok {
+					r = polyreduce.Unrated
+				}
+				fmt.Fprintf(w, "  [%d] %s: %v\n", i+1, id, r)
+			}
+		}
+		fmt.Fprintf(w, "assumed optimal: %v\n", o.AssumedOptimal)
+	})
+}
+
+// phaseChangeResult is the result of an amended or retracted phase.
+type phaseChangeResult struct {
+	SessionID      string  `json:"session_id"`
+	Phase          int     `json:"phase"`
+	AssumedOptimal float64 `json:"optimal"`
+}
+
+func SessionAmend(cmd *cobra.Command, args []string) error {
+	sid := args[0]
+	phase, err := strconv.Atoi(args[1])
+	if err != nil {
+		return &usageError{fmt.Errorf("cannot parse phase: %w", err)}
+	}
+
+	ctx := context.Background()
+	c := newClient()
+	h, err := c.ProPolyredEvaluations(ctx, &polyreduce.ProPolyredEvaluationsInput{SessionId: sid})
+	if err != nil {
+		return fmt.Errorf("failed to list evaluations: %w", err)
+	}
+	if phase < 1 || phase > len(h.Phases) {
+		return fmt.Errorf("session %s has no phase %d", sid, phase)
+	}
+	rating, err := parseRatings(args[2:], h.Phases[phase-1].IDs)
+	if err != nil {
+		return err
+	}
+
+	o, err := c.ProPolyredAmend(ctx, &polyreduce.ProPolyredAmendInput{
+		SessionId: sid,
+		Phase:     phase,
+		Rating:    rating,
+		Mode:      polyreduce.ModeScore,
+	})
+	if err != nil {
+		return err
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: sid, Action: "amend"})
+	r := &phaseChangeResult{SessionID: sid, Phase: phase, AssumedOptimal: o.AssumedOptimal}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "phase %d is amended, assumed optimal: %v\n", phase, o.AssumedOptimal)
+	})
+}
+
+func SessionRetract(cmd *cobra.Command, args []string) error {
+	phase, err := strconv.Atoi(args[1])
+	if err != nil {
+		return &usageError{fmt.Errorf("cannot parse phase: %w", err)}
+	}
+
+	c := newClient()
+	o, err := c.ProPolyredRetract(context.Background(), &polyreduce.ProPolyredRetractInput{
+		SessionId: args[0],
+		Phase:     phase,
+	})
+	if err != nil {
+		return err
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: args[0], Action: "retract"})
+	r := &phaseChangeResult{SessionID: args[0], Phase: phase, AssumedOptimal: o.AssumedOptimal}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "phase %d is retracted, assumed optimal: %v\n", phase, o.AssumedOptimal)
+	})
+}
+
+// pullResult is the result of the session pull command.
+type pullResult struct {
+	SessionID  string   `json:"session_id"`
+	Dir        string   `json:"dir"`
+	Downloaded []string `json:"downloaded"`
+	Existing   []string `json:"existing"`
+}
+
+func SessionPull(cmd *cobra.Command, args []string, dir string) error {
+	c := newClient()
+	stop := spin("pulling")
+	o, err := c.Session(args[0]).Sync(context.Background(), dir)
+	stop()
+	if err != nil {
+		return err
+	}
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: args[0], Action: "download", Output: o.Dir})
+
+	r := &pullResult{SessionID: args[0], Dir: o.Dir, Downloaded: o.Downloaded, Existing: o.Existing}
+	return printResult(cmd, r, func(w io.Writer) {
+		fmt.Fprintf(w, "session is saved to: %s (%d new models, %d existing models)\n", o.Dir, len(o.Downloaded), len(o.Existing))
+	})
+}
+
+// parseRatings parses ratings in the form of model_id=rating. A variant
+// may also be referred by its number in the given variants.
+func parseRatings(args, variants []string) (map[string]polyreduce.Rating, error) {
+	rating := map[string]polyreduce.Rating{}
+	for _, arg := range args {
+		id, label, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, &usageError{fmt.Errorf("invalid rating %q, expect model_id=rating", arg)}
+		}
+		if n, err := strconv.Atoi(id); err == nil && n >= 1 && n <= len(variants) {
+			id = variants[n-1]
+		}
+		r, err := polyreduce.ParseRating(label)
+		if err != nil {
+			return nil, &usageError{err}
+		}
+		rating[id] = r
+	}
+	return rating, nil
+}
+
+// loadSession resumes the session of the state file if it exists, or
+// uploads the model to start a new session otherwise.
+func loadSession(ctx context.Context, c *polyreduce.Client, model, state string) (*polyreduce.Session, error) {
+	if state != "" {
+		s, err := c.LoadSession(state)
+		if err == nil {
+			return s, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	bar := newProgressBar("uploading")
+	s, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{
+		ModelPath: model,
+		Progress:  bar.Func(),
+	})
+	bar.Done()
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload: %w", err)
+	}
+	sum, _ := hashFile(model)
+	recordHistory(c, &historyEvent{Kind: kindSession, ID: s.ID, Action: "upload", Source: model, SHA256: sum})
+	saveSession(s, state)
+	return s, nil
+}
+
+// saveSession saves the session to the state file, if any.
+func saveSession(s *polyreduce.Session, state string) {
+	if state == "" {
+		return
+	}
+	if err := s.Save(state); err != nil {
+		log.Printf("failed to save session: %v", err)
+	}
+}
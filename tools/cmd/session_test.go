@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestSessionLoop(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	dir := t.TempDir()
+	state := filepath.Join(dir, "session.json")
+	events := filepath.Join(dir, "events.jsonl")
+	args := []string{"session", "loop", testModel, "--events", events, "--mode", "ranking", "--max-iterations", "1", "--state", state}
+	out, err := executeInput(t, s, "4 3 2 1\n", args...)
+	if err != nil {
+		t.Fatalf("failed to run the loop: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "stopped (max iterations) after 1 phases") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	// Resume the session from the state file.
+	out, err = executeInput(t, s, "excellent . . .\n", append(args[:6], "score", "--max-iterations", "1", "--state", state)...)
+	if err != nil {
+		t.Fatalf("failed to resume the loop: %v\n%s", err, out)
+	}
+	c := s.Client()
+	ss, err := c.LoadSession(state)
+	if err != nil {
+		t.Fatalf("failed to load the state: %v", err)
+	}
+	snap, _ := s.Session(ss.ID)
+	if len(snap.Phases) != 2 || snap.Modes[0] != polyreduce.ModeRanking || snap.Modes[1] != polyreduce.ModeScore {
+		t.Fatalf("unexpected session: %+v", snap)
+	}
+
+	if _, err := executeInput(t, s, "", "session", "loop", testModel, "--mode", "vote"); err == nil {
+		t.Fatalf("an unknown mode should be rejected")
+	}
+}
+
+func TestSessionLoop_Actions(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	dir := t.TempDir()
+	state := filepath.Join(dir, "session.json")
+	events := filepath.Join(dir, "events.jsonl")
+	// Reject an answer without ratings, rate a phase, reject an invalid
+	// answer, reset the session, rate the new first phase, fork the
+	// session and stop the loop.
+	input := strings.Join([]string{"....", "5.32", "9999", "r", "y", "?", "1234", "f", "q"}, "\n") + "\n"
+	out, err := executeInput(t, s, input, "session", "loop", testModel, "--events", events, "--state", state)
+	if err != nil {
+		t.Fatalf("failed to run the loop: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"7872", "100.00%", "0.0000", // mesh statistics of the fake variants
+		"default=", // reduction configuration
+		"rate at least one variant",
+		"invalid rating \"9\" of variant 1",
+		"commands:",
+		"stopped (quit) after 2 phases",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("the output should contain %q, got:\n%s", want, out)
+		}
+	}
+
+	b, err := os.ReadFile(events)
+	if err != nil {
+		t.Fatalf("failed to read the event log: %v", err)
+	}
+	var kinds []string
+	var first, fork string
+	rated := []int{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		e := struct {
+			Event, Session, Fork string
+			Ratings              map[string]polyreduce.Rating
+		}{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		kinds = append(kinds, e.Event)
+		if e.Event == "start" {
+			first = e.Session
+		}
+		if e.Event == "fork" {
+			fork = e.Fork
+		}
+		if e.Event == "evaluate" {
+			rated = append(rated, len(e.Ratings))
+		}
+	}
+	want := "start phase evaluate phase reset phase evaluate phase fork phase stop"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Fatalf("unexpected events, want %q, got %q", want, got)
+	}
+	if len(rated) != 2 || rated[0] != 3 || rated[1] != 4 {
+		t.Fatalf("the evaluations should record 3 and 4 ratings, got: %v", rated)
+	}
+
+	// The state follows the fork, whose pending phase is resumable.
+	ss, err := s.Client().LoadSession(state)
+	if err != nil {
+		t.Fatalf("failed to load the state: %v", err)
+	}
+	if ss.ID != fork || ss.ID == first || len(ss.Phases) != 2 {
+		t.Fatalf("the state should be the fork of %s, got: %+v", first, ss)
+	}
+	if snap, _ := s.Session(first); len(snap.Phases) != 2 {
+		t.Fatalf("the reset session should have 2 phases, got: %+v", snap)
+	}
+}
+
+func TestSessionHistory(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	dir := t.TempDir()
+	state := filepath.Join(dir, "session.json")
+	_, err := executeInput(t, s, "1\n", "session", "loop", testModel, "--events", filepath.Join(dir, "events.jsonl"), "--mode", "best-of-n", "--max-iterations", "1", "--state", state)
+	if err != nil {
+		t.Fatalf("failed to run the loop: %v", err)
+	}
+	ss, err := s.Client().LoadSession(state)
+	if err != nil {
+		t.Fatalf("failed to load the state: %v", err)
+	}
+	p := ss.Current()
+
+	if _, err := execute(t, s, "session", "amend", ss.ID, "1", "2=excellent", p.IDs[0]+"=1"); err != nil {
+		t.Fatalf("failed to amend: %v", err)
+	}
+	out, err := execute(t, s, "session", "history", ss.ID)
+	if err != nil {
+		t.Fatalf("failed to list the history: %v", err)
+	}
+	for _, want := range []string{
+		"phase 1 (score)",
+		"[1] " + p.IDs[0] + ": Terrible",
+		"[2] " + p.IDs[1] + ": Excellent",
+		"[3] " + p.IDs[2] + ": Unrated",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("the history should contain %q, got:\n%s", want, out)
+		}
+	}
+
+	if _, err := execute(t, s, "session", "retract", ss.ID, "1"); err != nil {
+		t.Fatalf("failed to retract: %v", err)
+	}
+	out, _ = execute(t, s, "session", "history", ss.ID)
+	if !strings.Contains(out, "phase 1 (unevaluated)") {
+		t.Fatalf("the phase should be unevaluated, got:\n%s", out)
+	}
+	if _, err := execute(t, s, "session", "amend", ss.ID, "1", "1=great"); err == nil {
+		t.Fatalf("an invalid rating should be rejected")
+	}
+}
+
+func TestSessionPull(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	ss, err := s.Client().StartSession(context.Background(), &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if _, err := ss.Next(context.Background()); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+
+	dir := t.TempDir()
+	out, err := execute(t, s, "session", "pull", ss.ID, "--dir", dir)
+	if err != nil {
+		t.Fatalf("failed to pull: %v", err)
+	}
+	if !strings.Contains(out, "5 new models, 0 existing models") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ss.ID, "base.json")); err != nil {
+		t.Fatalf("base.json should be written: %v", err)
+	}
+	out, _ = execute(t, s, "session", "pull", ss.ID, "--dir", dir)
+	if !strings.Contains(out, "0 new models, 5 existing models") {
+		t.Fatalf("a second pull should be incremental: %s", out)
+	}
+}
+
+func TestSessionCommands(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	lastLine := func(out string) string {
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		return lines[len(lines)-1]
+	}
+
+	out, err := execute(t, s, "session", "upload", testModel)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	sid := lastLine(out)
+	if _, ok := s.Session(sid); !ok {
+		t.Fatalf("upload should print the session ID, got: %s", out)
+	}
+
+	out, err = execute(t, s, "session", "run", sid)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	ids := strings.Split(strings.TrimSpace(out), "\n")[1:]
+	if len(ids) == 0 {
+		t.Fatalf("run should print the variants, got: %s", out)
+	}
+
+	out, err = execute(t, s, "session", "inspect", sid)
+	if err != nil {
+		t.Fatalf("failed to inspect: %v", err)
+	}
+	if !strings.Contains(out, ids[0]) {
+		t.Fatalf("inspect should list the unevaluated variants, got: %s", out)
+	}
+
+	file := filepath.Join(t.TempDir(), "ratings.json")
+	if err := os.WriteFile(file, []byte(`{"`+ids[1]+`": "poor", "`+ids[2]+`": 4}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := execute(t, s, "session", "evaluate", sid, "1=excellent", "--file", file); err != nil {
+		t.Fatalf("failed to evaluate: %v", err)
+	}
+	if _, err := execute(t, s, "session", "evaluate", sid, "1=unknown"); err == nil {
+		t.Fatalf("evaluate should reject an unknown rating")
+	}
+	snap, _ := s.Session(sid)
+	if snap.Ratings[ids[0]] != polyreduce.Excellent || snap.Ratings[ids[1]] != polyreduce.Poor || snap.Ratings[ids[2]] != polyreduce.Good {
+		t.Fatalf("unexpected ratings: %v", snap.Ratings)
+	}
+	if _, err := execute(t, s, "session", "run", sid); err != nil {
+		t.Fatalf("failed to run the second phase: %v", err)
+	}
+
+	dir := t.TempDir()
+	if _, err := execute(t, s, "session", "download", sid, ids[0], filepath.Join(dir, "one.fbx")); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if _, err := execute(t, s, "session", "download", sid, ids[0]); err == nil {
+		t.Fatalf("download should require a path")
+	}
+	all := filepath.Join(dir, "all")
+	if _, err := execute(t, s, "session", "download", "--all", sid, all); err != nil {
+		t.Fatalf("failed to download all models: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(all, "*.fbx"))
+	if want := 1 + len(ids)*2; len(files) != want {
+		t.Fatalf("download --all should save %d models, got %d", want, len(files))
+	}
+
+	out, err = execute(t, s, "session", "copy", sid)
+	if err != nil {
+		t.Fatalf("failed to copy: %v", err)
+	}
+	if cp := lastLine(out); cp == sid {
+		t.Fatalf("copy should print a new session ID, got: %s", out)
+	} else if _, ok := s.Session(cp); !ok {
+		t.Fatalf("copy should print the new session ID, got: %s", out)
+	}
+
+	if _, err := execute(t, s, "session", "reset", sid); err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	if snap, _ := s.Session(sid); len(snap.Phases) != 0 {
+		t.Fatalf("reset should clear the phases, got: %+v", snap)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+)
+
+// sweepFlags are the flags of the sweep command.
+type sweepFlags struct {
+	dir         string
+	name        string
+	manifest    string
+	from        float64
+	to          float64
+	step        float64
+	ratios      []float64
+	layers      []string
+	concurrency int
+}
+
+func newSweepCmd() *cobra.Command {
+	f := &sweepFlags{}
+	sweepCmd := &cobra.Command{
+		Use:   "sweep [path_to_model]",
+		Short: "Reduce a model to a series of reduction ratios",
+		Long: `Reduce a model to a series of reduction ratios.
+
+The reduced models are saved as <name>_<ratio>.fbx to the directory,
+and models that already exist are skipped, hence an interrupted sweep
+resumes when it runs again. A manifest lists the requested ratio, the
+achieved face count and the path of every reduced model, e.g.:
+
+  sweep teapot_0.fbx --dir dataset/reduces/teapot --from 1 --to 99
+  sweep car_0.fbx --ratios 10,50,90 --layer 'Wheel*=100'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Sweep(cmd, args, f)
+		},
+	}
+	fs := sweepCmd.Flags()
+	fs.StringVar(&f.dir, "dir", ".", "directory of the reduced models")
+	fs.StringVar(&f.name, "name", "", "file name prefix of the reduced models, the model name without a _0 suffix if empty")
+	fs.StringVar(&f.manifest, "manifest", "", "path of the manifest CSV, manifest.csv in the directory if empty")
+	fs.Float64Var(&f.from, "from", 1, "first reduction ratio of the range")
+	fs.Float64Var(&f.to, "to", 99, "last reduction ratio of the range")
+	fs.Float64Var(&f.step, "step", 1, "step of the range")
+	fs.Float64SliceVar(&f.ratios, "ratios", nil, "explicit reduction ratios instead of the range, e.g. 10,50,90")
+	fs.StringArrayVar(&f.layers, "layer", nil, "reduction ratio of the layers that match a name or glob pattern regardless of the swept ratio, e.g. 'Wheel*=100', repeatable")
+	fs.IntVar(&f.concurrency, "concurrency", 2, "number of ratios that are reduced at the same time")
+	return sweepCmd
+}
+
+// sweepResult is the result of a ratio of the sweep command.
+type sweepResult struct {
+	Ratio   float64 `json:"ratio"`
+	Faces   int     `json:"faces"`
+	Path    string  `json:"path,omitempty"`
+	Skipped bool    `json:"skipped,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func Sweep(cmd *cobra.Command, args []string, f *sweepFlags) error {
+	in := &polyreduce.SweepInput{
+		ModelPath:   args[0],
+		Ratios:      f.ratios,
+		Dir:         f.dir,
+		Name:        f.name,
+		Concurrency: f.concurrency,
+	}
+	if !cmd.Flags().Changed("ratios") {
+		ratios, err := polyreduce.RatioRange(f.from, f.to, f.step)
+		if err != nil {
+			return &usageError{err}
+		}
+		in.Ratios = ratios
+	}
+	for _, s := range f.layers {
+		r, err := polyreduce.ParseLayerRatio(s)
+		if err != nil {
+			return &usageError{err}
+		}
+		in.Layers = append(in.Layers, r)
+	}
+	manifest := f.manifest
+	if manifest == "" {
+		manifest = filepath.Join(f.dir, "manifest.csv")
+	}
+
+	ctx := context.Background()
+	c := newClient()
+	sum, err := hashFile(in.ModelPath)
+	if err != nil {
+		return fmt.Errorf("failed to sweep: %w", err)
+	}
+	if e := reusableModel(ctx, c, sum); e != nil {
+		in.ModelID = e.ID
+		log.Printf("model is already uploaded from %s: %s", e.Source, e.ID)
+	}
+
+	done := 0
+	uploaded := map[string]bool{in.ModelID: true}
+	in.Progress = func(r *polyreduce.SweepResult) {
+		done++
+		if r.ModelID != "" && !uploaded[r.ModelID] {
+			uploaded[r.ModelID] = true
+			recordHistory(c, &historyEvent{Kind: kindModel, ID: r.ModelID, Action: "upload", Source: in.ModelPath, SHA256: sum})
+		}
+		if r.Err == nil && !r.Skipped {
+			recordHistory(c, &historyEvent{Kind: kindModel, ID: r.ModelID, Action: "config", Config: r.Config})
+			recordHistory(c, &historyEvent{Kind: kindModel, ID: r.ModelID, Action: "run"})
+			recordHistory(c, &historyEvent{Kind: kindModel, ID: r.ModelID, Action: "download", Output: r.Path})
+		}
+		switch {
+		case r.Err != nil:
+			log.Printf("[%d/%d] ratio %v failed: %v", done, len(in.Ratios), r.Ratio, r.Err)
+		case r.Skipped:
+			log.Printf("[%d/%d] ratio %v exists: %s", done, len(in.Ratios), r.Ratio, r.Path)
+		default:
+			log.Printf("[%d/%d] ratio %v is reduced to %d faces: %s", done, len(in.Ratios), r.Ratio, r.Faces, r.Path)
+		}
+	}
+
+	o, err := c.Sweep(ctx, in)
+	if o == nil {
+		return fmt.Errorf("failed to sweep: %w", err)
+	}
+	if werr := writeManifest(manifest, o); werr != nil {
+		return werr
+	}
+
+	results := make([]*sweepResult, len(o.Results))
+	for i, r := range o.Results {
+		results[i] = &sweepResult{Ratio: r.Ratio, Faces: r.Faces, Path: r.Path, Skipped: r.Skipped}
+		if r.Err != nil {
+			results[i].Path, results[i].Error = "", r.Err.Error()
+		}
+	}
+	perr := printResult(cmd, struct {
+		Manifest string         `json:"manifest"`
+		Results  []*sweepResult `json:"results"`
+	}{manifest, results}, func(w io.Writer) {
+		fmt.Fprintf(w, "manifest is saved to: %s\n", manifest)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sweep: %w", err)
+	}
+	return perr
+}
+
+// writeManifest writes the manifest of a sweep to a file.
+func writeManifest(path string, o *polyreduce.SweepOutput) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+	if err := o.WriteCSV(f); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+	return f.Close()
+}
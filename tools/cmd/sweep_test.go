@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestSweep(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"Body", "Wheel"}
+
+	dir := t.TempDir()
+	args := []string{"sweep", testModel, "--dir", dir, "--from", "10", "--to", "30", "--step", "10", "--layer", "Wheel=100"}
+	out, err := execute(t, s, args...)
+	if err != nil {
+		t.Fatalf("failed to sweep: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "[3/3]") {
+		t.Fatalf("the sweep should report its progress, got:\n%s", out)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.csv"))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	want := "ratio,faces,path,error\n" +
+		"10,7872," + filepath.Join(dir, "monkey_10.fbx") + ",\n" +
+		"20,7872," + filepath.Join(dir, "monkey_20.fbx") + ",\n" +
+		"30,7872," + filepath.Join(dir, "monkey_30.fbx") + ",\n"
+	if string(b) != want {
+		t.Fatalf("want manifest:\n%s\ngot:\n%s", want, b)
+	}
+
+	// A second sweep skips the existing models.
+	manifest := filepath.Join(t.TempDir(), "sweep.csv")
+	out, err = execute(t, s, "sweep", testModel, "--dir", dir, "--ratios", "20,40", "--manifest", manifest, "-o", "json")
+	if err != nil {
+		t.Fatalf("failed to sweep: %v\n%s", err, out)
+	}
+	var r struct {
+		Manifest string
+		Results  []sweepResult
+	}
+	if err := json.Unmarshal([]byte(out[strings.Index(out, "{"):]), &r); err != nil {
+		t.Fatalf("the sweep should print JSON, got %q: %v", out, err)
+	}
+	if r.Manifest != manifest || len(r.Results) != 2 || !r.Results[0].Skipped || r.Results[1].Skipped {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	for _, args := range [][]string{
+		{"sweep", testModel, "--from", "50", "--to", "10"},
+		{"sweep", testModel, "--ratios", "10", "--layer", "Wheel"},
+	} {
+		if _, err := execute(t, s, args...); ExitCode(err) != ExitUsage {
+			t.Fatalf("%v should fail with a usage error, got: %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/mesh"
+)
+
+// loopAction is returned by the terminal rater to interrupt the loop.
+type loopAction string
+
+// All actions that interrupt the loop.
+const (
+	actionStop  loopAction = "stop"
+	actionReset loopAction = "reset"
+	actionFork  loopAction = "fork"
+)
+
+func (a loopAction) Error() string { return "loop interrupted: " + string(a) }
+
+// variantInfo is a variant as it is shown to the rater.
+type variantInfo struct {
+	ID     string             `json:"id"`
+	Config map[string]float64 `json:"config,omitempty"`
+	Stats  *mesh.Stats        `json:"stats,omitempty"`
+}
+
+// terminalRater is a polyreduce.Rater that shows the variants of a phase
+// with their configuration and mesh statistics in a terminal, and reads
+// the evaluation from the keyboard.
+type terminalRater struct {
+	c      *polyreduce.Client
+	mode   polyreduce.EvaluationMode
+	in     *bufio.Scanner
+	out    io.Writer
+	clear  bool
+	events *eventLog
+
+	// roots are the meshes of the root models by session ID. A nil mesh
+	// is a root model that cannot be read, e.g. an ASCII FBX file.
+	roots map[string]*mesh.Mesh
+}
+
+func newTerminalRater(c *polyreduce.Client, mode polyreduce.EvaluationMode, in io.Reader, out io.Writer, events *eventLog) *terminalRater {
+	f, ok := out.(*os.File)
+	return &terminalRater{
+		c:      c,
+		mode:   mode,
+		in:     bufio.NewScanner(in),
+		out:    out,
+		clear:  ok && isTerminal(f),
+		events: events,
+		roots:  map[string]*mesh.Mesh{},
+	}
+}
+
+// Rate implements polyreduce.Rater.
+func (r *terminalRater) Rate(ctx context.Context, s *polyreduce.Session, variants []polyreduce.Variant) (polyreduce.Evaluation, error) {
+	infos, err := r.inspect(ctx, s, variants)
+	if err != nil {
+		return nil, err
+	}
+	r.events.record(&loopEvent{
+		Event:    "phase",
+		Session:  s.ID,
+		Phase:    len(s.Phases),
+		Variants: infos,
+		Optimal:  s.Current().AssumedOptimal,
+	})
+
+	help := false
+	msg := ""
+	for {
+		r.render(s, infos, help, msg)
+		help, msg = false, ""
+
+		fmt.Fprint(r.out, "> ")
+		if !r.in.Scan() {
+			if err := r.in.Err(); err != nil {
+				return nil, err
+			}
+			return nil, actionStop
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		answer := strings.TrimSpace(r.in.Text())
+		switch answer {
+		case "q", "quit":
+			return nil, actionStop
+		case "f", "fork":
+			return nil, actionFork
+		case "r", "reset":
+			if r.confirm(fmt.Sprintf("reset session %s and forget %d phases? [y/N] ", s.ID, len(s.Phases))) {
+				return nil, actionReset
+			}
+			continue
+		case "?", "h", "help":
+			help = true
+			continue
+		}
+		e, err := r.parse(answer, variants)
+		if err != nil {
+			msg = err.Error()
+			continue
+		}
+		return e, nil
+	}
+}
+
+// inspect fetches the configuration and computes the mesh statistics of
+// every variant. Missing information is left empty rather than failing
+// the loop.
+func (r *terminalRater) inspect(ctx context.Context, s *polyreduce.Session, variants []polyreduce.Variant) ([]variantInfo, error) {
+	root, ok := r.roots[s.ID]
+	if !ok && len(variants) > 0 {
+		path := filepath.Join(filepath.Dir(variants[0].Path), s.ID+".fbx")
+		if _, err := s.DownloadPhase(ctx, s.ID, path); err != nil {
+			return nil, fmt.Errorf("failed to download root model: %w", err)
+		}
+		m, err := mesh.Load(path)
+		if err != nil {
+			log.Printf("mesh statistics are not available: %v", err)
+		}
+		root = m
+		r.roots[s.ID] = root
+	}
+
+	infos := make([]variantInfo, len(variants))
+	for i, v := range variants {
+		infos[i].ID = v.ID
+		o, err := r.c.ProPolyredModelConfig(ctx, &polyreduce.ProPolyredModelConfigInput{SessionId: s.ID, ModelId: v.ID})
+		if err == nil {
+			infos[i].Config = o.ReductionRatio
+		}
+		if root == nil {
+			continue
+		}
+		if m, err := mesh.Load(v.Path); err == nil {
+			stats := mesh.Compare(root, m)
+			infos[i].Stats = &stats
+		}
+	}
+	return infos, nil
+}
+
+// render draws the screen of a phase.
+func (r *terminalRater) render(s *polyreduce.Session, infos []variantInfo, help bool, msg string) {
+	if r.clear {
+		fmt.Fprint(r.out, "\033[H\033[2J")
+	}
+	fmt.Fprintf(r.out, "session %s, phase %d (%s)\n\n", s.ID, len(s.Phases), r.mode)
+
+	fmt.Fprintln(r.out, "assumed optimal:")
+	for i, v := range s.AssumedOptimal() {
+		n := int(v / 100 * progressBarWidth)
+		if n < 0 {
+			n = 0
+		} else if n > progressBarWidth {
+			n = progressBarWidth
+		}
+		bar := strings.Repeat("=", n) + strings.Repeat(" ", progressBarWidth-n)
+		fmt.Fprintf(r.out, "  %3d [%s] %6.2f\n", i+1, bar, v)
+	}
+	fmt.Fprintln(r.out)
+
+	fmt.Fprintf(r.out, "  %-3s %-36s %-24s %8s %8s %8s\n", "", "model", "config", "faces", "ratio", "error")
+	for i, v := range infos {
+		faces, ratio, geomErr := "-", "-", "-"
+		if v.Stats != nil {
+			faces = strconv.Itoa(v.Stats.Faces)
+			ratio = fmt.Sprintf("%.2f%%", v.Stats.Ratio)
+			geomErr = fmt.Sprintf("%.4f", v.Stats.Error)
+		}
+		fmt.Fprintf(r.out, "  [%d] %-36s %-24s %8s %8s %8s\n", i+1, v.ID, formatConfig(v.Config), faces, ratio, geomErr)
+	}
+	fmt.Fprintln(r.out)
+
+	if help {
+		fmt.Fprintln(r.out, "ratings:")
+		for _, l := range polyreduce.Ratings() {
+			fmt.Fprintf(r.out, "  %d: %v\n", l, l)
+		}
+		fmt.Fprintln(r.out, "commands:")
+		fmt.Fprintln(r.out, "  q: stop the loop, the session can be resumed later")
+		fmt.Fprintln(r.out, "  r: reset the session to its root model")
+		fmt.Fprintln(r.out, "  f: fork the session and continue with the fork")
+		fmt.Fprintln(r.out)
+	}
+	if msg != "" {
+		fmt.Fprintln(r.out, msg)
+	}
+	fmt.Fprintf(r.out, "%s, or q (stop), r (reset), f (fork), ? (help)\n", r.usage(len(infos)))
+}
+
+// usage describes the expected answer of the evaluation mode.
+func (r *terminalRater) usage(n int) string {
+	switch r.mode {
+	case polyreduce.ModeRanking:
+		return fmt.Sprintf("rank all variants from the best to the worst, e.g. %s", example(n))
+	case polyreduce.ModePairwise:
+		return "compare pairs of variants, e.g. 1>2 3<4"
+	case polyreduce.ModeBestOfN:
+		return fmt.Sprintf("pick the best variant, 1 to %d", n)
+	}
+	return fmt.Sprintf("rate every variant from %d (skip) to %d (excellent), . leaves it unrated, e.g. %s",
+		polyreduce.Skip, polyreduce.Excellent, example(n))
+}
+
+// example returns an example answer with a key for each of n variants.
+func example(n int) string {
+	b := strings.Builder{}
+	for i := n; i >= 1; i-- {
+		b.WriteString(strconv.Itoa((i-1)%int(polyreduce.Excellent) + 1))
+	}
+	return b.String()
+}
+
+// parse parses an answer to an evaluation of the variants. An answer
+// that rates no variant is rejected, hence the rater asks again.
+func (r *terminalRater) parse(answer string, variants []polyreduce.Variant) (polyreduce.Evaluation, error) {
+	return polyreduce.ParseEvaluation(r.mode, answer, variants)
+}
+
+// confirm asks a yes or no question.
+func (r *terminalRater) confirm(question string) bool {
+	fmt.Fprint(r.out, question)
+	if !r.in.Scan() {
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(r.in.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+// formatConfig formats a reduction configuration sorted by layer names.
+func formatConfig(config map[string]float64) string {
+	if len(config) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%v", name, config[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+// loopEvent is an event of a session loop. A loop appends its events to
+// a local log as JSON lines, which allows to analyze a study later.
+type loopEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Session string    `json:"session"`
+
+	// Model is the path of the model of a started loop.
+	Model string `json:"model,omitempty"`
+	// Phase is the number of the phase of a phase or evaluate event.
+	Phase int `json:"phase,omitempty"`
+	// Variants are the variants of a phase event.
+	Variants []variantInfo `json:"variants,omitempty"`
+	// Mode and Ratings are the evaluation of an evaluate event.
+	Mode    polyreduce.EvaluationMode    `json:"mode,omitempty"`
+	Ratings map[string]polyreduce.Rating `json:"ratings,omitempty"`
+	// Optimal is the assumed optimal reduction ratio after the event.
+	Optimal float64 `json:"optimal,omitempty"`
+	// Fork is the ID of the forked session of a fork event.
+	Fork string `json:"fork,omitempty"`
+	// Reason is the reason of a stop event.
+	Reason polyreduce.StopReason `json:"reason,omitempty"`
+}
+
+// eventLog appends loop events to a file.
+type eventLog struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func openEventLog(path string) (*eventLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open event log: %w", err)
+	}
+	return &eventLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record appends an event to the log. A failed write is logged but does
+// not interrupt the loop.
+func (l *eventLog) record(e *loopEvent) {
+	e.Time = time.Now().UTC()
+	if err := l.enc.Encode(e); err != nil {
+		log.Printf("failed to record event: %v", err)
+	}
+}
+
+func (l *eventLog) Close() error {
+	return l.f.Close()
+}
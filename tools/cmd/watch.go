@@ -0,0 +1,362 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// newWatchCmd creates the command that watches a directory.
+func newWatchCmd() *cobra.Command {
+	f := &watchFlags{}
+	watchCmd := &cobra.Command{
+		Use:   "watch [dir]",
+		Short: "Reduce new or changed .fbx files of a directory",
+		Long: `Reduce new or changed .fbx files of a directory.
+
+The directory is polled, and a file is reduced once its size and
+modification time stop changing for the settle duration. A file is
+reduced by the first rule whose pattern matches its path relative to
+the directory, or its name if the pattern has no slash:
+
+  rules:
+    - match: "car_*.fbx"
+      all: 50
+      layers:
+        Wheel*: 30
+    - match: "props/*.fbx"
+      faces: 5000
+
+With --all, files that match no rule are reduced by the given ratio,
+and other files are ignored otherwise.
+
+A reduced model is saved to the same relative path in the output
+directory, next to a sidecar <name>.fbx.json that records the source,
+the model ID and the config. Files whose sidecar matches the source
+are not reduced again.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Watch(cmd, args, f)
+		},
+	}
+	watchCmd.Flags().StringVar(&f.out, "out", "", "output directory, <dir>/reduced by default")
+	watchCmd.Flags().StringVar(&f.rules, "rules", "", "YAML or JSON file of the reduction rules")
+	watchCmd.Flags().Float64Var(&f.all, "all", 0, "reduction ratio of the files that match no rule")
+	watchCmd.Flags().DurationVar(&f.interval, "interval", 2*time.Second, "interval of polling the directory")
+	watchCmd.Flags().DurationVar(&f.settle, "settle", 5*time.Second, "duration a file must not change before it is reduced")
+	watchCmd.Flags().BoolVar(&f.once, "once", false, "exit once all files are reduced")
+	return watchCmd
+}
+
+// watchFlags are the flags of the watch command.
+type watchFlags struct {
+	out      string
+	rules    string
+	all      float64
+	interval time.Duration
+	settle   time.Duration
+	once     bool
+}
+
+// watchRule reduces the files that match a pattern.
+type watchRule struct {
+	Match         string `yaml:"match"`
+	reductionSpec `yaml:",inline"`
+
+	ratios []polyreduce.LayerRatio
+}
+
+// match reports whether the rule matches the given slash separated path.
+func (r *watchRule) match(rel string) bool {
+	name := rel
+	if !strings.Contains(r.Match, "/") {
+		name = path.Base(rel)
+	}
+	ok, _ := path.Match(r.Match, name)
+	return ok
+}
+
+// readRules reads the rules of the flags.
+func (f *watchFlags) readRules(flags *pflag.FlagSet) ([]*watchRule, error) {
+	var rules struct {
+		Rules []*watchRule `yaml:"rules"`
+	}
+	if f.rules != "" {
+		b, err := os.ReadFile(f.rules)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read rules: %w", err)
+		}
+		if err := yaml.Unmarshal(b, &rules); err != nil {
+			return nil, fmt.Errorf("cannot parse rules %s: %w", f.rules, err)
+		}
+	}
+	if flags.Changed("all") {
+		all := f.all
+		rules.Rules = append(rules.Rules, &watchRule{Match: "*", reductionSpec: reductionSpec{All: &all}})
+	}
+	if len(rules.Rules) == 0 {
+		return nil, errors.New("requires either --rules or --all")
+	}
+	for i, r := range rules.Rules {
+		if _, err := path.Match(r.Match, ""); err != nil || r.Match == "" {
+			return nil, fmt.Errorf("rule %d has an invalid pattern: %q", i+1, r.Match)
+		}
+		ratios, err := r.reductionSpec.ratios()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d of %s %w", i+1, r.Match, err)
+		}
+		r.ratios = ratios
+	}
+	return rules.Rules, nil
+}
+
+// watchSidecar is the sidecar of a reduced model.
+type watchSidecar struct {
+	Source    string             `json:"source"`
+	Size      int64              `json:"size"`
+	ModTime   time.Time          `json:"mod_time"`
+	SHA256    string             `json:"sha256"`
+	Rule      string             `json:"rule"`
+	ModelID   string             `json:"model_id,omitempty"`
+	Config    map[string]float64 `json:"config,omitempty"`
+	Output    string             `json:"output"`
+	Faces     int                `json:"faces,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	ReducedAt time.Time          `json:"reduced_at"`
+}
+
+// watchedFile is the last observation of a file.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	// since is the time the file was first observed with its size and
+	// modification time.
+	since time.Time
+	// polls is the number of polls that observed the file unchanged.
+	polls int
+	// handled is true if the observed version of the file is reduced,
+	// failed or matches no rule.
+	handled bool
+}
+
+// watcher polls a directory and reduces its stable .fbx files.
+type watcher struct {
+	c      *polyreduce.Client
+	dir    string
+	out    string
+	rules  []*watchRule
+	settle time.Duration
+	// report is called with the sidecar of every reduced file.
+	report func(*watchSidecar)
+
+	files map[string]*watchedFile
+}
+
+// poll scans the directory once, and reduces the files that did not
+// change since the previous poll for the settle duration. It returns the
+// number of files that are not yet stable and the number of failed
+// reductions.
+func (w *watcher) poll(ctx context.Context) (pending, failed int, err error) {
+	if w.files == nil {
+		w.files = map[string]*watchedFile{}
+	}
+	out, _ := filepath.Abs(w.out)
+	seen := map[string]bool{}
+	err = filepath.WalkDir(w.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The root is unreadable, or a file vanished while walking.
+			if p == w.dir {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if abs, _ := filepath.Abs(p); abs == out {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(p), ".fbx") {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(w.dir, p)
+		seen[rel] = true
+
+		now := time.Now()
+		f, ok := w.files[rel]
+		switch {
+		case !ok:
+			f = &watchedFile{size: fi.Size(), modTime: fi.ModTime(), since: now}
+			f.handled = w.reduced(rel, f)
+			w.files[rel] = f
+		case f.size != fi.Size() || !f.modTime.Equal(fi.ModTime()):
+			*f = watchedFile{size: fi.Size(), modTime: fi.ModTime(), since: now}
+		default:
+			f.polls++
+		}
+		if f.handled {
+			return nil
+		}
+		if f.polls == 0 || now.Sub(f.since) < w.settle {
+			pending++
+			return nil
+		}
+		f.handled = true
+		if !w.reduce(ctx, rel, f) {
+			failed++
+		}
+		return nil
+	})
+	for rel := range w.files {
+		if !seen[rel] {
+			delete(w.files, rel)
+		}
+	}
+	return pending, failed, err
+}
+
+// sidecar returns the output and the sidecar path of a file.
+func (w *watcher) sidecar(rel string) (output, sidecar string) {
+	output = filepath.Join(w.out, rel)
+	return output, output + ".json"
+}
+
+// reduced reports whether the file was reduced before, according to
+// its sidecar.
+func (w *watcher) reduced(rel string, f *watchedFile) bool {
+	output, sidecar := w.sidecar(rel)
+	b, err := os.ReadFile(sidecar)
+	if err != nil {
+		return false
+	}
+	s := &watchSidecar{}
+	if err := json.Unmarshal(b, s); err != nil || s.Error != "" {
+		return false
+	}
+	if _, err := os.Stat(output); err != nil {
+		return false
+	}
+	return s.Size == f.size && s.ModTime.Equal(f.modTime)
+}
+
+// reduce reduces a file by its rule and writes its sidecar. It reports
+// whether the reduction succeeded or no rule matches the file.
+func (w *watcher) reduce(ctx context.Context, rel string, f *watchedFile) bool {
+	slash := filepath.ToSlash(rel)
+	var rule *watchRule
+	for _, r := range w.rules {
+		if r.match(slash) {
+			rule = r
+			break
+		}
+	}
+	if rule == nil {
+		log.Printf("%s matches no rule, ignored", slash)
+		return true
+	}
+
+	source := filepath.Join(w.dir, rel)
+	output, sidecar := w.sidecar(rel)
+	s := &watchSidecar{Source: source, Size: f.size, ModTime: f.modTime, Rule: rule.Match, Output: output}
+	sum, err := hashFile(source)
+	if err == nil {
+		s.SHA256 = sum
+		j := &pipelineJob{Model: source, Output: output, Ratios: rule.ratios, Faces: rule.Faces}
+		js := &jobState{}
+		err = reduce(ctx, w.c, nil, j, js, func() {})
+		s.ModelID, s.Config, s.Faces = js.ModelID, js.Config, js.Faces
+	}
+	s.ReducedAt = time.Now().UTC()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if err := os.MkdirAll(filepath.Dir(sidecar), 0755); err != nil {
+		log.Printf("failed to save the sidecar of %s: %v", slash, err)
+	} else if err := polyreduce.WriteJSONFile(sidecar, s); err != nil {
+		log.Printf("failed to save the sidecar of %s: %v", slash, err)
+	}
+	w.report(s)
+	return err == nil
+}
+
+func Watch(cmd *cobra.Command, args []string, f *watchFlags) error {
+	dir := args[0]
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return &usageError{fmt.Errorf("%s is not a directory", dir)}
+	}
+	rules, err := f.readRules(cmd.Flags())
+	if err != nil {
+		return &usageError{err}
+	}
+	out := f.out
+	if out == "" {
+		out = filepath.Join(dir, "reduced")
+	}
+
+	w := &watcher{
+		c:      newClient(),
+		dir:    dir,
+		out:    out,
+		rules:  rules,
+		settle: f.settle,
+		report: func(s *watchSidecar) {
+			err := printResult(cmd, s, func(w io.Writer) {
+				if s.Error != "" {
+					fmt.Fprintf(w, "failed to reduce %s: %s\n", s.Source, s.Error)
+					return
+				}
+				fmt.Fprintf(w, "%s is reduced to %d faces: %s\n", s.Source, s.Faces, s.Output)
+			})
+			if err != nil {
+				log.Printf("failed to print result: %v", err)
+			}
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	log.Printf("watching %s, reduced models are saved to %s", dir, out)
+	failed := 0
+	for {
+		pending, n, err := w.poll(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		failed += n
+		if f.once && pending == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(f.interval):
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d models failed", failed)
+	}
+	return nil
+}
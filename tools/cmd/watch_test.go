@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestWatch(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"Body", "Wheel"}
+
+	model, err := os.ReadFile(testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"car_red.fbx", "props/chair.fbx", "props/notes.txt", "tree.fbx"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, model, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := filepath.Join(t.TempDir(), "rules.yaml")
+	err = os.WriteFile(rules, []byte(`rules:
+  - match: "car_*.fbx"
+    all: 50
+    layers:
+      Wheel: 30
+  - match: "props/*.fbx"
+    faces: 1000
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"watch", dir, "--rules", rules, "--once", "--interval", "10ms", "--settle", "20ms"}
+	out, err := execute(t, s, args...)
+	if err != nil {
+		t.Fatalf("failed to watch: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "tree.fbx matches no rule") {
+		t.Fatalf("the watcher should ignore files that match no rule, got:\n%s", out)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "reduced", "car_red.fbx.json"))
+	if err != nil {
+		t.Fatalf("failed to read sidecar: %v", err)
+	}
+	sidecar := &watchSidecar{}
+	if err := json.Unmarshal(b, sidecar); err != nil {
+		t.Fatalf("failed to parse sidecar: %v", err)
+	}
+	if _, ok := s.Model(sidecar.ModelID); !ok || sidecar.Rule != "car_*.fbx" || sidecar.Config["Wheel"] != 30 || sidecar.Config["Body"] != 50 {
+		t.Fatalf("unexpected sidecar: %+v", sidecar)
+	}
+	for _, name := range []string{"car_red.fbx", "props/chair.fbx"} {
+		if _, err := os.Stat(filepath.Join(dir, "reduced", name)); err != nil {
+			t.Fatalf("missing reduced model: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "reduced", "tree.fbx")); err == nil {
+		t.Fatalf("tree.fbx matches no rule and should not be reduced")
+	}
+
+	// Reduced files are not reduced again, unless they change, and
+	// files that match no rule are reduced by --all.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "car_red.fbx"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	out, err = execute(t, s, append(args, "--all", "20", "-o", "json")...)
+	if err != nil {
+		t.Fatalf("failed to watch: %v\n%s", err, out)
+	}
+	var reduced []string
+	dec := json.NewDecoder(strings.NewReader(out[strings.Index(out, "{"):]))
+	for dec.More() {
+		r := &watchSidecar{}
+		if err := dec.Decode(r); err != nil {
+			t.Fatalf("the watcher should print JSON, got %q: %v", out, err)
+		}
+		reduced = append(reduced, filepath.Base(r.Source))
+	}
+	if strings.Join(reduced, ",") != "car_red.fbx,tree.fbx" {
+		t.Fatalf("want car_red.fbx and tree.fbx to be reduced, got %v", reduced)
+	}
+
+	for _, args := range [][]string{
+		{"watch", dir, "--once"},
+		{"watch", filepath.Join(dir, "missing"), "--all", "50"},
+		{"watch", dir, "--all", "150", "--once"},
+	} {
+		if _, err := execute(t, s, args...); ExitCode(err) != ExitUsage {
+			t.Fatalf("%v should fail with a usage error, got: %v", args, err)
+		}
+	}
+}
+
+func TestWatcher_StableWrite(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	model, err := os.ReadFile(testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	all := 50.0
+	w := &watcher{
+		c:      polyreduce.NewClient(polyreduce.WithEndpoint(s.URL), polyreduce.WithPollInterval(10*time.Millisecond)),
+		dir:    dir,
+		out:    filepath.Join(dir, "reduced"),
+		rules:  []*watchRule{{Match: "*", reductionSpec: reductionSpec{All: &all}, ratios: []polyreduce.LayerRatio{{Pattern: "*", Ratio: all}}}},
+		report: func(*watchSidecar) {},
+	}
+	path := filepath.Join(dir, "model.fbx")
+	ctx := context.Background()
+
+	// The file is written in two parts, and is reduced once it was
+	// observed unchanged.
+	if err := os.WriteFile(path, model[:len(model)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _, _ := w.poll(ctx); pending != 1 {
+		t.Fatalf("a new file should be pending, got %d pending files", pending)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(model[len(model)/2:]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if pending, _, _ := w.poll(ctx); pending != 1 {
+		t.Fatalf("a changed file should be pending, got %d pending files", pending)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "reduced", "model.fbx")); err == nil {
+		t.Fatalf("a file should not be reduced while it is written")
+	}
+	pending, failed, err := w.poll(ctx)
+	if err != nil || pending != 0 || failed != 0 {
+		t.Fatalf("a stable file should be reduced, got %d pending, %d failed: %v", pending, failed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "reduced", "model.fbx")); err != nil {
+		t.Fatalf("missing reduced model: %v", err)
+	}
+}
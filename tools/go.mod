@@ -2,9 +2,10 @@ module changkun.de/x/infloop/tools
 
 go 1.18
 
-require github.com/spf13/cobra v1.4.0
-
 require (
-	github.com/inconshreveable/mousetrap v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/cobra v1.4.0
+	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/inconshreveable/mousetrap v1.0.0 // indirect
@@ -0,0 +1,294 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package cassette records the HTTP interactions of a polyreduce client
+// into a cassette directory and replays them later without the service.
+//
+// A Recorder captures real interactions, including multipart uploads and
+// binary downloads:
+//
+//	rec := cassette.NewRecorder("testdata/session", nil)
+//	c := polyreduce.NewClient(polyreduce.WithTransport(rec))
+//
+// A Replayer serves the recorded interactions back:
+//
+//	rep, err := cassette.NewReplayer("testdata/session")
+//	c := polyreduce.NewClient(
+//		polyreduce.WithTransport(rep),
+//		polyreduce.WithRetryPolicy(polyreduce.NoRetry),
+//	)
+//
+// Retries are useless during a replay, because a request that is not
+// recorded fails with ErrNotRecorded every time.
+//
+// Credentials are redacted before an interaction is written. Requests are
+// matched on method, path and the SHA-256 hash of the request body.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotRecorded is returned by a Replayer for a request that does not
+// match any recorded interaction.
+var ErrNotRecorded = errors.New("cassette: request not recorded")
+
+// Redacted replaces the values of all redacted headers.
+const Redacted = "REDACTED"
+
+// redactedHeaders are headers that may carry credentials.
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// Interaction is a recorded request and its response. The response body
+// is stored next to the interaction in a file named by Body.
+type Interaction struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	// BodyHash is the hex encoded SHA-256 hash of the request body.
+	BodyHash      string      `json:"body_hash"`
+	BodySize      int64       `json:"body_size"`
+	RequestHeader http.Header `json:"request_header"`
+
+	StatusCode     int         `json:"status_code"`
+	ResponseHeader http.Header `json:"response_header"`
+	// Body is the file name of the response body in the cassette.
+	Body string `json:"body"`
+}
+
+// key returns the key that requests are matched on.
+func (i *Interaction) key() string {
+	return i.Method + " " + i.Path + " " + i.BodyHash
+}
+
+// Recorder is an http.RoundTripper that records every interaction into
+// a cassette directory.
+type Recorder struct {
+	dir  string
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder returns a recorder that sends requests using next, or
+// http.DefaultTransport if next is nil, and writes the interactions to
+// the given directory.
+func NewRecorder(dir string, next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{dir: dir, next: next}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(req.Context())
+	if req.Body != nil {
+		out.Body = ioutil.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+	}
+	resp, err := r.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Interaction{
+		Method:         req.Method,
+		Path:           req.URL.Path,
+		Query:          req.URL.RawQuery,
+		BodyHash:       bodyHash(req, body),
+		BodySize:       int64(len(body)),
+		RequestHeader:  redact(req.Header),
+		StatusCode:     resp.StatusCode,
+		ResponseHeader: redact(resp.Header),
+	}
+	if err := r.save(i, data); err != nil {
+		return nil, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	return resp, nil
+}
+
+// save writes the interaction and its response body to the cassette.
+func (r *Recorder) save(i *Interaction, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seq == 0 {
+		if err := os.MkdirAll(r.dir, 0755); err != nil {
+			return fmt.Errorf("cassette: failed to create cassette: %w", err)
+		}
+		// Continue the sequence of an existing cassette.
+		names, _ := filepath.Glob(filepath.Join(r.dir, "*.json"))
+		r.seq = len(names)
+	}
+	r.seq++
+
+	name := fmt.Sprintf("%04d", r.seq)
+	i.Body = name + ".body"
+	b, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, i.Body), body, 0644); err != nil {
+		return fmt.Errorf("cassette: failed to save interaction: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, name+".json"), b, 0644); err != nil {
+		return fmt.Errorf("cassette: failed to save interaction: %w", err)
+	}
+	return nil
+}
+
+// Replayer is an http.RoundTripper that serves the interactions of a
+// cassette. Identical requests are served with the recorded responses
+// in the order of recording, and the last response is repeated once all
+// of them were served, e.g. for polling the status of a job.
+type Replayer struct {
+	dir string
+
+	mu           sync.Mutex
+	interactions map[string][]*Interaction
+	served       map[string]int
+}
+
+// NewReplayer loads the cassette in the given directory.
+func NewReplayer(dir string) (*Replayer, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("cassette: no interactions in %s", dir)
+	}
+	sort.Strings(names)
+
+	r := &Replayer{
+		dir:          dir,
+		interactions: map[string][]*Interaction{},
+		served:       map[string]int{},
+	}
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("cassette: failed to load interaction: %w", err)
+		}
+		i := &Interaction{}
+		if err := json.Unmarshal(b, i); err != nil {
+			return nil, fmt.Errorf("cassette: invalid interaction %s: %w", name, err)
+		}
+		r.interactions[i.key()] = append(r.interactions[i.key()], i)
+	}
+	return r, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := (&Interaction{
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		BodyHash: bodyHash(req, body),
+	}).key()
+
+	r.mu.Lock()
+	all := r.interactions[key]
+	n := r.served[key]
+	if n < len(all) {
+		r.served[key]++
+	} else {
+		n = len(all) - 1
+	}
+	r.mu.Unlock()
+	if n < 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, req.URL.Path)
+	}
+	i := all[n]
+
+	data, err := os.ReadFile(filepath.Join(r.dir, i.Body))
+	if err != nil {
+		return nil, fmt.Errorf("cassette: failed to load response: %w", err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.StatusCode, http.StatusText(i.StatusCode)),
+		StatusCode:    i.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        i.ResponseHeader.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// readBody reads and closes the body of a request.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: failed to read request body: %w", err)
+	}
+	return b, nil
+}
+
+// bodyHash returns the hex encoded SHA-256 hash of a request body. The
+// random boundary of a multipart body is replaced by a fixed one, so
+// that the same upload always has the same hash.
+func bodyHash(req *http.Request, body []byte) string {
+	mt, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err == nil && strings.HasPrefix(mt, "multipart/") && params["boundary"] != "" {
+		body = bytes.ReplaceAll(body, []byte(params["boundary"]), []byte("cassette"))
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// redact returns a copy of the header without credentials.
+func redact(h http.Header) http.Header {
+	h = h.Clone()
+	for k := range h {
+		for _, r := range redactedHeaders {
+			if strings.EqualFold(k, r) {
+				h[k] = []string{Redacted}
+			}
+		}
+	}
+	return h
+}
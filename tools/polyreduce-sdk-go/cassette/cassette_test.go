@@ -0,0 +1,95 @@
+package cassette_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/cassette"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+const testModel = "../testdata/monkey.fbx"
+
+// session runs a propolyred session and returns the downloaded variant.
+func session(t *testing.T, c *polyreduce.Client, dir string) (*polyreduce.ProPolyredRunOutput, []byte) {
+	t.Helper()
+	ctx := context.Background()
+
+	up, err := c.ProPolyredUpload(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	run, err := c.ProPolyredRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: up.SessionId})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	path := filepath.Join(dir, "variant.fbx")
+	_, err = c.ProPolyredDownload(ctx, &polyreduce.ProPolyredDownloadInput{
+		SessionId:      up.SessionId,
+		PhaseId:        run.Phases[0],
+		Path:           path,
+		VerifyChecksum: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	err = c.ProPolyredEvaluate(ctx, &polyreduce.ProPolyredEvaluateInput{
+		SessionId: up.SessionId,
+		Rating:    map[string]polyreduce.Rating{run.Phases[0]: polyreduce.Good},
+	})
+	if err != nil {
+		t.Fatalf("failed to evaluate: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the downloaded model: %v", err)
+	}
+	return run, b
+}
+
+func TestCassette(t *testing.T) {
+	dir := t.TempDir()
+
+	s := polyreducetest.NewServer()
+	rec := cassette.NewRecorder(dir, nil)
+	want, wantModel := session(t, s.Client(polyreduce.WithTransport(rec)), t.TempDir())
+	s.Close()
+
+	secret := base64.StdEncoding.EncodeToString([]byte(polyreducetest.Username + ":" + polyreducetest.Password))
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, f := range files {
+		b, _ := os.ReadFile(f)
+		if bytes.Contains(b, []byte(secret)) {
+			t.Fatalf("credentials are not redacted in %s", f)
+		}
+	}
+
+	rep, err := cassette.NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+	c := polyreduce.NewClient(
+		polyreduce.WithEndpoint("http://127.0.0.1:1"),
+		polyreduce.WithTransport(rep),
+		polyreduce.WithPollInterval(1),
+		polyreduce.WithRetryPolicy(polyreduce.NoRetry),
+	)
+	got, gotModel := session(t, c, t.TempDir())
+	if got.Phases[0] != want.Phases[0] || got.AssumedOptimal != want.AssumedOptimal {
+		t.Fatalf("replayed run differs, want %+v, got %+v", want, got)
+	}
+	if !bytes.Equal(gotModel, wantModel) {
+		t.Fatalf("replayed model differs")
+	}
+
+	_, err = c.PolyredUpload(context.Background(), &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if !errors.Is(err, cassette.ErrNotRecorded) {
+		t.Fatalf("want ErrNotRecorded, got %v", err)
+	}
+}
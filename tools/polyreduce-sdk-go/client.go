@@ -4,30 +4,134 @@
 
 package polyreduce
 
+import (
+	"net/http"
+	"time"
+)
+
 // ClientVersion defines polyreduce client version
 const ClientVersion = "v0.0.1"
 
 // DefaultEndpoint is the default endpoint of polyreduce service.
 const DefaultEndpoint = "https://polyreduce.com"
 
+// DefaultBasePath is the default path prefix of all polyreduce APIs.
+const DefaultBasePath = "/api/v1"
+
+// DefaultUserAgent is the user agent sent by a polyreduce client if no
+// other user agent is configured.
+const DefaultUserAgent = "polyreduce-sdk-go/" + ClientVersion
+
+// The credentials of the public polyreduce account, used if no other
+// credentials are configured.
+const (
+	defaultUsername = "way"
+	defaultPassword = "secret-pass"
+)
+
 // Client represents the client to interact the polyreduce service.
 type Client struct {
-	endpoint string
+	endpoint  string
+	basePath  string
+	hc        *http.Client
+	transport http.RoundTripper
+	username  string
+	password  string
+	userAgent string
+	timeout   time.Duration
+	retry     RetryPolicy
+	retryHook RetryHook
+
+	pollInterval time.Duration
 }
 
-// NewClient creates a polyreduce client using default polyreduce endpoint.
-func NewClient() *Client {
-	return NewClientWithEndpoint(DefaultEndpoint)
+// Option configures a polyreduce client.
+type Option func(c *Client)
+
+// WithEndpoint sets the endpoint of the polyreduce service,
+// e.g. https://polyreduce.com.
+func WithEndpoint(endpoint string) Option {
+	return func(c *Client) { c.endpoint = endpoint }
 }
 
-// NewClientWithEndpoint creates a polyreduce client with a specific endpoint
-func NewClientWithEndpoint(endpoint string) *Client {
-	return &Client{
-		endpoint: endpoint,
+// WithBasePath sets the path prefix of all APIs, DefaultBasePath by default.
+func WithBasePath(path string) Option {
+	return func(c *Client) { c.basePath = path }
+}
+
+// WithHTTPClient sets the HTTP client that is used to send all requests,
+// which allows a caller to share connection pools with other services.
+// http.DefaultClient is used by default.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.hc = hc }
+}
+
+// WithTransport sets the round tripper of the HTTP client. If it is
+// combined with WithHTTPClient, the given HTTP client is copied and
+// its transport is replaced.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.transport = rt }
+}
+
+// WithCredentials sets the basic auth credentials for all requests.
+func WithCredentials(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
 	}
 }
 
+// WithUserAgent sets the user agent header for all requests.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// WithTimeout limits the duration of every single attempt of a call,
+// including reading the response body. A zero duration means no timeout,
+// which is the default since a reduction may take a long time.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// NewClient creates a polyreduce client. Without any options, the client
+// uses default polyreduce endpoint and the public account.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		endpoint:  DefaultEndpoint,
+		basePath:  DefaultBasePath,
+		hc:        http.DefaultClient,
+		username:  defaultUsername,
+		password:  defaultPassword,
+		userAgent: DefaultUserAgent,
+		retry:     DefaultRetryPolicy,
+
+		pollInterval: DefaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.hc == nil {
+		c.hc = http.DefaultClient
+	}
+	if c.transport != nil {
+		hc := *c.hc
+		hc.Transport = c.transport
+		c.hc = &hc
+	}
+	return c
+}
+
+// NewClientWithEndpoint creates a polyreduce client with a specific endpoint
+func NewClientWithEndpoint(endpoint string, opts ...Option) *Client {
+	return NewClient(append([]Option{WithEndpoint(endpoint)}, opts...)...)
+}
+
 // SetEndpoint sets the endpoint of polyreduce client
 func (c *Client) SetEndpoint(endpoint string) {
 	c.endpoint = endpoint
 }
+
+// Endpoint returns the endpoint of polyreduce client.
+func (c *Client) Endpoint() string {
+	return c.endpoint
+}
@@ -0,0 +1,131 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Compatibility is the compatibility of a client with a service.
+type Compatibility string
+
+// The compatibilities of a client with a service.
+const (
+	Compatible           Compatibility = "compatible"
+	Incompatible         Compatibility = "incompatible"
+	CompatibilityUnknown Compatibility = "unknown"
+)
+
+// compatibilityMatrix lists the service versions that are supported by
+// every series of client versions. A series is the major and minor
+// version of a client, and it supports the services from min up to, but
+// not including, max.
+var compatibilityMatrix = []struct {
+	client string
+	min    string
+	max    string
+}{
+	{client: "v0.0", min: "v0.0.0", max: "v0.1.0"},
+}
+
+// CompatibilityReport is the result of CheckCompatibility.
+type CompatibilityReport struct {
+	Client  string        `json:"client"`
+	Service string        `json:"service"`
+	Status  Compatibility `json:"status"`
+	Reason  string        `json:"reason"`
+	// Fix suggests how to resolve an incompatibility.
+	Fix string `json:"fix,omitempty"`
+}
+
+// CheckCompatibility checks a client version, e.g. ClientVersion, against
+// the version of a service as reported by Ping. The pre-release part of
+// a service version is ignored, hence v0.1.0-rc.1 is treated as v0.1.0.
+func CheckCompatibility(client, service string) *CompatibilityReport {
+	r := &CompatibilityReport{Client: client, Service: service, Status: CompatibilityUnknown}
+	cv, ok := parseVersion(client)
+	if !ok {
+		r.Reason = fmt.Sprintf("client version %q is not a release", client)
+		return r
+	}
+	series := fmt.Sprintf("v%d.%d", cv[0], cv[1])
+	var min, max [3]int
+	found := false
+	for _, row := range compatibilityMatrix {
+		if row.client == series {
+			min, _ = parseVersion(row.min)
+			max, _ = parseVersion(row.max)
+			found = true
+			break
+		}
+	}
+	if !found {
+		r.Reason = fmt.Sprintf("client %s is not in the compatibility matrix", client)
+		return r
+	}
+	sv, ok := parseVersion(service)
+	if !ok {
+		r.Reason = fmt.Sprintf("service version %q is not a release", service)
+		r.Fix = "ask the operator of the service which release it runs"
+		return r
+	}
+
+	supported := fmt.Sprintf("%s supports services from v%d.%d.%d before v%d.%d.%d",
+		client, min[0], min[1], min[2], max[0], max[1], max[2])
+	switch {
+	case compareVersion(sv, min) < 0:
+		r.Status = Incompatible
+		r.Reason = fmt.Sprintf("service %s is too old, %s", service, supported)
+		r.Fix = "ask the operator to upgrade the service, or use an older release of the client"
+	case compareVersion(sv, max) >= 0:
+		r.Status = Incompatible
+		r.Reason = fmt.Sprintf("service %s is too new, %s", service, supported)
+		r.Fix = "upgrade the client to a release that supports service " + service
+	default:
+		r.Status = Compatible
+		r.Reason = supported
+	}
+	return r
+}
+
+// parseVersion parses the major, minor and patch of a version such as
+// v1.2.3, v1.2.3-rc.1 or v1.2.3+build.
+func parseVersion(v string) ([3]int, bool) {
+	var n [3]int
+	if !strings.HasPrefix(v, "v") {
+		return n, false
+	}
+	v = v[1:]
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return n, false
+	}
+	for i, p := range parts {
+		x, err := strconv.Atoi(p)
+		if err != nil || x < 0 {
+			return n, false
+		}
+		n[i] = x
+	}
+	return n, true
+}
+
+// compareVersion compares two parsed versions like strings.Compare.
+func compareVersion(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
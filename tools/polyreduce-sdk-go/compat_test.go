@@ -0,0 +1,35 @@
+package polyreduce_test
+
+import (
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		client, service string
+		want            polyreduce.Compatibility
+	}{
+		{"v0.0.1", "v0.0.0", polyreduce.Compatible},
+		{"v0.0.1", "v0.0.9", polyreduce.Compatible},
+		{"v0.0.1", polyreducetest.Version, polyreduce.Compatible},
+		{"v0.0.1", "v0.1.0", polyreduce.Incompatible},
+		{"v0.0.1", "v0.1.0-rc.1", polyreduce.Incompatible},
+		{"v0.0.1", "v1.0.0", polyreduce.Incompatible},
+		{"v0.0.1", "dev", polyreduce.CompatibilityUnknown},
+		{"v0.0.1", "", polyreduce.CompatibilityUnknown},
+		{"v0.9.0", "v0.9.0", polyreduce.CompatibilityUnknown},
+		{"devel", "v0.0.0", polyreduce.CompatibilityUnknown},
+	}
+	for _, tt := range tests {
+		r := polyreduce.CheckCompatibility(tt.client, tt.service)
+		if r.Status != tt.want {
+			t.Errorf("client %s, service %s: want %s, got %s: %s", tt.client, tt.service, tt.want, r.Status, r.Reason)
+		}
+		if r.Status == polyreduce.Incompatible && r.Fix == "" {
+			t.Errorf("client %s, service %s: an incompatibility should suggest a fix", tt.client, tt.service)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors of a download that produced an unusable model.
+var (
+	ErrInvalidModel     = errors.New("polyreduce: invalid model")
+	ErrChecksumMismatch = errors.New("polyreduce: checksum mismatch")
+)
+
+// ChecksumHeader is the response header that carries the hex encoded
+// SHA-256 checksum of a downloaded model, if the service provides it.
+const ChecksumHeader = "X-Checksum-Sha256"
+
+// Model formats of a downloaded model.
+const (
+	FormatBinaryFBX = "fbx-binary"
+	FormatASCIIFBX  = "fbx-ascii"
+)
+
+// fbxBinaryMagic is the header of a binary FBX file.
+var fbxBinaryMagic = []byte("Kaydara FBX Binary  \x00")
+
+// DownloadOutput describes a model that was written by a download.
+type DownloadOutput struct {
+	// Path is the path of the written model.
+	Path string
+	// Size is the number of bytes of the model.
+	Size int64
+	// SHA256 is the hex encoded SHA-256 checksum of the model.
+	SHA256 string
+	// Format is the detected format of the model, either
+	// FormatBinaryFBX or FormatASCIIFBX.
+	Format string
+	// ContentType is the content type reported by the service.
+	ContentType string
+}
+
+// download sends the request and saves the response body to the given
+// path. The body is written to a temporary file in the same directory
+// that is renamed to the path only if the body is complete and is a
+// valid FBX model. If verify is true, the model must match the checksum
+// provided by the service. An interrupted body is downloaded again if
+// the request is retryable.
+func (c *Client) download(ctx context.Context, req *request, path string, verify bool, progress ProgressFunc) (*DownloadOutput, error) {
+	var o *DownloadOutput
+	err := c.do(ctx, req, func(resp *http.Response) (err error) {
+		o, err = saveModel(resp, path, verify, progress)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// saveModel saves the body of a download response to the given path.
+func saveModel(resp *http.Response, path string, verify bool, progress ProgressFunc) (o *DownloadOutput, err error) {
+	checksum := strings.ToLower(resp.Header.Get(ChecksumHeader))
+	if verify && checksum == "" {
+		return nil, fmt.Errorf("%w: the service did not provide a checksum", ErrChecksumMismatch)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	h := sha256.New()
+	head := &headWriter{max: len(fbxBinaryMagic)}
+	n, err := io.Copy(io.MultiWriter(f, h, head), withProgress(resp.Body, resp.ContentLength, progress))
+	if err != nil {
+		return nil, &interruptedError{n: n, err: err}
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return nil, fmt.Errorf("%w: expect %d bytes but received %d bytes", ErrInvalidModel, resp.ContentLength, n)
+	}
+	format := detectFormat(head.buf.Bytes())
+	if format == "" {
+		return nil, fmt.Errorf("%w: the received %d bytes are not an FBX model", ErrInvalidModel, n)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if verify && sum != checksum {
+		return nil, fmt.Errorf("%w: expect %s but received %s", ErrChecksumMismatch, checksum, sum)
+	}
+
+	if err = f.Sync(); err != nil {
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(tmp, 0644); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	return &DownloadOutput{
+		Path:        path,
+		Size:        n,
+		SHA256:      sum,
+		Format:      format,
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// detectFormat returns the FBX format of a model by its first bytes, or
+// an empty string if the bytes are not an FBX model.
+func detectFormat(head []byte) string {
+	if bytes.HasPrefix(head, fbxBinaryMagic) {
+		return FormatBinaryFBX
+	}
+	// An ASCII FBX starts with a comment, e.g. "; FBX 7.4.0 project file".
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	if bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n"), []byte("; FBX")) {
+		return FormatASCIIFBX
+	}
+	return ""
+}
+
+// headWriter keeps the first max bytes that are written to it.
+type headWriter struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	if n := w.max - w.buf.Len(); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		w.buf.Write(b[:n])
+	}
+	return len(b), nil
+}
@@ -0,0 +1,104 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sentinel errors that classify an APIError. They can be checked using
+// errors.Is, for instance:
+//
+//	if errors.Is(err, polyreduce.ErrNotFound) {
+//		// the model or session does not exist.
+//	}
+var (
+	ErrNotFound     = errors.New("polyreduce: not found")
+	ErrUnauthorized = errors.New("polyreduce: unauthorized")
+	ErrConflict     = errors.New("polyreduce: conflict")
+	ErrServerBusy   = errors.New("polyreduce: server busy")
+)
+
+// RequestIDHeader is the response header that carries the ID which the
+// polyreduce service assigned to a request.
+const RequestIDHeader = "X-Request-Id"
+
+// APIError is returned if the polyreduce service responds a request with
+// a non-2xx status code.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Message is the error message reported by the service, if any.
+	Message string
+	// Endpoint is the method and path of the failed request,
+	// e.g. "POST /api/v1/polyred/run/<id>".
+	Endpoint string
+	// RequestID is the request ID assigned by the service, if any.
+	RequestID string
+	// RetryAfter is the delay requested by the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("polyreduce: %s: %d %s", e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		s += " (request id: " + e.RequestID + ")"
+	}
+	return s
+}
+
+// Is reports whether the error belongs to the class of the given
+// sentinel error.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized ||
+			e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrServerBusy:
+		return e.StatusCode == http.StatusTooManyRequests ||
+			e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// maxErrorBody limits how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// newAPIError creates an APIError from a non-2xx response. The message
+// is taken from the "msg" field of a JSON body, or from the plain body
+// otherwise. The response body is consumed but not closed.
+func newAPIError(resp *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Endpoint:   resp.Request.Method + " " + resp.Request.URL.Path,
+		RequestID:  resp.Header.Get(RequestIDHeader),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var o struct {
+		Message string `json:"msg"`
+	}
+	if json.Unmarshal(data, &o) == nil {
+		e.Message = o.Message
+	} else {
+		e.Message = strings.TrimSpace(string(data))
+	}
+	return e
+}
@@ -0,0 +1,192 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// EvaluationModeHeader is the request header that tells the service how
+// the submitted ratings were collected.
+const EvaluationModeHeader = "X-Evaluation-Mode"
+
+// EvaluationMode is the way the variants of a phase are evaluated.
+type EvaluationMode string
+
+// All evaluation modes.
+const (
+	ModeScore    EvaluationMode = "score"
+	ModeRanking  EvaluationMode = "ranking"
+	ModePairwise EvaluationMode = "pairwise"
+	ModeBestOfN  EvaluationMode = "best-of-n"
+)
+
+// EvaluationModes returns all evaluation modes.
+func EvaluationModes() []EvaluationMode {
+	return []EvaluationMode{ModeScore, ModeRanking, ModePairwise, ModeBestOfN}
+}
+
+// ParseEvaluationMode parses an evaluation mode from its name.
+func ParseEvaluationMode(s string) (EvaluationMode, error) {
+	for _, m := range EvaluationModes() {
+		if s == string(m) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("polyreduce: invalid evaluation mode %q, expect one of %v", s, EvaluationModes())
+}
+
+// Evaluation is an evaluation of the variants of a phase. Every
+// evaluation is converted to ratings, which are what the optimizer of
+// the service consumes.
+type Evaluation interface {
+	// Mode returns the mode of the evaluation.
+	Mode() EvaluationMode
+	// Ratings converts the evaluation of the given variants to ratings.
+	Ratings(variants []string) (map[string]Rating, error)
+}
+
+// Scores is an evaluation that rates variants on the absolute scale.
+type Scores map[string]Rating
+
+// Mode implements Evaluation.
+func (s Scores) Mode() EvaluationMode { return ModeScore }
+
+// Ratings implements Evaluation.
+func (s Scores) Ratings(variants []string) (map[string]Rating, error) {
+	ratings := make(map[string]Rating, len(s))
+	for id, r := range s {
+		if err := checkVariant(variants, id); err != nil {
+			return nil, err
+		}
+		if !r.Valid() {
+			return nil, fmt.Errorf("polyreduce: invalid rating of model %s: %v", id, r)
+		}
+		ratings[id] = r
+	}
+	return ratings, nil
+}
+
+// Ranking is an evaluation that orders all variants of a phase from the
+// best to the worst. The ranks are spread evenly over the scale from
+// Excellent to Terrible, e.g. four variants are rated Excellent, Good,
+// Poor and Terrible.
+type Ranking []string
+
+// Mode implements Evaluation.
+func (r Ranking) Mode() EvaluationMode { return ModeRanking }
+
+// Ratings implements Evaluation.
+func (r Ranking) Ratings(variants []string) (map[string]Rating, error) {
+	if len(r) != len(variants) {
+		return nil, fmt.Errorf("polyreduce: a ranking must order all %d variants, got %d", len(variants), len(r))
+	}
+	ratings := make(map[string]Rating, len(r))
+	for i, id := range r {
+		if err := checkVariant(variants, id); err != nil {
+			return nil, err
+		}
+		if _, ok := ratings[id]; ok {
+			return nil, fmt.Errorf("polyreduce: model %s is ranked twice", id)
+		}
+		ratings[id] = spread(float64(len(r)-1-i), float64(len(r)-1))
+	}
+	return ratings, nil
+}
+
+// Preference is a judgment that the Better variant is better than the
+// Worse variant.
+type Preference struct {
+	Better, Worse string
+}
+
+// Pairwise is an evaluation that consists of pairwise judgments. Every
+// judged variant is scored by the number of comparisons it won minus the
+// number of comparisons it lost, and the scores are spread over the
+// scale from Terrible to Excellent. Variants that are not judged stay
+// unrated.
+type Pairwise []Preference
+
+// Mode implements Evaluation.
+func (p Pairwise) Mode() EvaluationMode { return ModePairwise }
+
+// Ratings implements Evaluation.
+func (p Pairwise) Ratings(variants []string) (map[string]Rating, error) {
+	if len(p) == 0 {
+		return nil, errors.New("polyreduce: no pairwise judgment")
+	}
+	wins := map[string]float64{}
+	for _, pref := range p {
+		for _, id := range []string{pref.Better, pref.Worse} {
+			if err := checkVariant(variants, id); err != nil {
+				return nil, err
+			}
+		}
+		if pref.Better == pref.Worse {
+			return nil, fmt.Errorf("polyreduce: model %s is compared with itself", pref.Better)
+		}
+		wins[pref.Better]++
+		wins[pref.Worse]--
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, w := range wins {
+		min, max = math.Min(min, w), math.Max(max, w)
+	}
+	ratings := make(map[string]Rating, len(wins))
+	for id, w := range wins {
+		ratings[id] = spread(w-min, max-min)
+	}
+	return ratings, nil
+}
+
+// BestOfN is an evaluation that picks the best variant of the
+// candidates, or of all variants of a phase if there are no candidates.
+// The best variant is rated Excellent and the other candidates Poor.
+type BestOfN struct {
+	Best       string
+	Candidates []string
+}
+
+// Mode implements Evaluation.
+func (b BestOfN) Mode() EvaluationMode { return ModeBestOfN }
+
+// Ratings implements Evaluation.
+func (b BestOfN) Ratings(variants []string) (map[string]Rating, error) {
+	candidates := b.Candidates
+	if len(candidates) == 0 {
+		candidates = variants
+	}
+	if !contains(candidates, b.Best) {
+		return nil, fmt.Errorf("polyreduce: the best model %s is not a candidate", b.Best)
+	}
+	ratings := make(map[string]Rating, len(candidates))
+	for _, id := range candidates {
+		if err := checkVariant(variants, id); err != nil {
+			return nil, err
+		}
+		ratings[id] = Poor
+	}
+	ratings[b.Best] = Excellent
+	return ratings, nil
+}
+
+// spread maps x of [0, n] linearly to the scale from Terrible to
+// Excellent. Fair is returned if n is zero.
+func spread(x, n float64) Rating {
+	if n == 0 {
+		return Fair
+	}
+	return Terrible + Rating(math.Round(x/n*float64(Excellent-Terrible)))
+}
+
+func checkVariant(variants []string, id string) error {
+	if !contains(variants, id) {
+		return fmt.Errorf("polyreduce: model %s is not a variant of the current phase", id)
+	}
+	return nil
+}
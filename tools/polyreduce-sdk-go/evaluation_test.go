@@ -0,0 +1,133 @@
+package polyreduce_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestEvaluation(t *testing.T) {
+	variants := []string{"a", "b", "c", "d"}
+	tests := []struct {
+		name string
+		e    polyreduce.Evaluation
+		want map[string]polyreduce.Rating
+	}{
+		{
+			name: "scores",
+			e:    polyreduce.Scores{"a": polyreduce.Skip, "c": polyreduce.Good},
+			want: map[string]polyreduce.Rating{"a": polyreduce.Skip, "c": polyreduce.Good},
+		},
+		{
+			name: "ranking",
+			e:    polyreduce.Ranking{"c", "a", "d", "b"},
+			want: map[string]polyreduce.Rating{
+				"c": polyreduce.Excellent, "a": polyreduce.Good,
+				"d": polyreduce.Poor, "b": polyreduce.Terrible,
+			},
+		},
+		{
+			name: "pairwise",
+			e: polyreduce.Pairwise{
+				{Better: "a", Worse: "b"},
+				{Better: "a", Worse: "c"},
+				{Better: "c", Worse: "b"},
+			},
+			want: map[string]polyreduce.Rating{
+				"a": polyreduce.Excellent, "c": polyreduce.Fair, "b": polyreduce.Terrible,
+			},
+		},
+		{
+			name: "pairwise cycle",
+			e: polyreduce.Pairwise{
+				{Better: "a", Worse: "b"},
+				{Better: "b", Worse: "a"},
+			},
+			want: map[string]polyreduce.Rating{"a": polyreduce.Fair, "b": polyreduce.Fair},
+		},
+		{
+			name: "best of all",
+			e:    polyreduce.BestOfN{Best: "b"},
+			want: map[string]polyreduce.Rating{
+				"a": polyreduce.Poor, "b": polyreduce.Excellent,
+				"c": polyreduce.Poor, "d": polyreduce.Poor,
+			},
+		},
+		{
+			name: "best of two",
+			e:    polyreduce.BestOfN{Best: "d", Candidates: []string{"a", "d"}},
+			want: map[string]polyreduce.Rating{"a": polyreduce.Poor, "d": polyreduce.Excellent},
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.e.Ratings(variants)
+		if err != nil {
+			t.Fatalf("%s: failed to convert: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: want %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	invalid := []polyreduce.Evaluation{
+		polyreduce.Scores{"x": polyreduce.Good},
+		polyreduce.Scores{"a": polyreduce.Unrated},
+		polyreduce.Ranking{"a", "b", "c"},
+		polyreduce.Ranking{"a", "b", "c", "c"},
+		polyreduce.Pairwise{},
+		polyreduce.Pairwise{{Better: "a", Worse: "a"}},
+		polyreduce.BestOfN{Best: "x"},
+		polyreduce.BestOfN{Best: "a", Candidates: []string{"b"}},
+	}
+	for _, e := range invalid {
+		if _, err := e.Ratings(variants); err == nil {
+			t.Errorf("%v should be an invalid %s evaluation", e, e.Mode())
+		}
+	}
+}
+
+func TestPromptRater_Modes(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	tests := []struct {
+		mode  polyreduce.EvaluationMode
+		input string
+		// best is the one-based number of the best variant.
+		best int
+	}{
+		{polyreduce.ModeRanking, "1 2\n3 1 4 2\n", 3},
+		{polyreduce.ModePairwise, "2\n3\n4\nx\n2\n2\n\n", 2},
+		{polyreduce.ModeBestOfN, "9\n4\n", 4},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			ss, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+			if err != nil {
+				t.Fatalf("failed to start session: %v", err)
+			}
+			r := polyreduce.NewPromptRater(strings.NewReader(tt.input), new(bytes.Buffer))
+			r.Mode = tt.mode
+			o := &polyreduce.Optimizer{Session: ss, Rater: r, MaxIterations: 1}
+			if _, err := o.Run(ctx); err != nil {
+				t.Fatalf("failed to optimize: %v", err)
+			}
+
+			p := ss.Current()
+			if p.Mode != tt.mode || p.Rating(p.IDs[tt.best-1]) != polyreduce.Excellent {
+				t.Fatalf("variant %d should be the best, got %v", tt.best, p.Ratings)
+			}
+			snap, _ := s.Session(ss.ID)
+			if snap.Modes[0] != tt.mode {
+				t.Fatalf("the service should record the mode, got %v", snap.Modes)
+			}
+		})
+	}
+}
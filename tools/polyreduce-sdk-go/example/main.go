@@ -10,96 +10,43 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"log"
 	"os"
-	"strings"
+	"path/filepath"
 
 	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
 )
 
 var client = polyreduce.NewClient()
 
-func simplify(modelPath, modelID string, ratio map[string]float64) {
-	// 1. upload config
-	err := client.PolyredConfig(context.Background(), &polyreduce.PolyredConfigInput{
-		ModelID:        modelID,
-		ReductionRatio: ratio,
-	})
-	if err != nil {
-		panic(err)
-	}
-
-	// 2. run simplification
-	err = client.PolyredRun(context.Background(), &polyreduce.PolyredRunInput{
-		ModelID: modelID,
-	})
+// process reduces a model to the ratios 1..99 of all its layers. The
+// reduced models are saved next to the model as <model>_<ratio>.fbx,
+// which skips models that were already reduced.
+func process(model string) error {
+	ratios, err := polyreduce.RatioRange(1, 99, 1)
 	if err != nil {
-		panic(err)
-	}
-
-	// 3. download models back
-	p := 0.0
-	for _, v := range ratio {
-		p += v
+		return err
 	}
-	p /= float64(len(ratio))
-
-	err = client.PolyredDownload(context.Background(), &polyreduce.DownloadInput{
-		ModelID: modelID,
-		Path:    fmt.Sprintf(modelPath+"_%v.fbx", p),
+	o, err := client.Sweep(context.Background(), &polyreduce.SweepInput{
+		ModelPath:   model + "_0.fbx",
+		Ratios:      ratios,
+		Dir:         filepath.Dir(model),
+		Concurrency: 4,
+		Progress: func(r *polyreduce.SweepResult) {
+			log.Printf("%s: ratio %v, %d faces, err: %v", model, r.Ratio, r.Faces, r.Err)
+		},
 	})
-	if err != nil {
-		panic(err)
-	}
-}
-
-type UploadOutput struct {
-	ID  string `json:"id"`
-	Msg string `json:"msg"`
-}
-
-type ConfigInput struct {
-	Percent map[string]float64 `json:"percent"`
-}
-
-func process(model string) {
-	modelPath := model + "_0.obj"
-	b, err := os.ReadFile(modelPath)
-	if err != nil {
-		panic(err)
-	}
-	layers := []string{}
-	all := strings.Split(string(b), "\n")
-	for i := range all {
-		if !strings.HasPrefix(all[i], "o ") {
-			continue
+	if o != nil {
+		f, ferr := os.Create(filepath.Join(filepath.Dir(model), "manifest.csv"))
+		if ferr != nil {
+			return ferr
 		}
-
-		layers = append(layers, strings.TrimPrefix(all[i], "o "))
-	}
-
-	modelPath = model + "_0.fbx"
-
-	o, err := client.PolyredUpload(context.Background(), &polyreduce.PolyredUploadInput{
-		ModelPath: modelPath,
-	})
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Println(o.ModelId)
-	configs := []*ConfigInput{}
-	for i := 1; i < 100; i++ {
-		m := map[string]float64{}
-		for _, l := range layers {
-			m[l] = float64(i)
+		defer f.Close()
+		if werr := o.WriteCSV(f); werr != nil {
+			return werr
 		}
-		configs = append(configs, &ConfigInput{Percent: m})
-	}
-
-	for _, conf := range configs {
-		simplify(model, o.ModelId, conf.Percent)
 	}
+	return err
 }
 
 func main() {
@@ -111,7 +58,14 @@ func main() {
 		"../dataset/reduces/rose/rose",
 	}
 
+	failed := false
 	for _, model := range models {
-		process(model)
+		if err := process(model); err != nil {
+			log.Printf("failed to process %s: %v", model, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
 	}
 }
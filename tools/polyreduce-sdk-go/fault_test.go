@@ -0,0 +1,277 @@
+package polyreduce_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+// fixture prepares the state that is required by every operation.
+type fixture struct {
+	dir string
+	// model is a configured and simplified model.
+	model string
+	// fresh is a session without phases.
+	fresh string
+	// running is a session with an unevaluated phase.
+	running string
+	variant string
+}
+
+func newFixture(t *testing.T, s *polyreducetest.Server) *fixture {
+	t.Helper()
+	c := s.Client()
+	ctx := context.Background()
+	f := &fixture{dir: t.TempDir()}
+
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	f.model = o.ModelId
+	err = c.PolyredConfig(ctx, &polyreduce.PolyredConfigInput{
+		ModelID:        f.model,
+		ReductionRatio: map[string]float64{"default": 50},
+	})
+	if err != nil {
+		t.Fatalf("failed to config: %v", err)
+	}
+	if err := c.PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: f.model}); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+
+	for _, id := range []*string{&f.fresh, &f.running} {
+		o, err := c.ProPolyredUpload(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+		if err != nil {
+			t.Fatalf("failed to upload: %v", err)
+		}
+		*id = o.SessionId
+	}
+	run, err := c.ProPolyredRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: f.running})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	f.variant = run.Phases[0]
+	return f
+}
+
+type operation struct {
+	name       string
+	route      string
+	idempotent bool
+	download   bool
+	call       func(ctx context.Context, c *polyreduce.Client, f *fixture) error
+}
+
+var operations = []operation{
+	{
+		name: "Ping", route: polyreducetest.RoutePing, idempotent: true,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.Ping(ctx)
+			return err
+		},
+	},
+	{
+		name: "PolyredUpload", route: polyreducetest.RoutePolyredUpload,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+			return err
+		},
+	},
+	{
+		name: "PolyredConfig", route: polyreducetest.RoutePolyredConfig,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			return c.PolyredConfig(ctx, &polyreduce.PolyredConfigInput{
+				ModelID:        f.model,
+				ReductionRatio: map[string]float64{"default": 20},
+			})
+		},
+	},
+	{
+		name: "PolyredLayers", route: polyreducetest.RoutePolyredLayers, idempotent: true,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.PolyredLayers(ctx, &polyreduce.PolyredLayersInput{ModelID: f.model})
+			return err
+		},
+	},
+	{
+		name: "PolyredRun", route: polyreducetest.RoutePolyredSubmitRun,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			return c.PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: f.model})
+		},
+	},
+	{
+		name: "PolyredDownload", route: polyreducetest.RoutePolyredDownload, idempotent: true, download: true,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.PolyredDownload(ctx, &polyreduce.DownloadInput{
+				ModelID: f.model,
+				Path:    filepath.Join(f.dir, "polyred.fbx"),
+			})
+			return err
+		},
+	},
+	{
+		name: "ProPolyredUpload", route: polyreducetest.RouteProPolyredUpload,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.ProPolyredUpload(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+			return err
+		},
+	},
+	{
+		name: "ProPolyredRun", route: polyreducetest.RouteProPolyredSubmitRun,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.ProPolyredRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: f.fresh})
+			return err
+		},
+	},
+	{
+		name: "ProPolyredDownload", route: polyreducetest.RouteProPolyredDownload, idempotent: true, download: true,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.ProPolyredDownload(ctx, &polyreduce.ProPolyredDownloadInput{
+				SessionId: f.running,
+				PhaseId:   f.variant,
+				Path:      filepath.Join(f.dir, "propolyred.fbx"),
+			})
+			return err
+		},
+	},
+	{
+		name: "ProPolyredInspect", route: polyreducetest.RouteProPolyredInspect, idempotent: true,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.ProPolyredInspect(ctx, &polyreduce.ProPolyredInspectInput{SessionId: f.running})
+			return err
+		},
+	},
+	{
+		name: "ProPolyredEvaluate", route: polyreducetest.RouteProPolyredEvaluate,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			return c.ProPolyredEvaluate(ctx, &polyreduce.ProPolyredEvaluateInput{
+				SessionId: f.running,
+				Rating:    map[string]polyreduce.Rating{f.variant: polyreduce.Good},
+			})
+		},
+	},
+	{
+		name: "ProPolyredReset", route: polyreducetest.RouteProPolyredReset,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.ProPolyredReset(ctx, &polyreduce.ProPolyredResetInput{SessionId: f.running})
+			return err
+		},
+	},
+	{
+		name: "ProPolyredCopy", route: polyreducetest.RouteProPolyredCopy,
+		call: func(ctx context.Context, c *polyreduce.Client, f *fixture) error {
+			_, err := c.ProPolyredCopy(ctx, &polyreduce.ProPolyredCopyInput{SessionId: f.running})
+			return err
+		},
+	},
+}
+
+func TestFault(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault polyreducetest.Fault
+		// check verifies the error of the operation under the fault.
+		check func(op operation, err error) bool
+	}{
+		{
+			name:  "transient 503",
+			fault: polyreducetest.Fault{Status: http.StatusServiceUnavailable, Times: 1},
+			check: func(op operation, err error) bool {
+				if op.idempotent {
+					return err == nil
+				}
+				return errors.Is(err, polyreduce.ErrServerBusy)
+			},
+		},
+		{
+			name:  "random 5xx",
+			fault: polyreducetest.Fault{ErrorRate: 1},
+			check: func(op operation, err error) bool {
+				var e *polyreduce.APIError
+				return errors.As(err, &e) && e.StatusCode >= 500
+			},
+		},
+		{
+			name:  "reset mid-body",
+			fault: polyreducetest.Fault{Reset: true, ResetAfter: 8, Times: 1},
+			check: func(op operation, err error) bool {
+				return (err == nil) == op.idempotent
+			},
+		},
+		{
+			name:  "malformed JSON",
+			fault: polyreducetest.Fault{MalformedJSON: true},
+			check: func(op operation, err error) bool {
+				return (err == nil) == op.download
+			},
+		},
+		{
+			name: "HTML page of a proxy",
+			fault: polyreducetest.Fault{
+				Body:        "<html><body>Bad Gateway</body></html>",
+				ContentType: "text/html",
+			},
+			check: func(op operation, err error) bool {
+				if op.download {
+					return errors.Is(err, polyreduce.ErrInvalidModel)
+				}
+				return err != nil
+			},
+		},
+		{
+			name:  "latency",
+			fault: polyreducetest.Fault{Latency: 200 * time.Millisecond},
+			check: func(op operation, err error) bool {
+				return err != nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, op := range operations {
+			tt, op := tt, op
+			t.Run(tt.name+"/"+op.name, func(t *testing.T) {
+				s := polyreducetest.NewServer()
+				defer s.Close()
+				f := newFixture(t, s)
+
+				s.Inject(op.route, tt.fault)
+				c := s.Client(polyreduce.WithTimeout(50 * time.Millisecond))
+				err := op.call(context.Background(), c, f)
+				if !tt.check(op, err) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if files, _ := os.ReadDir(f.dir); err != nil && len(files) != 0 {
+					t.Fatalf("a failed call should not leave files, got %v", files)
+				}
+			})
+		}
+	}
+}
+
+func TestFault_RateLimit(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	s.Inject(polyreducetest.RoutePing, polyreducetest.Fault{RetryAfter: time.Second, Times: 1})
+
+	var backoff time.Duration
+	c := s.Client(polyreduce.WithRetryHook(func(e polyreduce.RetryEvent) {
+		backoff = e.Backoff
+	}))
+	_, err := c.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("ping should succeed after the rate limit: %v", err)
+	}
+	if backoff != time.Second {
+		t.Fatalf("the retry should respect Retry-After, got %v", backoff)
+	}
+}
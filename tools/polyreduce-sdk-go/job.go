@@ -0,0 +1,158 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Errors of a job that did not succeed.
+var (
+	ErrJobFailed   = errors.New("polyreduce: job failed")
+	ErrJobCanceled = errors.New("polyreduce: job canceled")
+)
+
+// DefaultPollInterval is the interval of polling the status of a job
+// if no other interval is configured.
+const DefaultPollInterval = 2 * time.Second
+
+// WithPollInterval sets the interval of polling the status of a job
+// while waiting for it.
+func WithPollInterval(d time.Duration) Option {
+	return func(c *Client) { c.pollInterval = d }
+}
+
+// JobState is the state of a job.
+type JobState string
+
+// All states of a job.
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// Done reports whether the job is finished in the given state.
+func (s JobState) Done() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// JobStatus is the status of a job.
+type JobStatus struct {
+	JobId   string   `json:"id"`
+	State   JobState `json:"state"`
+	Message string   `json:"msg,omitempty"`
+
+	// The result of a succeeded propolyred run.
+	Phases         []string `json:"ids,omitempty"`
+	AssumedOptimal float64  `json:"optimal,omitempty"`
+}
+
+// Job is a handle of a job that runs on the polyreduce service.
+type Job struct {
+	// ID is the ID of the job.
+	ID string
+
+	c *Client
+}
+
+// Job returns the handle of an existing job with the given ID.
+func (c *Client) Job(id string) *Job {
+	return &Job{ID: id, c: c}
+}
+
+// SubmitRun submits the simplification of a model as a job. It returns
+// as soon as the job is accepted by the service.
+func (c *Client) SubmitRun(ctx context.Context, i *PolyredRunInput) (*Job, error) {
+	return c.submit(ctx, "/jobs/polyred/run/"+i.ModelID)
+}
+
+// ProPolyredSubmitRun submits the run of the next phase of a propolyred
+// session as a job. It returns as soon as the job is accepted by the
+// service.
+func (c *Client) ProPolyredSubmitRun(ctx context.Context, i *ProPolyredRunInput) (*Job, error) {
+	return c.submit(ctx, "/jobs/propolyred/run/"+i.SessionId)
+}
+
+func (c *Client) submit(ctx context.Context, path string) (*Job, error) {
+	o := &JobStatus{}
+	_, err := c.doJSON(ctx, &request{
+		method: http.MethodPost,
+		path:   path,
+	}, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.JobId == "" {
+		return nil, errors.New("polyreduce: the service did not return a job ID")
+	}
+	return c.Job(o.JobId), nil
+}
+
+// Status returns the current status of the job.
+func (j *Job) Status(ctx context.Context) (*JobStatus, error) {
+	o := &JobStatus{}
+	_, err := j.c.doJSON(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/jobs/" + j.ID,
+		idempotent: true,
+	}, o)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Wait polls the status of the job until it is finished. It returns the
+// final status if the job succeeded, or an error that wraps ErrJobFailed
+// or ErrJobCanceled otherwise.
+//
+// If the context is done before, Wait returns the context error and the
+// job keeps running on the service.
+func (j *Job) Wait(ctx context.Context) (*JobStatus, error) {
+	interval := j.c.pollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		s, err := j.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch s.State {
+		case JobSucceeded:
+			return s, nil
+		case JobFailed:
+			return nil, fmt.Errorf("%w: job %s: %s", ErrJobFailed, j.ID, s.Message)
+		case JobCanceled:
+			return nil, fmt.Errorf("%w: job %s", ErrJobCanceled, j.ID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Cancel cancels the job. Canceling a finished job has no effect.
+// The response body, if any, is ignored.
+func (j *Job) Cancel(ctx context.Context) error {
+	return j.c.do(ctx, &request{
+		method:     http.MethodDelete,
+		path:       "/jobs/" + j.ID,
+		idempotent: true,
+	}, func(resp *http.Response) error { return nil })
+}
@@ -0,0 +1,141 @@
+package polyreduce_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestJob(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.RunDuration = 50 * time.Millisecond
+	c := s.Client()
+	ctx := context.Background()
+
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	err = c.PolyredConfig(ctx, &polyreduce.PolyredConfigInput{
+		ModelID:        o.ModelId,
+		ReductionRatio: map[string]float64{"default": 50},
+	})
+	if err != nil {
+		t.Fatalf("failed to config: %v", err)
+	}
+
+	job, err := c.SubmitRun(ctx, &polyreduce.PolyredRunInput{ModelID: o.ModelId})
+	if err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+	st, err := job.Status(ctx)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if st.State.Done() {
+		t.Fatalf("the job should be still running, got %s", st.State)
+	}
+	st, err = job.Wait(ctx)
+	if err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if st.State != polyreduce.JobSucceeded {
+		t.Fatalf("want succeeded, got %s", st.State)
+	}
+	if m, _ := s.Model(o.ModelId); !m.Reduced {
+		t.Fatalf("the model should be reduced")
+	}
+}
+
+func TestJob_Cancel(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.RunDuration = time.Hour
+	c := s.Client()
+	ctx := context.Background()
+
+	o, err := c.ProPolyredUpload(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	job, err := c.ProPolyredSubmitRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: o.SessionId})
+	if err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+	if err := job.Cancel(ctx); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+	_, err = job.Wait(ctx)
+	if !errors.Is(err, polyreduce.ErrJobCanceled) {
+		t.Fatalf("want ErrJobCanceled, got %v", err)
+	}
+	if ss, _ := s.Session(o.SessionId); len(ss.Phases) != 0 {
+		t.Fatalf("a canceled run should not produce a phase")
+	}
+}
+
+func TestJob_CancelNoContent(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	// A service that confirms the cancellation without a body.
+	s.Handle(polyreducetest.RouteJobCancel, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	if err := c.Job("job-1").Cancel(ctx); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+}
+
+func TestJob_LegacyAPI(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	// A service without the jobs API.
+	noJobs := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	s.Handle(polyreducetest.RoutePolyredSubmitRun, noJobs)
+	s.Handle(polyreducetest.RouteProPolyredSubmitRun, noJobs)
+
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	err = c.PolyredConfig(ctx, &polyreduce.PolyredConfigInput{
+		ModelID:        o.ModelId,
+		ReductionRatio: map[string]float64{"default": 50},
+	})
+	if err != nil {
+		t.Fatalf("failed to config: %v", err)
+	}
+	if err := c.PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: o.ModelId}); err != nil {
+		t.Fatalf("failed to run on the legacy API: %v", err)
+	}
+	if m, _ := s.Model(o.ModelId); !m.Reduced {
+		t.Fatalf("the model should be reduced")
+	}
+	err = c.PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: "missing"})
+	if !errors.Is(err, polyreduce.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+
+	up, err := c.ProPolyredUpload(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	run, err := c.ProPolyredRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: up.SessionId})
+	if err != nil || len(run.Phases) == 0 {
+		t.Fatalf("failed to run on the legacy API: %v, %+v", err, run)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/mesh"
+)
+
+// LayerRatio is the reduction ratio of the layers whose mesh names match
+// Pattern, which is either a mesh name or a glob pattern as of
+// path.Match, e.g. Wheel*. The pattern * matches all layers.
+type LayerRatio struct {
+	Pattern string
+	Ratio   float64
+}
+
+// ParseLayerRatio parses a layer ratio in the form of pattern=ratio.
+func ParseLayerRatio(s string) (LayerRatio, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return LayerRatio{}, fmt.Errorf("polyreduce: invalid layer ratio %q, expect name=ratio", s)
+	}
+	ratio, err := strconv.ParseFloat(s[i+1:], 64)
+	if err != nil {
+		return LayerRatio{}, fmt.Errorf("polyreduce: invalid layer ratio %q: %w", s, err)
+	}
+	return LayerRatio{Pattern: s[:i], Ratio: ratio}, nil
+}
+
+// ModelLayers returns the mesh names of an uploaded model that the layer
+// ratios are resolved against, see ResolveReductionRatio. The names are
+// listed by PolyredLayers. A service without that API reports
+// ErrNotFound, in which case the patterns are taken as mesh names if
+// none of them is a glob pattern, and otherwise the mesh names are read
+// from the FBX file at path, if given.
+func (c *Client) ModelLayers(ctx context.Context, modelID, path string, ratios []LayerRatio) ([]string, error) {
+	o, err := c.PolyredLayers(ctx, &PolyredLayersInput{ModelID: modelID})
+	if err == nil {
+		return o.Layers, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	// Either the model or the layers API does not exist. A missing
+	// model is reported once it is configured.
+	if layers, ok := literalLayers(ratios); ok {
+		return layers, nil
+	}
+	if path == "" {
+		return nil, err
+	}
+	m, merr := mesh.Load(path)
+	if merr != nil {
+		return nil, fmt.Errorf("%w, and the layers of %s are unknown: %v", err, path, merr)
+	}
+	layers := make([]string, len(m.Layers))
+	for i, l := range m.Layers {
+		layers[i] = l.Name
+	}
+	return layers, nil
+}
+
+// literalLayers returns the patterns of the layer ratios if all of them
+// are mesh names rather than glob patterns.
+func literalLayers(ratios []LayerRatio) ([]string, bool) {
+	layers := make([]string, 0, len(ratios))
+	for _, r := range ratios {
+		if strings.ContainsAny(r.Pattern, `*?[\`) {
+			return nil, false
+		}
+		layers = append(layers, r.Pattern)
+	}
+	return layers, true
+}
+
+// ResolveReductionRatio resolves layer ratios against the mesh names of
+// a model, see PolyredLayers, and returns the reduction ratio of every
+// matched layer.
+//
+// A layer takes the ratio of the most specific matching pattern: a mesh
+// name wins over a glob pattern, which wins over *. Matching glob
+// patterns of different ratios are ambiguous. Of multiple layer ratios
+// with the same pattern, the last one wins. A pattern that matches no
+// layer is an error, so that a typo does not silently keep a layer
+// unreduced.
+func ResolveReductionRatio(layers []string, ratios []LayerRatio) (map[string]float64, error) {
+	if len(ratios) == 0 {
+		return nil, fmt.Errorf("polyreduce: no reduction ratio is given")
+	}
+	patterns := map[string]float64{}
+	for _, r := range ratios {
+		if r.Ratio < 0 || r.Ratio > 100 {
+			return nil, fmt.Errorf("polyreduce: invalid reduction ratio of %q: %v, expect 0 to 100", r.Pattern, r.Ratio)
+		}
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("polyreduce: invalid layer pattern %q: %w", r.Pattern, err)
+		}
+		patterns[r.Pattern] = r.Ratio
+	}
+
+	// specificity ranks the patterns that match a layer.
+	specificity := func(pattern, layer string) int {
+		switch {
+		case pattern == layer:
+			return 3
+		case pattern == "*":
+			return 1
+		}
+		if ok, _ := path.Match(pattern, layer); ok {
+			return 2
+		}
+		return 0
+	}
+
+	config := map[string]float64{}
+	matched := map[string]bool{}
+	for _, layer := range layers {
+		best, from := 0, []string{}
+		for pattern := range patterns {
+			s := specificity(pattern, layer)
+			if s == 0 {
+				continue
+			}
+			matched[pattern] = true
+			if s > best {
+				best, from = s, from[:0]
+			}
+			if s == best {
+				from = append(from, pattern)
+			}
+		}
+		if best == 0 {
+			continue
+		}
+		sort.Strings(from)
+		for _, pattern := range from[1:] {
+			if patterns[pattern] != patterns[from[0]] {
+				return nil, fmt.Errorf("polyreduce: ambiguous reduction ratio of layer %q, patterns %q and %q differ", layer, from[0], pattern)
+			}
+		}
+		config[layer] = patterns[from[0]]
+	}
+
+	var unknown []string
+	for pattern := range patterns {
+		if !matched[pattern] {
+			unknown = append(unknown, strconv.Quote(pattern))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("polyreduce: no layer matches %s, the layers are %q", strings.Join(unknown, ", "), layers)
+	}
+	return config, nil
+}
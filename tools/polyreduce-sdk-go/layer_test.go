@@ -0,0 +1,152 @@
+package polyreduce_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestResolveReductionRatio(t *testing.T) {
+	layers := []string{"Body", "Wheel_FL", "Wheel_FR", "Window"}
+	tests := []struct {
+		name   string
+		ratios []string
+		want   map[string]float64
+		err    string
+	}{
+		{
+			name:   "name",
+			ratios: []string{"Body=50"},
+			want:   map[string]float64{"Body": 50},
+		},
+		{
+			name:   "pattern",
+			ratios: []string{"Wheel*=30", "W*w=10"},
+			want:   map[string]float64{"Wheel_FL": 30, "Wheel_FR": 30, "Window": 10},
+		},
+		{
+			name:   "specificity",
+			ratios: []string{"Wheel_FR=20", "*=80", "Wheel*=30"},
+			want:   map[string]float64{"Body": 80, "Wheel_FL": 30, "Wheel_FR": 20, "Window": 80},
+		},
+		{
+			name:   "last wins",
+			ratios: []string{"Body=50", "Body=60"},
+			want:   map[string]float64{"Body": 60},
+		},
+		{
+			name:   "name resolves ambiguity",
+			ratios: []string{"W*=10", "*_FL=20", "Wheel_FL=30"},
+			want:   map[string]float64{"Wheel_FL": 30, "Wheel_FR": 10, "Window": 10},
+		},
+		{name: "ambiguous", ratios: []string{"W*=10", "*_FL=20"}, err: `ambiguous reduction ratio of layer "Wheel_FL"`},
+		{name: "unknown", ratios: []string{"Body=10", "Wheels=20", "Tire*=5"}, err: `no layer matches "Tire*", "Wheels"`},
+		{name: "invalid ratio", ratios: []string{"Body=120"}, err: "invalid reduction ratio"},
+		{name: "invalid pattern", ratios: []string{"[Body=10"}, err: "invalid layer pattern"},
+		{name: "empty", err: "no reduction ratio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ratios []polyreduce.LayerRatio
+			for _, s := range tt.ratios {
+				r, err := polyreduce.ParseLayerRatio(s)
+				if err != nil {
+					t.Fatalf("failed to parse %q: %v", s, err)
+				}
+				ratios = append(ratios, r)
+			}
+			got, err := polyreduce.ResolveReductionRatio(layers, ratios)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("want error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to resolve: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	for _, s := range []string{"Body", "=10", "Body=ten"} {
+		if _, err := polyreduce.ParseLayerRatio(s); err == nil {
+			t.Fatalf("%q should be rejected", s)
+		}
+	}
+}
+
+func TestPolyredLayers(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"Body", "Wheel"}
+
+	ctx := context.Background()
+	c := s.Client()
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	layers, err := c.PolyredLayers(ctx, &polyreduce.PolyredLayersInput{ModelID: o.ModelId})
+	if err != nil {
+		t.Fatalf("failed to list layers: %v", err)
+	}
+	if !reflect.DeepEqual(layers.Layers, s.Layers) {
+		t.Fatalf("want layers %v, got %v", s.Layers, layers.Layers)
+	}
+	if _, err := c.PolyredLayers(ctx, &polyreduce.PolyredLayersInput{ModelID: "missing"}); err == nil {
+		t.Fatalf("a missing model should have no layers")
+	}
+}
+
+func TestModelLayers(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"Suzanne"}
+
+	ctx := context.Background()
+	c := s.Client()
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	name := []polyreduce.LayerRatio{{Pattern: "Body", Ratio: 10}}
+	all := []polyreduce.LayerRatio{{Pattern: "*", Ratio: 10}}
+	layers, err := c.ModelLayers(ctx, o.ModelId, "", name)
+	if err != nil || !reflect.DeepEqual(layers, s.Layers) {
+		t.Fatalf("want the layers of the service %v, got %v: %v", s.Layers, layers, err)
+	}
+
+	// A service without the layers API.
+	s.Handle(polyreducetest.RoutePolyredLayers, http.NotFoundHandler())
+	tests := []struct {
+		path   string
+		ratios []polyreduce.LayerRatio
+		want   []string
+	}{
+		{"", name, []string{"Body"}},
+		{testModel, name, []string{"Body"}},
+		{testModel, all, []string{"Suzanne"}},
+		{"", all, nil},
+	}
+	for _, tt := range tests {
+		layers, err := c.ModelLayers(ctx, o.ModelId, tt.path, tt.ratios)
+		if tt.want == nil {
+			if !errors.Is(err, polyreduce.ErrNotFound) {
+				t.Fatalf("%v of %q: want ErrNotFound, got %v", tt.ratios, tt.path, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(layers, tt.want) {
+			t.Fatalf("%v of %q: want %v, got %v: %v", tt.ratios, tt.path, tt.want, layers, err)
+		}
+	}
+}
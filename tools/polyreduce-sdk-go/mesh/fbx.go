@@ -0,0 +1,307 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mesh
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// fbxMagic is the beginning of every binary FBX file.
+const fbxMagic = "Kaydara FBX Binary  \x00"
+
+// ErrNotBinaryFBX is returned when a file is not a binary FBX file,
+// e.g. an ASCII FBX file.
+var ErrNotBinaryFBX = errors.New("mesh: not a binary FBX file")
+
+// Load reads the meshes of the binary FBX file at the given path.
+func Load(path string) (*Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("mesh: cannot read %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Read reads the meshes of a binary FBX file. Every geometry of the file
+// is a layer of the returned mesh.
+func Read(r io.Reader) (*Mesh, error) {
+	d := &fbxDecoder{r: bufio.NewReader(r)}
+	header := make([]byte, len(fbxMagic)+6)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return nil, ErrNotBinaryFBX
+	}
+	if string(header[:len(fbxMagic)]) != fbxMagic {
+		return nil, ErrNotBinaryFBX
+	}
+	d.version = binary.LittleEndian.Uint32(header[len(fbxMagic)+2:])
+	d.off = uint64(len(header))
+
+	m := &Mesh{}
+	for {
+		n, err := d.node()
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			break
+		}
+		if n.name != "Objects" {
+			continue
+		}
+		for _, g := range n.children {
+			if g.name != "Geometry" {
+				continue
+			}
+			l, err := layerOf(g)
+			if err != nil {
+				return nil, err
+			}
+			if l != nil {
+				m.Layers = append(m.Layers, l)
+			}
+		}
+	}
+	return m, nil
+}
+
+// layerOf converts a geometry node to a layer, or returns nil if the
+// geometry is not a mesh.
+func layerOf(g *fbxNode) (*Layer, error) {
+	l := &Layer{}
+	if len(g.props) > 1 {
+		// The name is stored as "<name>\x00\x01Geometry".
+		name, _ := g.props[1].(string)
+		l.Name, _, _ = strings.Cut(name, "\x00\x01")
+	}
+	var vertices []float64
+	var indices []int32
+	for _, c := range g.children {
+		if len(c.props) == 0 {
+			continue
+		}
+		switch c.name {
+		case "Vertices":
+			v, ok := c.props[0].([]float64)
+			if !ok {
+				return nil, fmt.Errorf("mesh: invalid vertices of geometry %q", l.Name)
+			}
+			vertices = v
+		case "PolygonVertexIndex":
+			i, ok := c.props[0].([]int32)
+			if !ok {
+				return nil, fmt.Errorf("mesh: invalid polygons of geometry %q", l.Name)
+			}
+			indices = i
+		}
+	}
+	if vertices == nil {
+		return nil, nil
+	}
+	if len(vertices)%3 != 0 {
+		return nil, fmt.Errorf("mesh: invalid vertices of geometry %q", l.Name)
+	}
+	l.Vertices = make([]Vec3, len(vertices)/3)
+	for i := range l.Vertices {
+		l.Vertices[i] = Vec3{vertices[3*i], vertices[3*i+1], vertices[3*i+2]}
+	}
+	// The last index of a polygon is stored as its bitwise negation.
+	for _, i := range indices {
+		if i < 0 {
+			l.Faces++
+		}
+	}
+	return l, nil
+}
+
+// fbxNode is a node record of a binary FBX file.
+type fbxNode struct {
+	name     string
+	props    []interface{}
+	children []*fbxNode
+}
+
+// fbxDecoder decodes the node records of a binary FBX file.
+type fbxDecoder struct {
+	r       *bufio.Reader
+	version uint32
+	off     uint64
+}
+
+func (d *fbxDecoder) read(v interface{}) error {
+	if err := binary.Read(d.r, binary.LittleEndian, v); err != nil {
+		return fmt.Errorf("mesh: truncated FBX file at offset %d: %w", d.off, err)
+	}
+	d.off += uint64(binary.Size(v))
+	return nil
+}
+
+func (d *fbxDecoder) bytes(n uint64) ([]byte, error) {
+	if n > 1<<30 {
+		return nil, fmt.Errorf("mesh: invalid FBX record of %d bytes at offset %d", n, d.off)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, fmt.Errorf("mesh: truncated FBX file at offset %d: %w", d.off, err)
+	}
+	d.off += n
+	return b, nil
+}
+
+// uint reads an offset or a length, whose size depends on the version.
+func (d *fbxDecoder) uint() (uint64, error) {
+	if d.version >= 7500 {
+		var v uint64
+		err := d.read(&v)
+		return v, err
+	}
+	var v uint32
+	err := d.read(&v)
+	return uint64(v), err
+}
+
+// node reads the next node record, or returns nil at a null record that
+// terminates a list of nodes.
+func (d *fbxDecoder) node() (*fbxNode, error) {
+	end, err := d.uint()
+	if err != nil {
+		return nil, err
+	}
+	nprops, err := d.uint()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.uint(); err != nil {
+		return nil, err
+	}
+	var nameLen uint8
+	if err := d.read(&nameLen); err != nil {
+		return nil, err
+	}
+	if end == 0 {
+		return nil, nil
+	}
+	name, err := d.bytes(uint64(nameLen))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &fbxNode{name: string(name)}
+	for i := uint64(0); i < nprops; i++ {
+		p, err := d.prop()
+		if err != nil {
+			return nil, err
+		}
+		n.props = append(n.props, p)
+	}
+	for d.off < end {
+		c, err := d.node()
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			break
+		}
+		n.children = append(n.children, c)
+	}
+	if d.off != end {
+		return nil, fmt.Errorf("mesh: invalid FBX record %q ends at offset %d, expect %d", n.name, d.off, end)
+	}
+	return n, nil
+}
+
+// prop reads a property of a node record. Only the properties that are
+// needed for meshes are decoded, the others are returned as nil.
+func (d *fbxDecoder) prop() (interface{}, error) {
+	var typ byte
+	if err := d.read(&typ); err != nil {
+		return nil, err
+	}
+	switch typ {
+	case 'C':
+		_, err := d.bytes(1)
+		return nil, err
+	case 'Y':
+		_, err := d.bytes(2)
+		return nil, err
+	case 'I', 'F':
+		_, err := d.bytes(4)
+		return nil, err
+	case 'D', 'L':
+		_, err := d.bytes(8)
+		return nil, err
+	case 'S', 'R':
+		var n uint32
+		if err := d.read(&n); err != nil {
+			return nil, err
+		}
+		b, err := d.bytes(uint64(n))
+		if typ == 'R' {
+			return nil, err
+		}
+		return string(b), err
+	case 'f', 'd', 'l', 'i', 'b':
+		return d.array(typ)
+	}
+	return nil, fmt.Errorf("mesh: unknown FBX property type %q at offset %d", typ, d.off-1)
+}
+
+// array reads an array property, which may be zlib compressed.
+func (d *fbxDecoder) array(typ byte) (interface{}, error) {
+	var header struct {
+		Len, Encoding, Size uint32
+	}
+	if err := d.read(&header); err != nil {
+		return nil, err
+	}
+	b, err := d.bytes(uint64(header.Size))
+	if err != nil {
+		return nil, err
+	}
+	if typ != 'd' && typ != 'i' {
+		return nil, nil
+	}
+	if header.Encoding == 1 {
+		zr, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("mesh: invalid FBX array: %w", err)
+		}
+		if b, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("mesh: invalid FBX array: %w", err)
+		}
+	}
+	size := 4
+	if typ == 'd' {
+		size = 8
+	}
+	if uint64(len(b)) != uint64(header.Len)*uint64(size) {
+		return nil, fmt.Errorf("mesh: invalid FBX array of %d elements in %d bytes", header.Len, len(b))
+	}
+	if typ == 'd' {
+		v := make([]float64, header.Len)
+		for i := range v {
+			v[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+		}
+		return v, nil
+	}
+	v := make([]int32, header.Len)
+	for i := range v {
+		v[i] = int32(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v, nil
+}
@@ -0,0 +1,199 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package mesh reads the meshes of FBX models and computes the local
+// statistics of a reduced model against its root model, such as the
+// achieved reduction ratio and the geometric error.
+//
+//	root, err := mesh.Load("root.fbx")
+//	variant, err := mesh.Load("variant.fbx")
+//	stats := mesh.Compare(root, variant)
+package mesh
+
+import (
+	"math"
+)
+
+// Vec3 is a point in 3D space.
+type Vec3 [3]float64
+
+func (v Vec3) sub(u Vec3) Vec3 { return Vec3{v[0] - u[0], v[1] - u[1], v[2] - u[2]} }
+func (v Vec3) len() float64    { return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2]) }
+
+// Layer is a named mesh of a model.
+type Layer struct {
+	// Name is the name of the mesh.
+	Name string
+	// Vertices are the vertices of the mesh.
+	Vertices []Vec3
+	// Faces is the number of polygons of the mesh.
+	Faces int
+}
+
+// Mesh are all meshes of a model.
+type Mesh struct {
+	Layers []*Layer
+}
+
+// Faces returns the number of polygons of all layers.
+func (m *Mesh) Faces() int {
+	n := 0
+	for _, l := range m.Layers {
+		n += l.Faces
+	}
+	return n
+}
+
+// Vertices returns the vertices of all layers.
+func (m *Mesh) Vertices() []Vec3 {
+	var v []Vec3
+	for _, l := range m.Layers {
+		v = append(v, l.Vertices...)
+	}
+	return v
+}
+
+// Bounds returns the corners of the axis-aligned bounding box of all
+// vertices.
+func (m *Mesh) Bounds() (min, max Vec3) {
+	first := true
+	for _, l := range m.Layers {
+		for _, v := range l.Vertices {
+			if first {
+				min, max, first = v, v, false
+				continue
+			}
+			for i := range v {
+				min[i] = math.Min(min[i], v[i])
+				max[i] = math.Max(max[i], v[i])
+			}
+		}
+	}
+	return min, max
+}
+
+// Stats are the statistics of a reduced model against its root model.
+type Stats struct {
+	// Faces is the number of polygons of the reduced model.
+	Faces int `json:"faces"`
+	// Ratio is the achieved reduction ratio in percent, i.e. the faces
+	// of the reduced model relative to the faces of the root model.
+	Ratio float64 `json:"ratio"`
+	// Error is the symmetric Hausdorff distance between the vertices of
+	// both models, relative to the diagonal of the bounding box of the
+	// root model.
+	Error float64 `json:"error"`
+}
+
+// Compare computes the statistics of a reduced model against its root
+// model.
+func Compare(root, reduced *Mesh) Stats {
+	s := Stats{Faces: reduced.Faces()}
+	if n := root.Faces(); n > 0 {
+		s.Ratio = 100 * float64(s.Faces) / float64(n)
+	}
+	min, max := root.Bounds()
+	diag := max.sub(min).len()
+	if diag == 0 {
+		return s
+	}
+	a, b := root.Vertices(), reduced.Vertices()
+	if len(a) == 0 || len(b) == 0 {
+		return s
+	}
+	d := math.Max(newGrid(b).farthest(a), newGrid(a).farthest(b))
+	s.Error = d / diag
+	return s
+}
+
+// grid is a uniform grid of points for nearest neighbor queries.
+type grid struct {
+	min   Vec3
+	cell  float64
+	size  [3]int
+	cells map[[3]int][]Vec3
+}
+
+func newGrid(points []Vec3) *grid {
+	m := &Mesh{Layers: []*Layer{{Vertices: points}}}
+	min, max := m.Bounds()
+	ext := max.sub(min)
+	// About one point per cell for a surface sampled uniformly.
+	cell := math.Max(math.Max(ext[0], ext[1]), ext[2]) / math.Max(1, math.Sqrt(float64(len(points))))
+	if cell == 0 {
+		cell = 1
+	}
+	g := &grid{min: min, cell: cell, cells: map[[3]int][]Vec3{}}
+	for i := range g.size {
+		g.size[i] = int(ext[i]/cell) + 1
+	}
+	for _, p := range points {
+		k := g.key(p)
+		g.cells[k] = append(g.cells[k], p)
+	}
+	return g
+}
+
+func (g *grid) key(p Vec3) [3]int {
+	var k [3]int
+	for i := range k {
+		k[i] = int(math.Floor((p[i] - g.min[i]) / g.cell))
+	}
+	return k
+}
+
+// nearest returns the distance of p to the nearest point of the grid.
+// It searches shells of cells around p until no closer point can exist.
+func (g *grid) nearest(p Vec3) float64 {
+	k := g.key(p)
+	maxR := 0
+	for i := range k {
+		maxR = maxInt(maxR, maxInt(k[i], g.size[i]-1-k[i]))
+	}
+	maxR = maxInt(maxR, maxInt(-k[0], maxInt(-k[1], -k[2])))
+	best := math.Inf(1)
+	for r := 0; r <= maxR+1; r++ {
+		// Points of shell r are at least (r-1) cells away from p.
+		if float64(r-1)*g.cell > best {
+			break
+		}
+		for x := -r; x <= r; x++ {
+			for y := -r; y <= r; y++ {
+				for z := -r; z <= r; z++ {
+					if maxInt(absInt(x), maxInt(absInt(y), absInt(z))) != r {
+						continue
+					}
+					for _, q := range g.cells[[3]int{k[0] + x, k[1] + y, k[2] + z}] {
+						best = math.Min(best, p.sub(q).len())
+					}
+				}
+			}
+		}
+	}
+	return best
+}
+
+// farthest returns the largest distance of the given points to their
+// nearest point of the grid.
+func (g *grid) farthest(points []Vec3) float64 {
+	d := 0.0
+	for _, p := range points {
+		d = math.Max(d, g.nearest(p))
+	}
+	return d
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,90 @@
+package mesh_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/mesh"
+)
+
+const testModel = "../testdata/monkey.fbx"
+
+func TestLoad(t *testing.T) {
+	m, err := mesh.Load(testModel)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if len(m.Layers) != 1 || m.Layers[0].Name != "Suzanne" {
+		t.Fatalf("unexpected layers: %+v", m.Layers)
+	}
+	if m.Faces() != 7872 || len(m.Vertices()) != 7958 {
+		t.Fatalf("unexpected mesh: %d faces, %d vertices", m.Faces(), len(m.Vertices()))
+	}
+
+	s := mesh.Compare(m, m)
+	if s.Faces != 7872 || s.Ratio != 100 || s.Error != 0 {
+		t.Fatalf("a model should not differ from itself: %+v", s)
+	}
+}
+
+func TestRead_Invalid(t *testing.T) {
+	_, err := mesh.Read(strings.NewReader("; FBX 7.4.0 project file"))
+	if !errors.Is(err, mesh.ErrNotBinaryFBX) {
+		t.Fatalf("an ASCII FBX file should be rejected, got: %v", err)
+	}
+
+	b, err := os.ReadFile(testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mesh.Read(bytes.NewReader(b[:len(b)/2])); err == nil {
+		t.Fatalf("a truncated FBX file should be rejected")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	points := func(n int) []mesh.Vec3 {
+		p := make([]mesh.Vec3, n)
+		for i := range p {
+			p[i] = mesh.Vec3{r.Float64(), r.Float64() * 2, r.Float64() * 3}
+		}
+		return p
+	}
+	// hausdorff is the brute force symmetric Hausdorff distance.
+	hausdorff := func(a, b []mesh.Vec3) float64 {
+		d := 0.0
+		for _, s := range [][2][]mesh.Vec3{{a, b}, {b, a}} {
+			for _, p := range s[0] {
+				best := math.Inf(1)
+				for _, q := range s[1] {
+					best = math.Min(best, math.Sqrt((p[0]-q[0])*(p[0]-q[0])+(p[1]-q[1])*(p[1]-q[1])+(p[2]-q[2])*(p[2]-q[2])))
+				}
+				d = math.Max(d, best)
+			}
+		}
+		return d
+	}
+
+	root := &mesh.Mesh{Layers: []*mesh.Layer{{Vertices: points(500), Faces: 400}, {Vertices: points(100), Faces: 100}}}
+	reduced := &mesh.Mesh{Layers: []*mesh.Layer{{Vertices: points(200), Faces: 125}}}
+	s := mesh.Compare(root, reduced)
+	if s.Faces != 125 || s.Ratio != 25 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	min, max := root.Bounds()
+	diag := math.Sqrt((max[0]-min[0])*(max[0]-min[0]) + (max[1]-min[1])*(max[1]-min[1]) + (max[2]-min[2])*(max[2]-min[2]))
+	if want := hausdorff(root.Vertices(), reduced.Vertices()) / diag; math.Abs(s.Error-want) > 1e-12 {
+		t.Fatalf("unexpected error, want %v, got %v", want, s.Error)
+	}
+
+	empty := mesh.Compare(root, &mesh.Mesh{})
+	if empty.Faces != 0 || empty.Ratio != 0 || empty.Error != 0 {
+		t.Fatalf("unexpected stats of an empty mesh: %+v", empty)
+	}
+}
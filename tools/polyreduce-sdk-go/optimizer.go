@@ -0,0 +1,416 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Variant is a downloaded variant of a phase that waits for a rating.
+type Variant struct {
+	// ID is the model ID of the variant.
+	ID string
+	// Path is the local path of the downloaded model.
+	Path string
+}
+
+// Rater evaluates the variants of a phase. Variants that are not
+// covered by the evaluation stay unevaluated.
+type Rater interface {
+	Rate(ctx context.Context, s *Session, variants []Variant) (Evaluation, error)
+}
+
+// RaterFunc is a function that implements Rater, e.g. for scripts that
+// rate variants automatically.
+type RaterFunc func(ctx context.Context, s *Session, variants []Variant) (Evaluation, error)
+
+// Rate implements Rater.
+func (f RaterFunc) Rate(ctx context.Context, s *Session, variants []Variant) (Evaluation, error) {
+	return f(ctx, s, variants)
+}
+
+// PromptRater is a Rater that asks a person to evaluate the variants in
+// a terminal. The zero value reads the answers from os.Stdin and writes
+// the prompts to os.Stdout.
+type PromptRater struct {
+	// Mode is the evaluation mode that the person is asked for,
+	// ModeScore if empty.
+	Mode EvaluationMode
+
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// NewPromptRater returns a PromptRater that reads the answers from in
+// and writes the prompts to out, e.g. os.Stdin and os.Stdout.
+func NewPromptRater(in io.Reader, out io.Writer) *PromptRater {
+	return &PromptRater{in: bufio.NewScanner(in), out: out}
+}
+
+// Rate implements Rater.
+func (r *PromptRater) Rate(ctx context.Context, s *Session, variants []Variant) (Evaluation, error) {
+	if r.in == nil {
+		r.in = bufio.NewScanner(os.Stdin)
+	}
+	if r.out == nil {
+		r.out = os.Stdout
+	}
+
+	fmt.Fprintf(r.out, "phase %d of session %s:\n", len(s.Phases), s.ID)
+	for i, v := range variants {
+		fmt.Fprintf(r.out, "  [%d] %s (%s)\n", i+1, v.ID, v.Path)
+	}
+
+	switch r.Mode {
+	case "", ModeScore:
+		return r.scores(ctx, variants)
+	case ModeRanking:
+		return r.ranking(ctx, variants)
+	case ModePairwise:
+		return r.pairwise(ctx, variants)
+	case ModeBestOfN:
+		return r.best(ctx, variants)
+	}
+	return nil, fmt.Errorf("polyreduce: unsupported evaluation mode %q", r.Mode)
+}
+
+// scores asks for the rating of every variant. A rating is answered by
+// its label or number, and an empty answer leaves a variant unrated. The
+// variants are asked again until at least one of them is rated.
+func (r *PromptRater) scores(ctx context.Context, variants []Variant) (Evaluation, error) {
+	fmt.Fprintf(r.out, "rate every variant, or press enter to leave it unrated:\n")
+	for _, l := range Ratings() {
+		fmt.Fprintf(r.out, "  %d: %v\n", l, l)
+	}
+	scores := Scores{}
+	for len(scores) == 0 {
+		for i, v := range variants {
+			for {
+				answer, err := r.ask(ctx, fmt.Sprintf("[%d]: ", i+1))
+				if err != nil {
+					return nil, err
+				}
+				if answer == "" {
+					break
+				}
+				score, err := ParseRating(answer)
+				if err != nil {
+					fmt.Fprintf(r.out, "invalid rating %q, expect a label or a number from %d to %d\n", answer, Skip, Excellent)
+					continue
+				}
+				scores[v.ID] = score
+				break
+			}
+		}
+		if len(scores) == 0 {
+			fmt.Fprintf(r.out, "rate at least one variant, e.g. as %v\n", Skip)
+		}
+	}
+	return scores, nil
+}
+
+// ranking asks for the order of all variants from the best to the worst.
+func (r *PromptRater) ranking(ctx context.Context, variants []Variant) (Evaluation, error) {
+	return r.askEvaluation(ctx, ModeRanking, "rank all variants from the best to the worst, e.g. 2 1 4 3: ", variants)
+}
+
+// pairwise asks for the better variant of every pair of variants. An
+// empty answer skips a pair, and the pairs are asked again if all of
+// them are skipped.
+func (r *PromptRater) pairwise(ctx context.Context, variants []Variant) (Evaluation, error) {
+	pairwise := Pairwise{}
+	for len(pairwise) == 0 {
+		for i := range variants {
+			for j := i + 1; j < len(variants); j++ {
+				for {
+					answer, err := r.ask(ctx, fmt.Sprintf("which is better, %d or %d? (press enter to skip): ", i+1, j+1))
+					if err != nil {
+						return nil, err
+					}
+					if answer == "" {
+						break
+					}
+					if answer == strconv.Itoa(i+1) {
+						pairwise = append(pairwise, Preference{Better: variants[i].ID, Worse: variants[j].ID})
+						break
+					}
+					if answer == strconv.Itoa(j+1) {
+						pairwise = append(pairwise, Preference{Better: variants[j].ID, Worse: variants[i].ID})
+						break
+					}
+					fmt.Fprintf(r.out, "invalid answer %q, expect %d or %d\n", answer, i+1, j+1)
+				}
+			}
+		}
+		if len(pairwise) == 0 {
+			fmt.Fprintln(r.out, "compare at least one pair of variants")
+		}
+	}
+	return pairwise, nil
+}
+
+// best asks for the best variant.
+func (r *PromptRater) best(ctx context.Context, variants []Variant) (Evaluation, error) {
+	return r.askEvaluation(ctx, ModeBestOfN, fmt.Sprintf("which variant is the best? (1-%d): ", len(variants)), variants)
+}
+
+// askEvaluation asks for an evaluation that is answered in a single
+// line, until the answer is valid.
+func (r *PromptRater) askEvaluation(ctx context.Context, mode EvaluationMode, prompt string, variants []Variant) (Evaluation, error) {
+	for {
+		answer, err := r.ask(ctx, prompt)
+		if err != nil {
+			return nil, err
+		}
+		e, err := ParseEvaluation(mode, answer, variants)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			continue
+		}
+		return e, nil
+	}
+}
+
+// ask prints the prompt and reads an answer.
+func (r *PromptRater) ask(ctx context.Context, prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.in.Scan() {
+		if err := r.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.ErrUnexpectedEOF
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(r.in.Text()), nil
+}
+
+// ParseEvaluation parses an evaluation of the variants that is answered
+// in a single line, where a variant is referred by its one-based number:
+//
+//   - ModeScore: a rating for each variant separated by spaces, or typed
+//     without spaces if every rating is a digit, e.g. 5.32 for four
+//     variants, where . leaves a variant unrated.
+//   - ModeRanking: all variants from the best to the worst, e.g. 2 1 4 3.
+//   - ModePairwise: comparisons of variants, e.g. 1>2 3<4.
+//   - ModeBestOfN: the best variant, e.g. 2.
+//
+// An answer that does not evaluate any variant is an error.
+func ParseEvaluation(mode EvaluationMode, answer string, variants []Variant) (Evaluation, error) {
+	switch mode {
+	case ModeRanking:
+		keys := splitKeys(answer, len(variants))
+		ranking := Ranking{}
+		for _, k := range keys {
+			v, ok := pick(variants, k)
+			if !ok || contains(ranking, v.ID) {
+				break
+			}
+			ranking = append(ranking, v.ID)
+		}
+		if len(ranking) != len(variants) || len(keys) != len(variants) {
+			return nil, fmt.Errorf("invalid ranking %q, expect every number from 1 to %d once", answer, len(variants))
+		}
+		return ranking, nil
+	case ModePairwise:
+		pairwise := Pairwise{}
+		for _, f := range strings.Fields(answer) {
+			sep := strings.IndexAny(f, "<>")
+			if sep < 0 {
+				return nil, fmt.Errorf("invalid comparison %q, expect e.g. 1>2", f)
+			}
+			a, okA := pick(variants, f[:sep])
+			b, okB := pick(variants, f[sep+1:])
+			if !okA || !okB || a.ID == b.ID {
+				return nil, fmt.Errorf("invalid comparison %q, expect two numbers from 1 to %d", f, len(variants))
+			}
+			if f[sep] == '<' {
+				a, b = b, a
+			}
+			pairwise = append(pairwise, Preference{Better: a.ID, Worse: b.ID})
+		}
+		if len(pairwise) == 0 {
+			return nil, errors.New("compare at least one pair of variants")
+		}
+		return pairwise, nil
+	case ModeBestOfN:
+		v, ok := pick(variants, answer)
+		if !ok {
+			return nil, fmt.Errorf("invalid answer %q, expect a number from 1 to %d", answer, len(variants))
+		}
+		return BestOfN{Best: v.ID}, nil
+	case "", ModeScore:
+		keys := splitKeys(answer, len(variants))
+		if len(keys) != len(variants) {
+			return nil, fmt.Errorf("invalid ratings %q, expect a rating for each of %d variants", answer, len(variants))
+		}
+		scores := Scores{}
+		for i, k := range keys {
+			if k == "." {
+				continue
+			}
+			score, err := ParseRating(k)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rating %q of variant %d, expect a label or a number from %d to %d", k, i+1, Skip, Excellent)
+			}
+			scores[variants[i].ID] = score
+		}
+		if len(scores) == 0 {
+			return nil, errors.New("rate at least one variant")
+		}
+		return scores, nil
+	}
+	return nil, fmt.Errorf("polyreduce: unsupported evaluation mode %q", mode)
+}
+
+// splitKeys splits an answer into one key per variant. The keys are
+// separated by spaces, or typed without spaces if every key is a single
+// digit or a dot, e.g. 5.32 for four variants.
+func splitKeys(answer string, n int) []string {
+	fields := strings.Fields(answer)
+	if len(fields) != 1 || n < 2 || len(fields[0]) != n {
+		return fields
+	}
+	keys := make([]string, 0, n)
+	for _, c := range fields[0] {
+		if c != '.' && (c < '0' || c > '9') {
+			return fields
+		}
+		keys = append(keys, string(c))
+	}
+	return keys
+}
+
+// pick returns the variant with the given one-based number.
+func pick(variants []Variant, number string) (Variant, bool) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(variants) {
+		return Variant{}, false
+	}
+	return variants[n-1], true
+}
+
+// StopReason is the reason why an Optimizer stopped.
+type StopReason string
+
+// All reasons of a stopped Optimizer.
+const (
+	StopConverged     StopReason = "converged"
+	StopMaxIterations StopReason = "max iterations"
+	StopCallback      StopReason = "callback"
+)
+
+// Optimizer drives a propolyred session without manual steps. It runs
+// the next phase, downloads its variants, asks the Rater for a rating,
+// and submits the rating, until one of the stop conditions holds.
+type Optimizer struct {
+	// Session is the optimized session.
+	Session *Session
+	// Rater rates the variants of every phase.
+	Rater Rater
+	// Dir is the directory where variants are downloaded to. A temporary
+	// directory that is removed after Run is used if Dir is empty.
+	Dir string
+
+	// MaxIterations is the maximum number of rated phases. Zero means
+	// no limit.
+	MaxIterations int
+	// Tolerance stops the optimization once the assumed optimal ratio
+	// of two consecutive phases differs by at most Tolerance. The last
+	// phase is left unevaluated then. Zero disables the check.
+	Tolerance float64
+	// Stop is called after every rated phase and stops the optimization
+	// if it returns true.
+	Stop func(s *Session, p *Phase) bool
+}
+
+// Run runs the optimization and returns the reason why it stopped. A
+// session with unevaluated variants in its latest phase, e.g. a resumed
+// session, continues with rating them.
+func (o *Optimizer) Run(ctx context.Context) (StopReason, error) {
+	if o.Session == nil || o.Rater == nil {
+		return "", errors.New("polyreduce: an optimizer requires a session and a rater")
+	}
+	dir := o.Dir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "polyreduce-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	s := o.Session
+	for i := 0; o.MaxIterations <= 0 || i < o.MaxIterations; i++ {
+		p := s.Current()
+		ids := o.pending(p)
+		if len(ids) == 0 {
+			var err error
+			p, err = s.Next(ctx)
+			if err != nil {
+				return "", err
+			}
+			if o.converged() {
+				return StopConverged, nil
+			}
+			ids = p.IDs
+		}
+
+		variants := make([]Variant, len(ids))
+		for j, id := range ids {
+			variants[j] = Variant{ID: id, Path: filepath.Join(dir, id+".fbx")}
+			if _, err := s.DownloadPhase(ctx, id, variants[j].Path); err != nil {
+				return "", fmt.Errorf("failed to download variant %s: %w", id, err)
+			}
+		}
+		e, err := o.Rater.Rate(ctx, s, variants)
+		if err != nil {
+			return "", fmt.Errorf("failed to rate: %w", err)
+		}
+		if err := s.Evaluate(ctx, e); err != nil {
+			return "", err
+		}
+		if o.Stop != nil && o.Stop(s, p) {
+			return StopCallback, nil
+		}
+	}
+	return StopMaxIterations, nil
+}
+
+// pending returns the variants of the given phase if any of them is
+// unevaluated. A phase with any rating is complete. All variants of the
+// phase are returned, because an evaluation such as a Ranking has to
+// cover the whole phase.
+func (o *Optimizer) pending(p *Phase) []string {
+	if p == nil || len(p.Ratings) > 0 {
+		return nil
+	}
+	for _, id := range p.IDs {
+		if contains(o.Session.Unevaluated, id) {
+			return p.IDs
+		}
+	}
+	return nil
+}
+
+// converged reports whether the assumed optimal ratio converged.
+func (o *Optimizer) converged() bool {
+	h := o.Session.AssumedOptimal()
+	if o.Tolerance <= 0 || len(h) < 2 {
+		return false
+	}
+	return math.Abs(h[len(h)-1]-h[len(h)-2]) <= o.Tolerance
+}
@@ -0,0 +1,182 @@
+package polyreduce_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+// rateFirst is a rater that always prefers the first variant.
+var rateFirst = polyreduce.RaterFunc(func(ctx context.Context, s *polyreduce.Session, variants []polyreduce.Variant) (polyreduce.Evaluation, error) {
+	return polyreduce.Scores{variants[0].ID: polyreduce.Excellent, variants[1].ID: polyreduce.Terrible}, nil
+})
+
+func TestOptimizer(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		o      polyreduce.Optimizer
+		reason polyreduce.StopReason
+		phases int
+	}{
+		{"max iterations", polyreduce.Optimizer{MaxIterations: 2}, polyreduce.StopMaxIterations, 2},
+		// The optimal ratio moves to the lower bound: 50, 10, 1, 1.
+		{"converged", polyreduce.Optimizer{Tolerance: 0.5}, polyreduce.StopConverged, 4},
+		{"callback", polyreduce.Optimizer{Stop: func(s *polyreduce.Session, p *polyreduce.Phase) bool {
+			return len(s.Phases) == 3
+		}}, polyreduce.StopCallback, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+			if err != nil {
+				t.Fatalf("failed to start session: %v", err)
+			}
+			tt.o.Session = ss
+			tt.o.Rater = rateFirst
+			tt.o.Dir = t.TempDir()
+			reason, err := tt.o.Run(ctx)
+			if err != nil {
+				t.Fatalf("failed to optimize: %v", err)
+			}
+			if reason != tt.reason || len(ss.Phases) != tt.phases {
+				t.Fatalf("want %q after %d phases, got %q after %d phases: %v",
+					tt.reason, tt.phases, reason, len(ss.Phases), ss.AssumedOptimal())
+			}
+		})
+	}
+}
+
+func TestOptimizer_ResumeRanking(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	ss, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	p, err := ss.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	// A resumed phase of which only some variants are unevaluated.
+	ss.Unevaluated = ss.Unevaluated[1:]
+
+	var got []string
+	o := &polyreduce.Optimizer{
+		Session: ss,
+		Rater: polyreduce.RaterFunc(func(ctx context.Context, s *polyreduce.Session, variants []polyreduce.Variant) (polyreduce.Evaluation, error) {
+			var r polyreduce.Ranking
+			for _, v := range variants {
+				r = append(r, v.ID)
+			}
+			got = r
+			return r, nil
+		}),
+		MaxIterations: 1,
+		Dir:           t.TempDir(),
+	}
+	if _, err := o.Run(ctx); err != nil {
+		t.Fatalf("failed to optimize: %v", err)
+	}
+	if !reflect.DeepEqual(got, p.IDs) || len(p.Ratings) != len(p.IDs) {
+		t.Fatalf("the whole phase %v should be ranked, got %v and %v", p.IDs, got, p.Ratings)
+	}
+}
+
+func TestPromptRater(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	ss, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	out := new(bytes.Buffer)
+	o := &polyreduce.Optimizer{
+		Session:       ss,
+		Rater:         polyreduce.NewPromptRater(strings.NewReader("good\nbad\n7\n2\n\nskip\n"), out),
+		MaxIterations: 1,
+	}
+	if _, err := o.Run(ctx); err != nil {
+		t.Fatalf("failed to optimize: %v", err)
+	}
+	p := ss.Current()
+	want := map[string]polyreduce.Rating{p.IDs[0]: polyreduce.Good, p.IDs[1]: polyreduce.Poor, p.IDs[3]: polyreduce.Skip}
+	for id, r := range want {
+		if p.Ratings[id] != r {
+			t.Fatalf("want ratings %v, got %v", want, p.Ratings)
+		}
+	}
+	if len(p.Ratings) != 3 || p.Rating(p.IDs[2]) != polyreduce.Unrated || strings.Count(out.String(), "invalid rating") != 2 {
+		t.Fatalf("unexpected ratings %v and prompts:\n%s", p.Ratings, out)
+	}
+}
+
+func TestParseEvaluation(t *testing.T) {
+	variants := []polyreduce.Variant{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	tests := []struct {
+		mode   polyreduce.EvaluationMode
+		answer string
+		want   polyreduce.Evaluation
+	}{
+		{polyreduce.ModeScore, "5.2", polyreduce.Scores{"a": polyreduce.Excellent, "c": polyreduce.Poor}},
+		{polyreduce.ModeScore, "good . poor", polyreduce.Scores{"a": polyreduce.Good, "c": polyreduce.Poor}},
+		{polyreduce.ModeScore, "...", nil},
+		{polyreduce.ModeScore, "52", nil},
+		{polyreduce.ModeRanking, "231", polyreduce.Ranking{"b", "c", "a"}},
+		{polyreduce.ModeRanking, "2 2 1", nil},
+		{polyreduce.ModePairwise, "1>2 3<1", polyreduce.Pairwise{{Better: "a", Worse: "b"}, {Better: "a", Worse: "c"}}},
+		{polyreduce.ModePairwise, "", nil},
+		{polyreduce.ModePairwise, "1>1", nil},
+		{polyreduce.ModeBestOfN, "3", polyreduce.BestOfN{Best: "c"}},
+		{polyreduce.ModeBestOfN, "4", nil},
+	}
+	for _, tt := range tests {
+		got, err := polyreduce.ParseEvaluation(tt.mode, tt.answer, variants)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s %q should be rejected, got %v", tt.mode, tt.answer, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q: want %v, got %v: %v", tt.mode, tt.answer, tt.want, got, err)
+		}
+	}
+}
+
+func TestPromptRater_Unrated(t *testing.T) {
+	variants := []polyreduce.Variant{{ID: "a"}, {ID: "b"}}
+	tests := []struct {
+		mode   polyreduce.EvaluationMode
+		answer string
+		want   polyreduce.Evaluation
+		prompt string
+	}{
+		{polyreduce.ModeScore, "\n\n\ngood\n", polyreduce.Scores{"b": polyreduce.Good}, "rate at least one variant"},
+		{polyreduce.ModePairwise, "\n2\n", polyreduce.Pairwise{{Better: "b", Worse: "a"}}, "compare at least one pair"},
+	}
+	for _, tt := range tests {
+		out := new(bytes.Buffer)
+		r := polyreduce.NewPromptRater(strings.NewReader(tt.answer), out)
+		r.Mode = tt.mode
+		got, err := r.Rate(context.Background(), &polyreduce.Session{}, variants)
+		if err != nil || !reflect.DeepEqual(got, tt.want) || !strings.Contains(out.String(), tt.prompt) {
+			t.Errorf("%s: want %v, got %v: %v\n%s", tt.mode, tt.want, got, err, out)
+		}
+	}
+}
@@ -6,9 +6,8 @@ package polyreduce
 
 import (
 	"context"
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 // PingInput is a a reserved structure
@@ -20,30 +19,29 @@ type PingOutput struct {
 	Version   string `json:"version"`
 	BuildTime string `json:"build_time"`
 	Message   string `json:"message"`
+
+	// Date is the clock of the service when it responded, or zero if
+	// the service did not send a Date header.
+	Date time.Time `json:"-"`
+	// Latency is the duration of the ping.
+	Latency time.Duration `json:"-"`
 }
 
 // Ping for polyreduce service health checking
 func (c *Client) Ping(ctx context.Context) (*PingOutput, error) {
-	url := c.endpoint + "/api/v1/ping"
-
-	r, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
 	o := &PingOutput{}
-	err = json.Unmarshal(data, o)
+	start := time.Now()
+	resp, err := c.doJSON(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/ping",
+		idempotent: true,
+	}, o)
 	if err != nil {
 		return nil, err
 	}
+	o.Latency = time.Since(start)
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		o.Date = date
+	}
 	return o, nil
 }
@@ -3,16 +3,45 @@ package polyreduce_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
 )
 
 func TestPolyreduce_Ping(t *testing.T) {
-	c := polyreduce.NewClient()
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	c := s.Client()
 	r, err := c.Ping(context.Background())
 	if err != nil {
-		t.Fatalf("failed to ping polyreduce.com: %v", err)
+		t.Fatalf("failed to ping polyreduce service: %v", err)
+	}
+	if r.Version != polyreducetest.Version {
+		t.Fatalf("unexpected version, want %s, got %s", polyreducetest.Version, r.Version)
+	}
+	if r.Date.IsZero() || r.Latency <= 0 {
+		t.Fatalf("ping should report the date of the service and the latency, got %v and %v", r.Date, r.Latency)
 	}
 
-	t.Log(r.Version)
+	s.SetClockSkew(time.Hour)
+	r, err = c.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("failed to ping polyreduce service: %v", err)
+	}
+	if skew := time.Until(r.Date); skew < 59*time.Minute || skew > time.Hour {
+		t.Fatalf("the date of the service should be an hour ahead, got %v", r.Date)
+	}
+}
+
+func TestPolyreduce_PingWithoutCredentials(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	c := s.Client(polyreduce.WithCredentials("", ""))
+	_, err := c.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("ping should not require credentials: %v", err)
+	}
 }
@@ -5,22 +5,17 @@
 package polyreduce
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"mime/multipart"
 	"net/http"
-	"os"
-	"strings"
 )
 
 type PolyredUploadInput struct {
 	// ModelPath refers to an FBX file.
 	ModelPath string
+	// Progress optionally reports the progress of the upload.
+	Progress ProgressFunc
 }
 type PolyredUploadOutput struct {
 	// The stored model ID that can be reused anytime in subsequent requests.
@@ -29,52 +24,20 @@ type PolyredUploadOutput struct {
 }
 
 // Upload uploads an given FBX model to the polyreduce service using
-// plain polyred service.
+// plain polyred service. See PolyredUploadReader for uploading a model
+// that is not stored in a file.
 func (c *Client) PolyredUpload(ctx context.Context, i *PolyredUploadInput) (*PolyredUploadOutput, error) {
-	url := c.endpoint + "/api/v1/polyred/upload"
-
-	if !strings.HasSuffix(strings.ToLower(i.ModelPath), ".fbx") {
-		return nil, errors.New("only .FBX model is supported")
-	}
-
-	b, err := os.ReadFile(i.ModelPath)
-	if err != nil {
-		return nil, err
-	}
-
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", i.ModelPath)
-	if err != nil {
-		return nil, err
-	}
-	part.Write(b)
-	err = writer.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	r, err := http.NewRequest(http.MethodPost, url, body)
-	if err != nil {
-		return nil, err
-	}
-	r.Header.Add("Content-Type", writer.FormDataContentType())
-	r.SetBasicAuth("way", "secret-pass")
-
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
+	req, err := uploadFile("/polyred/upload", i.ModelPath, i.Progress)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	data, err := ioutil.ReadAll(resp.Body)
+	output := &PolyredUploadOutput{}
+	_, err = c.doJSON(ctx, req, output)
 	if err != nil {
 		return nil, err
 	}
-
-	output := &PolyredUploadOutput{}
-	err = json.Unmarshal(data, output)
-	return output, err
+	return output, nil
 }
 
 type PolyredConfigInput struct {
@@ -95,7 +58,6 @@ type PolyredConfigOutput struct {
 //
 // The configuration is allowed to call multiple times for a reconfiguration.
 func (c *Client) PolyredConfig(ctx context.Context, i *PolyredConfigInput) error {
-	url := c.endpoint + "/api/v1/polyred/config/" + i.ModelID
 	b, err := json.Marshal(struct {
 		Percent map[string]float64 `json:"percent"`
 	}{
@@ -105,33 +67,63 @@ func (c *Client) PolyredConfig(ctx context.Context, i *PolyredConfigInput) error
 		return err
 	}
 
-	r, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	r.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	r.SetBasicAuth("way", "secret-pass")
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	o := &PolyredConfigOutput{}
+	_, err = c.doJSON(ctx, &request{
+		method:      http.MethodPost,
+		path:        "/polyred/config/" + i.ModelID,
+		body:        bytesBody(b),
+		contentType: "application/json; charset=UTF-8",
+	}, o)
+	return err
+}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
+type PolyredLayersInput struct {
+	ModelID string
+}
 
-	o := &PolyredConfigOutput{}
-	err = json.Unmarshal(data, o)
+type PolyredLayersOutput struct {
+	// Layers are the mesh names of the model, which are the keys of
+	// PolyredConfigInput.ReductionRatio.
+	Layers  []string `json:"layers"`
+	Message string   `json:"msg,omitempty"`
+}
+
+// PolyredLayers returns the mesh names of an uploaded model. See
+// ResolveReductionRatio for configuring layers by patterns.
+func (c *Client) PolyredLayers(ctx context.Context, i *PolyredLayersInput) (*PolyredLayersOutput, error) {
+	o := &PolyredLayersOutput{}
+	_, err := c.doJSON(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/polyred/layers/" + i.ModelID,
+		idempotent: true,
+	}, o)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return o, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send configuration: %s", o.Message)
-	}
-	return nil
+type PolyredExistsInput struct {
+	ModelID string
+}
+
+// PolyredExists reports whether an uploaded model still exists on the
+// service, e.g. before an upload is reused. It asks the download API,
+// which every version of the service offers, but does not read the
+// model. A model that is not simplified yet exists as well.
+func (c *Client) PolyredExists(ctx context.Context, i *PolyredExistsInput) (bool, error) {
+	err := c.do(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/polyred/download/" + i.ModelID,
+		idempotent: true,
+	}, func(resp *http.Response) error { return nil })
+	switch {
+	case err == nil || errors.Is(err, ErrConflict):
+		return true, nil
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	}
+	return false, err
 }
 
 type PolyredRunInput struct {
@@ -146,64 +138,51 @@ type PolyredRunOutput struct {
 
 // PolyredRun executes the simplification. The function blocks until the
 // simplification is complete or server side error.
+//
+// PolyredRun is a shortcut of SubmitRun followed by Job.Wait. Use
+// SubmitRun to run many simplifications concurrently. If the service
+// does not offer jobs, the simplification runs on the legacy blocking
+// API instead.
 func (c *Client) PolyredRun(ctx context.Context, i *PolyredRunInput) error {
-	url := c.endpoint + "/api/v1/polyred/run/" + i.ModelID
-	r, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		return err
-	}
-	r.SetBasicAuth("way", "secret-pass")
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	job, err := c.SubmitRun(ctx, i)
+	if errors.Is(err, ErrNotFound) {
+		// Either the model or the jobs API does not exist, which the
+		// legacy API tells apart.
+		o := &PolyredRunOutput{}
+		_, err = c.doJSON(ctx, &request{
+			method: http.MethodPost,
+			path:   "/polyred/run/" + i.ModelID,
+		}, o)
 		return err
 	}
-
-	o := &PolyredRunOutput{}
-	err = json.Unmarshal(data, o)
 	if err != nil {
 		return err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to run: %s", o.Message)
-	}
-	return nil
+	_, err = job.Wait(ctx)
+	return err
 }
 
 type DownloadInput struct {
 	ModelID string
 	Path    string
+	// VerifyChecksum requires the downloaded model to match the checksum
+	// provided by the service.
+	VerifyChecksum bool
+	// Progress optionally reports the progress of the download.
+	Progress ProgressFunc
 }
 
 // PolyredDownload downloads the result of a polygon reduction. The
-// downloaded model is saved to the given path.
+// downloaded model is saved to the given path. The path is only written
+// if the complete model was received, an existing file at the path is
+// kept otherwise.
 //
 // The result may be different if the simplification was reconfigured and
 // also being executed.
-func (c *Client) PolyredDownload(ctx context.Context, i *DownloadInput) error {
-	url := c.endpoint + "/api/v1/polyred/download/" + i.ModelID
-	r, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return err
-	}
-	r.SetBasicAuth("way", "secret-pass")
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	f, err := os.Create(i.Path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
-	return err
+func (c *Client) PolyredDownload(ctx context.Context, i *DownloadInput) (*DownloadOutput, error) {
+	return c.download(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/polyred/download/" + i.ModelID,
+		idempotent: true,
+	}, i.Path, i.VerifyChecksum, i.Progress)
 }
@@ -0,0 +1,175 @@
+package polyreduce_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+const testModel = "testdata/monkey.fbx"
+
+func TestPolyred(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	var sent int64
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{
+		ModelPath: testModel,
+		Progress:  func(n, total int64) { sent = n },
+	})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	fi, _ := os.Stat(testModel)
+	if sent != fi.Size() {
+		t.Fatalf("unexpected upload progress, want %d, got %d", fi.Size(), sent)
+	}
+
+	err = c.PolyredConfig(ctx, &polyreduce.PolyredConfigInput{
+		ModelID:        o.ModelId,
+		ReductionRatio: map[string]float64{"default": 30},
+	})
+	if err != nil {
+		t.Fatalf("failed to config: %v", err)
+	}
+	m, _ := s.Model(o.ModelId)
+	if m.Config["default"] != 30 {
+		t.Fatalf("unexpected configuration: %v", m.Config)
+	}
+
+	err = c.PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: o.ModelId})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.fbx")
+	d, err := c.PolyredDownload(ctx, &polyreduce.DownloadInput{
+		ModelID:        o.ModelId,
+		Path:           path,
+		VerifyChecksum: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if d.Size != fi.Size() || d.Format != polyreduce.FormatBinaryFBX {
+		t.Fatalf("unexpected download output: %+v", d)
+	}
+}
+
+func TestPolyredExists(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	o, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	for _, tt := range []struct {
+		id   string
+		want bool
+	}{{o.ModelId, true}, {"missing", false}} {
+		ok, err := c.PolyredExists(ctx, &polyreduce.PolyredExistsInput{ModelID: tt.id})
+		if err != nil || ok != tt.want {
+			t.Fatalf("model %s: want %v, got %v: %v", tt.id, tt.want, ok, err)
+		}
+	}
+
+	_, err = s.Client(polyreduce.WithCredentials("way", "wrong")).PolyredExists(ctx, &polyreduce.PolyredExistsInput{ModelID: o.ModelId})
+	if !errors.Is(err, polyreduce.ErrUnauthorized) {
+		t.Fatalf("want ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestPolyred_UploadReader(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+
+	f, err := os.Open(testModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	o, err := c.PolyredUploadReader(context.Background(), "monkey.fbx", f, -1)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	m, ok := s.Model(o.ModelId)
+	if !ok || m.Name != "monkey.fbx" {
+		t.Fatalf("unexpected uploaded model: %+v", m)
+	}
+}
+
+func TestPolyred_Errors(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	ctx := context.Background()
+
+	_, err := s.Client().PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: "testdata/monkey.obj"})
+	if err == nil {
+		t.Fatalf("uploading non FBX model should fail")
+	}
+
+	err = s.Client().PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: "missing"})
+	if !errors.Is(err, polyreduce.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+
+	_, err = s.Client(polyreduce.WithCredentials("way", "wrong")).PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if !errors.Is(err, polyreduce.ErrUnauthorized) {
+		t.Fatalf("want ErrUnauthorized, got %v", err)
+	}
+	var apiErr *polyreduce.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.RequestID == "" {
+		t.Fatalf("unexpected API error: %#v", apiErr)
+	}
+
+	o, err := s.Client().PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	err = s.Client().PolyredRun(ctx, &polyreduce.PolyredRunInput{ModelID: o.ModelId})
+	if !errors.Is(err, polyreduce.ErrConflict) {
+		t.Fatalf("running an unconfigured model should conflict, got %v", err)
+	}
+}
+
+func TestPolyred_DownloadInvalidModel(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	s.Handle(polyreducetest.RoutePolyredDownload, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"msg":"not a model"}`))
+	}))
+
+	path := filepath.Join(t.TempDir(), "out.fbx")
+	if err := os.WriteFile(path, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Client().PolyredDownload(context.Background(), &polyreduce.DownloadInput{
+		ModelID: "any",
+		Path:    path,
+	})
+	if !errors.Is(err, polyreduce.ErrInvalidModel) {
+		t.Fatalf("want ErrInvalidModel, got %v", err)
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "previous" {
+		t.Fatalf("a failed download must not overwrite the existing file")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("a failed download must not leave temporary files, got %d files", len(entries))
+	}
+}
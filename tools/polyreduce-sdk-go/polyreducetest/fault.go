@@ -0,0 +1,176 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreducetest
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault describes a fault that is injected into the response of a route.
+// Faults are combined, e.g. a Fault with Latency and Status delays the
+// response and then responds with the status.
+type Fault struct {
+	// Times is the number of requests that are affected by the fault.
+	// Zero means all subsequent requests.
+	Times int
+
+	// Latency delays the response.
+	Latency time.Duration
+	// Status responds with the status code instead of calling the route.
+	Status int
+	// ErrorRate is the probability, between 0 and 1, of responding with
+	// a random 5xx status code instead of calling the route.
+	ErrorRate float64
+	// RetryAfter sets the Retry-After header. If Status is zero, the
+	// route responds with 429 Too Many Requests.
+	RetryAfter time.Duration
+
+	// Body replaces the response body, e.g. an HTML error page of a proxy.
+	Body string
+	// ContentType replaces the Content-Type header.
+	ContentType string
+	// MalformedJSON truncates the body of a JSON response.
+	MalformedJSON bool
+	// Reset closes the connection after ResetAfter bytes of the body
+	// are sent, while announcing the full length of the body.
+	Reset      bool
+	ResetAfter int
+}
+
+// Inject appends the faults to the fault script of the given route, e.g.
+// RoutePolyredDownload. Every request to the route takes the first fault
+// of the script, and the fault is removed from the script after it was
+// applied Fault.Times times. A route behaves normally if its script is
+// empty.
+//
+// For instance, the following script lets the first two requests fail
+// and then lets all requests be slow:
+//
+//	s.Inject(polyreducetest.RoutePing,
+//		polyreducetest.Fault{Status: http.StatusBadGateway, Times: 2},
+//		polyreducetest.Fault{Latency: time.Second},
+//	)
+func (s *Server) Inject(route string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[route] = append(s.faults[route], faults...)
+}
+
+// ClearFaults removes the fault scripts of all routes.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = map[string][]Fault{}
+}
+
+// SetSeed sets the seed of the randomness of injected faults, which is 1
+// by default.
+func (s *Server) SetSeed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rand = rand.New(rand.NewSource(seed))
+}
+
+// nextFault takes the next fault of the given route, and reports
+// whether a random error should be injected. The caller must hold s.mu.
+func (s *Server) nextFault(route string) (f Fault, randErr int, ok bool) {
+	script := s.faults[route]
+	if len(script) == 0 {
+		return Fault{}, 0, false
+	}
+	f = script[0]
+	if script[0].Times > 0 {
+		script[0].Times--
+		if script[0].Times == 0 {
+			script = script[1:]
+		}
+	}
+	s.faults[route] = script
+
+	if f.ErrorRate > 0 && s.rand.Float64() < f.ErrorRate {
+		codes := []int{
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		}
+		randErr = codes[s.rand.Intn(len(codes))]
+	}
+	return f, randErr, true
+}
+
+// serveFault serves a request of a route with the given fault.
+func serveFault(w http.ResponseWriter, r *http.Request, h http.Handler, f Fault, randErr int) {
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+
+	status := f.Status
+	if status == 0 && f.RetryAfter > 0 {
+		status = http.StatusTooManyRequests
+	}
+	if randErr != 0 {
+		status = randErr
+	}
+
+	rec := httptest.NewRecorder()
+	if status != 0 {
+		writeError(rec, status, "injected fault: %s", http.StatusText(status))
+	} else {
+		h.ServeHTTP(rec, r)
+	}
+
+	body := rec.Body.Bytes()
+	if f.Body != "" {
+		body = []byte(f.Body)
+	}
+	if f.MalformedJSON && strings.Contains(rec.Header().Get("Content-Type"), "json") {
+		body = bytes.TrimSpace(body)
+		body = body[:len(body)/2]
+	}
+
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	if f.ContentType != "" {
+		w.Header().Set("Content-Type", f.ContentType)
+	}
+	if f.RetryAfter > 0 {
+		secs := int((f.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(rec.Code)
+
+	if !f.Reset {
+		w.Write(body)
+		return
+	}
+	n := f.ResetAfter
+	if n > len(body) {
+		n = len(body)
+	}
+	w.Write(body[:n])
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreducetest
+
+import (
+	"net/http"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+)
+
+// job is a reduction job. A job is advanced lazily whenever its status
+// is requested, and takes effect once the RunDuration of the server has
+// elapsed.
+type job struct {
+	id      string
+	state   polyreduce.JobState
+	msg     string
+	created time.Time
+	run     func() (code int, msg string, res *runResult)
+	result  *runResult
+}
+
+// runResult is the result of a propolyred run.
+type runResult struct {
+	phases  []string
+	optimal float64
+}
+
+// newJob creates a job that executes run. The caller must hold s.mu.
+func (s *Server) newJob(run func() (int, string, *runResult)) *job {
+	j := &job{
+		id:      newID(),
+		state:   polyreduce.JobQueued,
+		created: time.Now(),
+		run:     run,
+	}
+	s.jobs[j.id] = j
+	j.advance(s.RunDuration)
+	return j
+}
+
+// advance updates the state of the job. The caller must hold s.mu.
+func (j *job) advance(d time.Duration) {
+	if j.state.Done() {
+		return
+	}
+	if time.Since(j.created) < d {
+		j.state = polyreduce.JobRunning
+		return
+	}
+
+	code, msg, res := j.run()
+	j.msg = msg
+	j.result = res
+	if code == http.StatusOK {
+		j.state = polyreduce.JobSucceeded
+	} else {
+		j.state = polyreduce.JobFailed
+	}
+}
+
+func (j *job) status() *polyreduce.JobStatus {
+	st := &polyreduce.JobStatus{
+		JobId:   j.id,
+		State:   j.state,
+		Message: j.msg,
+	}
+	if j.result != nil {
+		st.Phases = j.result.phases
+		st.AssumedOptimal = j.result.optimal
+	}
+	return st
+}
+
+func (s *Server) jobStatus(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[p["job"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "job %s does not exist", p["job"])
+		return
+	}
+	j.advance(s.RunDuration)
+	writeJSON(w, http.StatusOK, j.status())
+}
+
+func (s *Server) jobCancel(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[p["job"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "job %s does not exist", p["job"])
+		return
+	}
+	j.advance(s.RunDuration)
+	if !j.state.Done() {
+		j.state = polyreduce.JobCanceled
+		j.msg = "canceled by client"
+	}
+	writeJSON(w, http.StatusOK, j.status())
+}
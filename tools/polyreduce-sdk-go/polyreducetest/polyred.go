@@ -0,0 +1,259 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreducetest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+)
+
+// model is an uploaded model of the plain polyred service.
+type model struct {
+	id     string
+	name   string
+	data   []byte
+	layers []string
+	config map[string]float64
+	// reduced is the result of the last run, nil if never run.
+	reduced []byte
+}
+
+// Model is a snapshot of an uploaded model.
+type Model struct {
+	ID     string
+	Name   string
+	Size   int
+	Layers []string
+	// Config is the last configured reduction ratio per layer.
+	Config map[string]float64
+	// Reduced reports whether the model was simplified.
+	Reduced bool
+}
+
+// Model returns a snapshot of the model with the given ID.
+func (s *Server) Model(id string) (*Model, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.models[id]
+	if !ok {
+		return nil, false
+	}
+	return &Model{
+		ID:      m.id,
+		Name:    m.name,
+		Size:    len(m.data),
+		Layers:  append([]string(nil), m.layers...),
+		Config:  copyConfig(m.config),
+		Reduced: m.reduced != nil,
+	}, true
+}
+
+// maxUpload limits the size of an uploaded model.
+const maxUpload = 1 << 30
+
+// readModel reads the uploaded FBX model of a request.
+func readModel(w http.ResponseWriter, r *http.Request) (name string, data []byte, ok bool) {
+	f, h, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "missing model file: %v", err)
+		return "", nil, false
+	}
+	defer f.Close()
+
+	if !strings.HasSuffix(strings.ToLower(h.Filename), ".fbx") {
+		writeError(w, http.StatusBadRequest, "only .FBX model is supported")
+		return "", nil, false
+	}
+	data, err = ioutil.ReadAll(http.MaxBytesReader(w, f, maxUpload))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "cannot read model: %v", err)
+		return "", nil, false
+	}
+	if !isFBX(data) {
+		writeError(w, http.StatusBadRequest, "the uploaded file is not an FBX model")
+		return "", nil, false
+	}
+	return h.Filename, data, true
+}
+
+// isFBX reports whether the data looks like an FBX model.
+func isFBX(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("Kaydara FBX Binary  \x00")) ||
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte("; FBX"))
+}
+
+func (s *Server) polyredUpload(w http.ResponseWriter, r *http.Request, _ params) {
+	name, data, ok := readModel(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	m := &model{
+		id:     newID(),
+		name:   name,
+		data:   data,
+		layers: append([]string(nil), s.Layers...),
+	}
+	s.models[m.id] = m
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": m.id, "msg": "upload success"})
+}
+
+func (s *Server) polyredConfig(w http.ResponseWriter, r *http.Request, p params) {
+	var in struct {
+		Percent map[string]float64 `json:"percent"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid configuration: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.models[p["model"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "model %s does not exist", p["model"])
+		return
+	}
+	if len(in.Percent) == 0 {
+		writeError(w, http.StatusBadRequest, "empty configuration")
+		return
+	}
+	for name, ratio := range in.Percent {
+		if !contains(m.layers, name) {
+			writeError(w, http.StatusBadRequest, "model %s has no mesh named %q", m.id, name)
+			return
+		}
+		if ratio < 0 || ratio > 100 {
+			writeError(w, http.StatusBadRequest, "invalid reduction ratio of %q: %v", name, ratio)
+			return
+		}
+	}
+	m.config = copyConfig(in.Percent)
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": m.id, "msg": "configuration success"})
+}
+
+func (s *Server) polyredLayers(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.models[p["model"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "model %s does not exist", p["model"])
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"layers": m.layers})
+}
+
+// polyredRun runs the simplification synchronously. It is the legacy
+// API of RoutePolyredSubmitRun.
+func (s *Server) polyredRun(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, msg := s.runModel(p["model"])
+	if code != http.StatusOK {
+		writeError(w, code, msg)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": p["model"], "msg": msg})
+}
+
+// runModel simplifies the given model. The caller must hold s.mu.
+func (s *Server) runModel(id string) (code int, msg string) {
+	m, ok := s.models[id]
+	if !ok {
+		return http.StatusNotFound, "model " + id + " does not exist"
+	}
+	if m.config == nil {
+		return http.StatusConflict, "model " + id + " is not configured"
+	}
+	m.reduced = m.data
+	return http.StatusOK, "simplification success"
+}
+
+func (s *Server) polyredSubmitRun(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.models[p["model"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "model %s does not exist", p["model"])
+		return
+	}
+	if m.config == nil {
+		writeError(w, http.StatusConflict, "model %s is not configured", m.id)
+		return
+	}
+	j := s.newJob(func() (code int, msg string, res *runResult) {
+		code, msg = s.runModel(m.id)
+		return code, msg, nil
+	})
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+func (s *Server) polyredDownload(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	m, ok := s.models[p["model"]]
+	var data []byte
+	if ok {
+		data = m.reduced
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "model %s does not exist", p["model"])
+		return
+	}
+	if data == nil {
+		writeError(w, http.StatusConflict, "model %s is not simplified", p["model"])
+		return
+	}
+	writeModel(w, data)
+}
+
+// writeModel writes the given FBX model as a download response.
+func writeModel(w http.ResponseWriter, data []byte) {
+	sum := sha256.Sum256(data)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set(polyreduce.ChecksumHeader, hex.EncodeToString(sum[:]))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func copyConfig(m map[string]float64) map[string]float64 {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]float64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,490 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreducetest
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+)
+
+// InitialOptimal is the assumed optimal reduction ratio of a new session.
+const InitialOptimal = 50.0
+
+// variantsPerPhase is the number of variants produced by a run.
+const variantsPerPhase = 4
+
+// session is a propolyred session. The ID of a session is the ID of its
+// root model.
+type session struct {
+	id      string
+	name    string
+	data    []byte
+	layers  []string
+	optimal float64
+	phases  []*phase
+	// variants maps from model ID to all variants of the session.
+	variants map[string]*variant
+}
+
+// phase is the result of a single run of a session.
+type phase struct {
+	ids     []string
+	ratings map[string]polyreduce.Rating
+	// mode is the evaluation mode of the ratings.
+	mode polyreduce.EvaluationMode
+	// optimal is the assumed optimal ratio when the phase was produced.
+	optimal float64
+}
+
+// variant is a simplified model of a session.
+type variant struct {
+	id     string
+	ratio  float64
+	config map[string]float64
+}
+
+// Session is a snapshot of a propolyred session.
+type Session struct {
+	ID      string
+	Layers  []string
+	Optimal float64
+	// Phases are the variant IDs produced by every run.
+	Phases [][]string
+	// Ratings are all submitted ratings of variants.
+	Ratings map[string]polyreduce.Rating
+	// Modes are the evaluation modes of every phase, empty if a phase
+	// is not evaluated.
+	Modes []polyreduce.EvaluationMode
+	// Configs are the reduction ratio per layer of every variant.
+	Configs map[string]map[string]float64
+}
+
+// Session returns a snapshot of the session with the given ID.
+func (s *Server) Session(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	snap := &Session{
+		ID:      ss.id,
+		Layers:  append([]string(nil), ss.layers...),
+		Optimal: ss.optimal,
+		Ratings: map[string]polyreduce.Rating{},
+		Configs: map[string]map[string]float64{},
+	}
+	for _, ph := range ss.phases {
+		snap.Phases = append(snap.Phases, append([]string(nil), ph.ids...))
+		snap.Modes = append(snap.Modes, ph.mode)
+		for id, r := range ph.ratings {
+			snap.Ratings[id] = r
+		}
+	}
+	for id, v := range ss.variants {
+		snap.Configs[id] = copyConfig(v.config)
+	}
+	return snap, true
+}
+
+// unevaluated returns the IDs of all variants that are not rated.
+func (ss *session) unevaluated() []string {
+	ids := []string{}
+	for _, ph := range ss.phases {
+		for _, id := range ph.ids {
+			if _, ok := ph.ratings[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// next produces the variants of the next phase around the currently
+// assumed optimal reduction ratio. The spread of the variants narrows
+// with every phase.
+func (ss *session) next() *phase {
+	d := 40 / float64(len(ss.phases)+1)
+	ph := &phase{ratings: map[string]polyreduce.Rating{}, optimal: ss.optimal}
+	for i := 0; i < variantsPerPhase; i++ {
+		offset := -d + 2*d*float64(i)/float64(variantsPerPhase-1)
+		ratio := math.Round(math.Max(1, math.Min(99, ss.optimal+offset))*100) / 100
+		v := &variant{id: newID(), ratio: ratio, config: map[string]float64{}}
+		for _, l := range ss.layers {
+			v.config[l] = ratio
+		}
+		ss.variants[v.id] = v
+		ph.ids = append(ph.ids, v.id)
+	}
+	ss.phases = append(ss.phases, ph)
+	return ph
+}
+
+// update recomputes the assumed optimal reduction ratio, which is the
+// ratio of the best rated variant of the latest rated phase. Skipped
+// variants are ignored.
+func (ss *session) update() {
+	ss.optimal = InitialOptimal
+	for _, ph := range ss.phases {
+		best := polyreduce.Skip
+		for _, id := range ph.ids {
+			r, ok := ph.ratings[id]
+			if ok && r > best {
+				best = r
+				ss.optimal = ss.variants[id].ratio
+			}
+		}
+	}
+}
+
+func (s *Server) propolyredUpload(w http.ResponseWriter, r *http.Request, _ params) {
+	name, data, ok := readModel(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	ss := &session{
+		id:       newID(),
+		name:     name,
+		data:     data,
+		layers:   append([]string(nil), s.Layers...),
+		optimal:  InitialOptimal,
+		variants: map[string]*variant{},
+	}
+	s.sessions[ss.id] = ss
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": ss.id, "msg": "upload success"})
+}
+
+// runSession runs the next phase of the given session. The caller must
+// hold s.mu.
+func (s *Server) runSession(id string) (code int, msg string, res *runResult) {
+	code, msg = s.checkRun(id)
+	if code != http.StatusOK {
+		return code, msg, nil
+	}
+	ss := s.sessions[id]
+	ph := ss.next()
+	return http.StatusOK, "run success", &runResult{
+		phases:  append([]string(nil), ph.ids...),
+		optimal: ss.optimal,
+	}
+}
+
+// checkRun checks whether the next phase of the given session can run.
+// The caller must hold s.mu.
+func (s *Server) checkRun(id string) (code int, msg string) {
+	ss, ok := s.sessions[id]
+	if !ok {
+		return http.StatusNotFound, "session " + id + " does not exist"
+	}
+	if n := len(ss.phases); n > 0 && len(ss.phases[n-1].ratings) == 0 {
+		return http.StatusConflict, "the latest phase of session " + id + " is not evaluated"
+	}
+	return http.StatusOK, ""
+}
+
+// propolyredRun runs the next phase synchronously. It is the legacy API
+// of RouteProPolyredSubmitRun.
+func (s *Server) propolyredRun(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, msg, res := s.runSession(p["session"])
+	if code != http.StatusOK {
+		writeError(w, code, msg)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ids":     res.phases,
+		"optimal": res.optimal,
+		"msg":     msg,
+	})
+}
+
+func (s *Server) propolyredSubmitRun(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := p["session"]
+	if code, msg := s.checkRun(id); code != http.StatusOK {
+		writeError(w, code, msg)
+		return
+	}
+	j := s.newJob(func() (int, string, *runResult) {
+		return s.runSession(id)
+	})
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+func (s *Server) propolyredDownload(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	ss, ok := s.sessions[p["session"]]
+	var data []byte
+	if ok {
+		if _, isVariant := ss.variants[p["model"]]; isVariant || p["model"] == ss.id {
+			data = ss.data
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return
+	}
+	if data == nil {
+		writeError(w, http.StatusNotFound, "model %s does not exist in session %s", p["model"], p["session"])
+		return
+	}
+	writeModel(w, data)
+}
+
+func (s *Server) propolyredConfig(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[p["session"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return
+	}
+	v, ok := ss.variants[p["model"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "model %s is not a variant of session %s", p["model"], ss.id)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"percent": v.config})
+}
+
+func (s *Server) propolyredInspect(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[p["session"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ids": ss.unevaluated()})
+}
+
+// readRating reads the submitted ratings and their evaluation mode.
+func readRating(w http.ResponseWriter, r *http.Request) (rating map[string]polyreduce.Rating, mode polyreduce.EvaluationMode, ok bool) {
+	mode = polyreduce.ModeScore
+	if h := r.Header.Get(polyreduce.EvaluationModeHeader); h != "" {
+		m, err := polyreduce.ParseEvaluationMode(h)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return nil, "", false
+		}
+		mode = m
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&rating)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid rating: %v", err)
+		return nil, "", false
+	}
+	if len(rating) == 0 {
+		writeError(w, http.StatusBadRequest, "empty rating")
+		return nil, "", false
+	}
+	for id, score := range rating {
+		if !score.Valid() {
+			writeError(w, http.StatusBadRequest, "invalid rating of model %s: %v", id, score)
+			return nil, "", false
+		}
+	}
+	return rating, mode, true
+}
+
+func (s *Server) propolyredEvaluate(w http.ResponseWriter, r *http.Request, p params) {
+	rating, mode, ok := readRating(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[p["session"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return
+	}
+	if len(ss.phases) == 0 {
+		writeError(w, http.StatusBadRequest, "session %s has no phase", ss.id)
+		return
+	}
+	current := ss.phases[len(ss.phases)-1]
+	for id := range rating {
+		if !contains(current.ids, id) {
+			writeError(w, http.StatusBadRequest, "model %s is not a variant of the current phase", id)
+			return
+		}
+		if _, ok := current.ratings[id]; ok {
+			writeError(w, http.StatusBadRequest, "model %s is already evaluated", id)
+			return
+		}
+	}
+	for id, score := range rating {
+		current.ratings[id] = score
+	}
+	current.mode = mode
+	ss.update()
+
+	writeJSON(w, http.StatusOK, map[string]string{"msg": "evaluation success"})
+}
+
+// phaseEvaluation is a phase in the evaluation history of a session.
+type phaseEvaluation struct {
+	Phase   int                          `json:"phase"`
+	IDs     []string                     `json:"ids"`
+	Ratings map[string]polyreduce.Rating `json:"ratings"`
+	Mode    polyreduce.EvaluationMode    `json:"mode,omitempty"`
+	Optimal float64                      `json:"optimal"`
+}
+
+func (s *Server) propolyredHistory(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[p["session"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return
+	}
+	phases := []phaseEvaluation{}
+	for i, ph := range ss.phases {
+		phases = append(phases, phaseEvaluation{
+			Phase:   i + 1,
+			IDs:     ph.ids,
+			Ratings: ph.ratings,
+			Mode:    ph.mode,
+			Optimal: ph.optimal,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"phases":  phases,
+		"optimal": ss.optimal,
+	})
+}
+
+// pastPhase returns the session and the phase of a request to the
+// evaluation history. The caller must hold s.mu.
+func (s *Server) pastPhase(w http.ResponseWriter, p params) (*session, *phase, bool) {
+	ss, ok := s.sessions[p["session"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return nil, nil, false
+	}
+	n, err := strconv.Atoi(p["phase"])
+	if err != nil || n < 1 || n > len(ss.phases) {
+		writeError(w, http.StatusNotFound, "session %s has no phase %s", ss.id, p["phase"])
+		return nil, nil, false
+	}
+	return ss, ss.phases[n-1], true
+}
+
+func (s *Server) propolyredAmend(w http.ResponseWriter, r *http.Request, p params) {
+	rating, mode, ok := readRating(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ph, ok := s.pastPhase(w, p)
+	if !ok {
+		return
+	}
+	for id := range rating {
+		if !contains(ph.ids, id) {
+			writeError(w, http.StatusBadRequest, "model %s is not a variant of phase %s", id, p["phase"])
+			return
+		}
+	}
+	ph.ratings = rating
+	ph.mode = mode
+	ss.update()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"optimal": ss.optimal, "msg": "amend success"})
+}
+
+func (s *Server) propolyredRetract(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ph, ok := s.pastPhase(w, p)
+	if !ok {
+		return
+	}
+	ph.ratings = map[string]polyreduce.Rating{}
+	ph.mode = ""
+	ss.update()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"optimal": ss.optimal, "msg": "retract success"})
+}
+
+func (s *Server) propolyredReset(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[p["session"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return
+	}
+	ss.phases = nil
+	ss.variants = map[string]*variant{}
+	ss.optimal = InitialOptimal
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": ss.id, "msg": "reset success"})
+}
+
+func (s *Server) propolyredCopy(w http.ResponseWriter, r *http.Request, p params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.sessions[p["session"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s does not exist", p["session"])
+		return
+	}
+	cp := &session{
+		id:       newID(),
+		name:     ss.name,
+		data:     ss.data,
+		layers:   ss.layers,
+		optimal:  ss.optimal,
+		variants: map[string]*variant{},
+	}
+	for _, ph := range ss.phases {
+		ratings := map[string]polyreduce.Rating{}
+		for id, r := range ph.ratings {
+			ratings[id] = r
+		}
+		cp.phases = append(cp.phases, &phase{
+			ids:     append([]string(nil), ph.ids...),
+			ratings: ratings,
+			mode:    ph.mode,
+			optimal: ph.optimal,
+		})
+	}
+	for id, v := range ss.variants {
+		cp.variants[id] = v
+	}
+	s.sessions[cp.id] = cp
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": cp.id, "msg": "copy success"})
+}
@@ -0,0 +1,336 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package polyreducetest implements an in-process fake of the polyreduce
+// service for offline testing.
+//
+// A fake server keeps all models, sessions and jobs in memory, and
+// implements every API that is used by the polyreduce SDK:
+//
+//	s := polyreducetest.NewServer()
+//	defer s.Close()
+//
+//	c := s.Client()
+//	o, err := c.Ping(context.Background())
+//
+// Individual routes can be overridden using Server.Handle, and faults can
+// be injected into routes using Server.Inject.
+package polyreducetest
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+)
+
+// Default credentials that are accepted by a fake server.
+const (
+	Username = "way"
+	Password = "secret-pass"
+)
+
+// Version and BuildTime are reported by the ping of a fake server.
+const (
+	Version   = "v0.0.0-polyreducetest"
+	BuildTime = "2022-07-03T00:00:00Z"
+)
+
+// Routes of the fake server. A route is the method and the path pattern
+// of an API relative to polyreduce.DefaultBasePath.
+const (
+	RoutePing                = "GET /ping"
+	RoutePolyredUpload       = "POST /polyred/upload"
+	RoutePolyredConfig       = "POST /polyred/config/{model}"
+	RoutePolyredLayers       = "GET /polyred/layers/{model}"
+	RoutePolyredRun          = "POST /polyred/run/{model}"
+	RoutePolyredDownload     = "GET /polyred/download/{model}"
+	RoutePolyredSubmitRun    = "POST /jobs/polyred/run/{model}"
+	RouteProPolyredUpload    = "POST /propolyred/upload"
+	RouteProPolyredRun       = "POST /propolyred/run/{session}"
+	RouteProPolyredSubmitRun = "POST /jobs/propolyred/run/{session}"
+	RouteProPolyredDownload  = "GET /propolyred/download/{session}/{model}"
+	RouteProPolyredConfig    = "GET /propolyred/config/{session}/{model}"
+	RouteProPolyredInspect   = "GET /propolyred/evaluate/{session}"
+	RouteProPolyredEvaluate  = "PUT /propolyred/evaluate/{session}"
+	RouteProPolyredReset     = "POST /propolyred/reset/{session}"
+	RouteProPolyredCopy      = "POST /propolyred/copy/{session}"
+	RouteProPolyredHistory   = "GET /propolyred/evaluations/{session}"
+	RouteProPolyredAmend     = "PUT /propolyred/evaluations/{session}/{phase}"
+	RouteProPolyredRetract   = "DELETE /propolyred/evaluations/{session}/{phase}"
+	RouteJobStatus           = "GET /jobs/{job}"
+	RouteJobCancel           = "DELETE /jobs/{job}"
+)
+
+// Server is a fake polyreduce service.
+type Server struct {
+	// URL is the endpoint of the server, e.g. http://127.0.0.1:1234.
+	URL string
+
+	// Layers are the mesh names of every uploaded model.
+	Layers []string
+	// RunDuration is the duration of every reduction job.
+	RunDuration time.Duration
+
+	ts     *httptest.Server
+	routes []route
+
+	mu        sync.Mutex
+	username  string
+	password  string
+	version   string
+	buildTime string
+	skew      time.Duration
+	overrides map[string]http.Handler
+	faults    map[string][]Fault
+	rand      *rand.Rand
+	models    map[string]*model
+	sessions  map[string]*session
+	jobs      map[string]*job
+}
+
+// route is a registered API of the server.
+type route struct {
+	key     string
+	method  string
+	pattern []string
+	handler func(w http.ResponseWriter, r *http.Request, p params)
+}
+
+// params are the values of the placeholders of a route pattern.
+type params map[string]string
+
+// NewServer starts a fake polyreduce service. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		Layers:    []string{"default"},
+		username:  Username,
+		password:  Password,
+		version:   Version,
+		buildTime: BuildTime,
+		overrides: map[string]http.Handler{},
+		faults:    map[string][]Fault{},
+		rand:      rand.New(rand.NewSource(1)),
+		models:    map[string]*model{},
+		sessions:  map[string]*session{},
+		jobs:      map[string]*job{},
+	}
+	s.register(RoutePing, s.ping)
+	s.register(RoutePolyredUpload, s.polyredUpload)
+	s.register(RoutePolyredConfig, s.polyredConfig)
+	s.register(RoutePolyredLayers, s.polyredLayers)
+	s.register(RoutePolyredRun, s.polyredRun)
+	s.register(RoutePolyredDownload, s.polyredDownload)
+	s.register(RoutePolyredSubmitRun, s.polyredSubmitRun)
+	s.register(RouteProPolyredUpload, s.propolyredUpload)
+	s.register(RouteProPolyredRun, s.propolyredRun)
+	s.register(RouteProPolyredSubmitRun, s.propolyredSubmitRun)
+	s.register(RouteProPolyredDownload, s.propolyredDownload)
+	s.register(RouteProPolyredConfig, s.propolyredConfig)
+	s.register(RouteProPolyredInspect, s.propolyredInspect)
+	s.register(RouteProPolyredEvaluate, s.propolyredEvaluate)
+	s.register(RouteProPolyredReset, s.propolyredReset)
+	s.register(RouteProPolyredCopy, s.propolyredCopy)
+	s.register(RouteProPolyredHistory, s.propolyredHistory)
+	s.register(RouteProPolyredAmend, s.propolyredAmend)
+	s.register(RouteProPolyredRetract, s.propolyredRetract)
+	s.register(RouteJobStatus, s.jobStatus)
+	s.register(RouteJobCancel, s.jobCancel)
+
+	s.ts = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.ts.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.ts.Close()
+}
+
+// Client returns a polyreduce client that talks to the server. The
+// given options are applied after the options that point the client to
+// the server.
+func (s *Server) Client(opts ...polyreduce.Option) *polyreduce.Client {
+	s.mu.Lock()
+	u, p := s.username, s.password
+	s.mu.Unlock()
+
+	return polyreduce.NewClient(append([]polyreduce.Option{
+		polyreduce.WithEndpoint(s.URL),
+		polyreduce.WithHTTPClient(s.ts.Client()),
+		polyreduce.WithCredentials(u, p),
+		polyreduce.WithPollInterval(10 * time.Millisecond),
+		polyreduce.WithRetryPolicy(polyreduce.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond,
+			Multiplier:     2,
+		}),
+	}, opts...)...)
+}
+
+// SetCredentials sets the basic auth credentials that are accepted by
+// the server, Username and Password by default.
+func (s *Server) SetCredentials(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+// SetVersion sets the version and the build time that are reported by
+// the ping of the server, Version and BuildTime by default.
+func (s *Server) SetVersion(version, buildTime string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version, s.buildTime = version, buildTime
+}
+
+// SetClockSkew shifts the Date header of the ping of the server by the
+// given duration, e.g. to simulate a clock that is ahead.
+func (s *Server) SetClockSkew(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skew = d
+}
+
+// Handle overrides the given route, e.g. RoutePolyredRun, by the handler.
+// A nil handler restores the default behavior of the route.
+func (s *Server) Handle(route string, h http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h == nil {
+		delete(s.overrides, route)
+		return
+	}
+	s.overrides[route] = h
+}
+
+func (s *Server) register(key string, h func(w http.ResponseWriter, r *http.Request, p params)) {
+	method, pattern, _ := strings.Cut(key, " ")
+	s.routes = append(s.routes, route{
+		key:     key,
+		method:  method,
+		pattern: strings.Split(strings.Trim(pattern, "/"), "/"),
+		handler: h,
+	})
+}
+
+// match finds the route of the given request.
+func (s *Server) match(method, path string) (*route, params, bool) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	found := false
+	for i := range s.routes {
+		r := &s.routes[i]
+		if len(r.pattern) != len(segs) {
+			continue
+		}
+		p := params{}
+		ok := true
+		for j, seg := range r.pattern {
+			if strings.HasPrefix(seg, "{") {
+				p[strings.Trim(seg, "{}")] = segs[j]
+			} else if seg != segs[j] {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		found = true
+		if r.method == method {
+			return r, p, true
+		}
+	}
+	return nil, nil, found
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(polyreduce.RequestIDHeader, newID())
+
+	path := strings.TrimPrefix(r.URL.Path, polyreduce.DefaultBasePath)
+	rt, p, found := s.match(r.Method, path)
+	if rt == nil {
+		if found {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeError(w, http.StatusNotFound, "no such API")
+		return
+	}
+
+	s.mu.Lock()
+	override := s.overrides[rt.key]
+	u, pw := s.username, s.password
+	f, randErr, faulty := s.nextFault(rt.key)
+	s.mu.Unlock()
+
+	h := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt.key != RoutePing {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != u || pass != pw {
+				w.Header().Set("WWW-Authenticate", `Basic realm="polyreduce"`)
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+
+		if override != nil {
+			override.ServeHTTP(w, r)
+			return
+		}
+		rt.handler(w, r, p)
+	}))
+	if faulty {
+		serveFault(w, r, h, f, randErr)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request, _ params) {
+	s.mu.Lock()
+	version, buildTime, skew := s.version, s.buildTime, s.skew
+	s.mu.Unlock()
+
+	w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+	writeJSON(w, http.StatusOK, &polyreduce.PingOutput{
+		Version:   version,
+		BuildTime: buildTime,
+		Message:   "pong",
+	})
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response in the format of the service.
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeJSON(w, code, struct {
+		Message string `json:"msg"`
+	}{fmt.Sprintf(format, args...)})
+}
+
+// newID returns a random UUID.
+func newID() string {
+	var b [16]byte
+	crand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
@@ -0,0 +1,74 @@
+package polyreducetest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestServer_Handle(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	s.Handle(polyreducetest.RouteProPolyredInspect, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ids":["a","b"]}`))
+	}))
+	o, err := s.Client().ProPolyredInspect(context.Background(), &polyreduce.ProPolyredInspectInput{SessionId: "any"})
+	if err != nil {
+		t.Fatalf("failed to inspect: %v", err)
+	}
+	if len(o.Unevaluated) != 2 {
+		t.Fatalf("the overridden route should be used, got %v", o.Unevaluated)
+	}
+
+	s.Handle(polyreducetest.RouteProPolyredInspect, nil)
+	_, err = s.Client().ProPolyredInspect(context.Background(), &polyreduce.ProPolyredInspectInput{SessionId: "any"})
+	if !errors.Is(err, polyreduce.ErrNotFound) {
+		t.Fatalf("the default route should be restored, got %v", err)
+	}
+}
+
+func TestServer_Credentials(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	s.SetCredentials("alice", "secret")
+	_, err := s.Client().ProPolyredInspect(context.Background(), &polyreduce.ProPolyredInspectInput{SessionId: "any"})
+	if !errors.Is(err, polyreduce.ErrNotFound) {
+		t.Fatalf("the client of the server should use its credentials, got %v", err)
+	}
+
+	c := s.Client(polyreduce.WithCredentials(polyreducetest.Username, polyreducetest.Password))
+	_, err = c.ProPolyredInspect(context.Background(), &polyreduce.ProPolyredInspectInput{SessionId: "any"})
+	if !errors.Is(err, polyreduce.ErrUnauthorized) {
+		t.Fatalf("want ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestServer_Inject(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	s.Inject(polyreducetest.RoutePing,
+		polyreducetest.Fault{Status: http.StatusBadRequest, Times: 2},
+		polyreducetest.Fault{Status: http.StatusConflict, Times: 1},
+	)
+	c := s.Client(polyreduce.WithRetryPolicy(polyreduce.NoRetry))
+	for _, want := range []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusConflict, 0} {
+		_, err := c.Ping(context.Background())
+		var e *polyreduce.APIError
+		if want == 0 && err != nil || want != 0 && !(errors.As(err, &e) && e.StatusCode == want) {
+			t.Fatalf("want status %d, got %v", want, err)
+		}
+	}
+
+	s.Inject(polyreducetest.RoutePing, polyreducetest.Fault{Status: http.StatusConflict})
+	s.ClearFaults()
+	if _, err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("faults should be cleared, got %v", err)
+	}
+}
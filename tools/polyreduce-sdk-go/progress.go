@@ -0,0 +1,43 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import "io"
+
+// ProgressFunc reports the progress of a transfer. The transferred is
+// the number of bytes sent or received so far, and total is the number
+// of bytes of the whole transfer, or -1 if unknown.
+//
+// A ProgressFunc is called synchronously during the transfer, hence it
+// should return quickly. If a transfer is retried, the progress starts
+// over from zero.
+type ProgressFunc func(transferred, total int64)
+
+// progressReader reports the number of bytes read from r.
+type progressReader struct {
+	r     io.Reader
+	n     int64
+	total int64
+	fn    ProgressFunc
+}
+
+// withProgress returns a reader that reports the progress of reading r
+// to fn. It returns r itself if fn is nil.
+func withProgress(r io.Reader, total int64, fn ProgressFunc) io.Reader {
+	if fn == nil {
+		return r
+	}
+	fn(0, total)
+	return &progressReader{r: r, total: total, fn: fn}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.fn(p.n, p.total)
+	}
+	return n, err
+}
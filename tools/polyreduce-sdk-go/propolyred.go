@@ -5,22 +5,18 @@
 package polyreduce
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"mime/multipart"
 	"net/http"
-	"os"
-	"strings"
 )
 
 type ProPolyredUploadInput struct {
 	// ModelPath refers to an FBX file.
 	ModelPath string
+	// Progress optionally reports the progress of the upload.
+	Progress ProgressFunc
 }
 type ProPolyredUploadOutput struct {
 	// The session ID that can be reused anytime in subsequent requests.
@@ -29,58 +25,21 @@ type ProPolyredUploadOutput struct {
 	Message   string `json:"msg,omitempty"`
 }
 
+// ProPolyredUpload uploads an given FBX model to the polyreduce service
+// and creates a propolyred session. See ProPolyredUploadReader for
+// uploading a model that is not stored in a file.
 func (c *Client) ProPolyredUpload(ctx context.Context, i *ProPolyredUploadInput) (*ProPolyredUploadOutput, error) {
-	url := c.endpoint + "/api/v1/propolyred/upload"
-
-	if !strings.HasSuffix(strings.ToLower(i.ModelPath), ".fbx") {
-		return nil, errors.New("only .FBX model is supported")
-	}
-
-	b, err := os.ReadFile(i.ModelPath)
-	if err != nil {
-		return nil, err
-	}
-
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", i.ModelPath)
-	if err != nil {
-		return nil, err
-	}
-	part.Write(b)
-	err = writer.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	r, err := http.NewRequest(http.MethodPost, url, body)
-	if err != nil {
-		return nil, err
-	}
-	r.Header.Add("Content-Type", writer.FormDataContentType())
-	r.SetBasicAuth("way", "secret-pass")
-
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
+	req, err := uploadFile("/propolyred/upload", i.ModelPath, i.Progress)
 	if err != nil {
 		return nil, err
 	}
 
 	output := &ProPolyredUploadOutput{}
-	err = json.Unmarshal(data, output)
+	_, err = c.doJSON(ctx, req, output)
 	if err != nil {
 		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(output.Message)
-	}
-	return output, err
+	return output, nil
 }
 
 type ProPolyredRunInput struct {
@@ -92,62 +51,86 @@ type ProPolyredRunOutput struct {
 	Message        string   `json:"msg,omitempty"`
 }
 
+// ProPolyredRun runs the next phase of a propolyred session and returns
+// the IDs of the produced variants. The function blocks until the phase
+// is complete or server side error.
+//
+// ProPolyredRun is a shortcut of ProPolyredSubmitRun followed by Job.Wait.
+// If the service does not offer jobs, the phase runs on the legacy
+// blocking API instead.
 func (c *Client) ProPolyredRun(ctx context.Context, i *ProPolyredRunInput) (*ProPolyredRunOutput, error) {
-	url := fmt.Sprintf("%s/api/v1/propolyred/run/%s", c.endpoint, i.SessionId)
-	r, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	job, err := c.ProPolyredSubmitRun(ctx, i)
+	if errors.Is(err, ErrNotFound) {
+		// Either the session or the jobs API does not exist, which the
+		// legacy API tells apart.
+		o := &ProPolyredRunOutput{}
+		_, err = c.doJSON(ctx, &request{
+			method: http.MethodPost,
+			path:   "/propolyred/run/" + i.SessionId,
+		}, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run: %w", err)
+		}
+		return o, nil
 	}
-	r.SetBasicAuth("way", "secret-pass")
-
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("failed to send the request: %w", err)
+		return nil, fmt.Errorf("failed to run: %w", err)
 	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	output := &ProPolyredRunOutput{}
-	err = json.Unmarshal(data, output)
+	s, err := job.Wait(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse the run output: %w", err)
+		return nil, fmt.Errorf("failed to run: %w", err)
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(output.Message)
-	}
-	return output, nil
+	return &ProPolyredRunOutput{
+		Phases:         s.Phases,
+		AssumedOptimal: s.AssumedOptimal,
+		Message:        s.Message,
+	}, nil
 }
 
 type ProPolyredDownloadInput struct {
 	SessionId, PhaseId string
 	Path               string
+	// VerifyChecksum requires the downloaded model to match the checksum
+	// provided by the service.
+	VerifyChecksum bool
+	// Progress optionally reports the progress of the download.
+	Progress ProgressFunc
 }
 
-func (c *Client) ProPolyredDownload(ctx context.Context, i *ProPolyredDownloadInput) error {
-	url := fmt.Sprintf("%s/api/v1/propolyred/download/%s/%s", c.endpoint, i.SessionId, i.PhaseId)
-	r, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return err
-	}
-	r.SetBasicAuth("way", "secret-pass")
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// ProPolyredDownload downloads a model of a propolyred session. The
+// downloaded model is saved to the given path. The path is only written
+// if the complete model was received, an existing file at the path is
+// kept otherwise.
+func (c *Client) ProPolyredDownload(ctx context.Context, i *ProPolyredDownloadInput) (*DownloadOutput, error) {
+	return c.download(ctx, &request{
+		method:     http.MethodGet,
+		path:       fmt.Sprintf("/propolyred/download/%s/%s", i.SessionId, i.PhaseId),
+		idempotent: true,
+	}, i.Path, i.VerifyChecksum, i.Progress)
+}
 
-	f, err := os.Create(i.Path)
+type ProPolyredModelConfigInput struct {
+	SessionId, ModelId string
+}
+type ProPolyredModelConfigOutput struct {
+	// ReductionRatio is the reduction ratio per layer of the model.
+	ReductionRatio map[string]float64 `json:"percent"`
+	Message        string             `json:"msg,omitempty"`
+}
+
+// ProPolyredModelConfig returns the reduction configuration that
+// produced a variant of a propolyred session.
+func (c *Client) ProPolyredModelConfig(ctx context.Context, i *ProPolyredModelConfigInput) (*ProPolyredModelConfigOutput, error) {
+	output := &ProPolyredModelConfigOutput{}
+	_, err := c.doJSON(ctx, &request{
+		method:     http.MethodGet,
+		path:       fmt.Sprintf("/propolyred/config/%s/%s", i.SessionId, i.ModelId),
+		idempotent: true,
+	}, output)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
-	return err
+	return output, nil
 }
 
 type ProPolyredInspectInput struct {
@@ -160,80 +143,65 @@ type ProPolyredInspectOutput struct {
 
 // ProPolyredInspect returns a list of model IDs that are not yet evaluated.
 func (c *Client) ProPolyredInspect(ctx context.Context, i *ProPolyredInspectInput) (*ProPolyredInspectOutput, error) {
-	url := c.endpoint + "/api/v1/propolyred/evaluate/" + i.SessionId
-
-	r, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	r.SetBasicAuth("way", "secret-pass")
-
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
 	output := &ProPolyredInspectOutput{}
-	err = json.Unmarshal(data, output)
+	_, err := c.doJSON(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/propolyred/evaluate/" + i.SessionId,
+		idempotent: true,
+	}, output)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(output.Message)
-	}
-
-	return output, err
+	return output, nil
 }
 
 type ProPolyredEvaluateInput struct {
 	SessionId string
-	Rating    map[string]float64
+	// Rating maps from the model ID of a variant to its rating. Variants
+	// that are not rated are left out, Unrated is not allowed.
+	Rating map[string]Rating
+	// Mode optionally tells the service how the ratings were collected,
+	// see Evaluation.
+	Mode EvaluationMode
 }
 
 type ProPolyredEvaluateOutput struct {
 	Message string `json:"msg,omitempty"`
 }
 
+// ProPolyredEvaluate submits the ratings of variants of a propolyred
+// session. See Session.Evaluate for submitting a ranking, pairwise
+// judgments or a best-of-N pick.
 func (c *Client) ProPolyredEvaluate(ctx context.Context, i *ProPolyredEvaluateInput) error {
-	url := c.endpoint + "/api/v1/propolyred/evaluate/" + i.SessionId
-
+	if len(i.Rating) == 0 {
+		return errors.New("failed to evaluate: no rating")
+	}
+	for id, r := range i.Rating {
+		if !r.Valid() {
+			return fmt.Errorf("failed to evaluate: invalid rating of model %s: %v", id, r)
+		}
+	}
 	b, err := json.Marshal(i.Rating)
 	if err != nil {
 		return fmt.Errorf("failed to marshal rating: %w", err)
 	}
 
-	r, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(b))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-	r.SetBasicAuth("way", "secret-pass")
-
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to parse response body: %w", err)
+	var header http.Header
+	if i.Mode != "" {
+		header = http.Header{EvaluationModeHeader: {string(i.Mode)}}
 	}
 
 	output := &ProPolyredEvaluateOutput{}
-	err = json.Unmarshal(data, output)
+	_, err = c.doJSON(ctx, &request{
+		method:      http.MethodPut,
+		path:        "/propolyred/evaluate/" + i.SessionId,
+		body:        bytesBody(b),
+		contentType: "application/json; charset=UTF-8",
+		header:      header,
+	}, output)
 	if err != nil {
-		return fmt.Errorf("failed to parse evaluate output: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(output.Message)
+		return fmt.Errorf("failed to evaluate: %w", err)
 	}
 	return nil
 }
@@ -249,34 +217,15 @@ type ProPolyredResetOutput struct {
 }
 
 func (c *Client) ProPolyredReset(ctx context.Context, i *ProPolyredResetInput) (*ProPolyredResetOutput, error) {
-	url := c.endpoint + "/api/v1/propolyred/reset/" + i.SessionId
-	r, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	r.SetBasicAuth("way", "secret-pass")
-
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
 	output := &ProPolyredResetOutput{}
-	err = json.Unmarshal(data, output)
+	_, err := c.doJSON(ctx, &request{
+		method: http.MethodPost,
+		path:   "/propolyred/reset/" + i.SessionId,
+	}, output)
 	if err != nil {
 		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(output.Message)
-	}
-	return output, err
+	return output, nil
 }
 
 type ProPolyredCopyInput struct {
@@ -290,32 +239,135 @@ type ProPolyredCopyOutput struct {
 }
 
 func (c *Client) ProPolyredCopy(ctx context.Context, i *ProPolyredCopyInput) (*ProPolyredCopyOutput, error) {
-	url := c.endpoint + "/api/v1/propolyred/copy/" + i.SessionId
-	r, err := http.NewRequest(http.MethodPost, url, nil)
+	output := &ProPolyredCopyOutput{}
+	_, err := c.doJSON(ctx, &request{
+		method: http.MethodPost,
+		path:   "/propolyred/copy/" + i.SessionId,
+	}, output)
 	if err != nil {
 		return nil, err
 	}
-	r.SetBasicAuth("way", "secret-pass")
+	return output, nil
+}
 
-	resp, err := http.DefaultClient.Do(r.WithContext(ctx))
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
+type ProPolyredEvaluationsInput struct {
+	SessionId string
+}
+
+// PhaseEvaluation is the evaluation of a phase of a propolyred session.
+type PhaseEvaluation struct {
+	// Phase is the number of the phase, starting from 1.
+	Phase int      `json:"phase"`
+	IDs   []string `json:"ids"`
+	// Ratings are the ratings of the variants of the phase, empty if the
+	// phase is not evaluated.
+	Ratings map[string]Rating `json:"ratings"`
+	Mode    EvaluationMode    `json:"mode,omitempty"`
+	// AssumedOptimal is the assumed optimal reduction ratio when the
+	// phase was produced.
+	AssumedOptimal float64 `json:"optimal"`
+}
+
+type ProPolyredEvaluationsOutput struct {
+	Phases []PhaseEvaluation `json:"phases"`
+	// AssumedOptimal is the assumed optimal reduction ratio of the
+	// current evaluation history.
+	AssumedOptimal float64 `json:"optimal"`
+	Message        string  `json:"msg,omitempty"`
+}
 
-	data, err := ioutil.ReadAll(resp.Body)
+// ProPolyredEvaluations lists the evaluations of all phases of a
+// propolyred session.
+func (c *Client) ProPolyredEvaluations(ctx context.Context, i *ProPolyredEvaluationsInput) (*ProPolyredEvaluationsOutput, error) {
+	output := &ProPolyredEvaluationsOutput{}
+	_, err := c.doJSON(ctx, &request{
+		method:     http.MethodGet,
+		path:       "/propolyred/evaluations/" + i.SessionId,
+		idempotent: true,
+	}, output)
 	if err != nil {
 		return nil, err
 	}
+	return output, nil
+}
 
-	output := &ProPolyredCopyOutput{}
-	err = json.Unmarshal(data, output)
+type ProPolyredAmendInput struct {
+	SessionId string
+	// Phase is the number of the amended phase, starting from 1.
+	Phase int
+	// Rating replaces all ratings of the phase.
+	Rating map[string]Rating
+	Mode   EvaluationMode
+}
+
+type ProPolyredAmendOutput struct {
+	// AssumedOptimal is the assumed optimal reduction ratio that is
+	// recomputed from the amended history.
+	AssumedOptimal float64 `json:"optimal"`
+	Message        string  `json:"msg,omitempty"`
+}
+
+// ProPolyredAmend replaces the ratings of a past phase of a propolyred
+// session. The service recomputes its optimizer state from the amended
+// history.
+func (c *Client) ProPolyredAmend(ctx context.Context, i *ProPolyredAmendInput) (*ProPolyredAmendOutput, error) {
+	if len(i.Rating) == 0 {
+		return nil, errors.New("failed to amend: no rating, see ProPolyredRetract")
+	}
+	for id, r := range i.Rating {
+		if !r.Valid() {
+			return nil, fmt.Errorf("failed to amend: invalid rating of model %s: %v", id, r)
+		}
+	}
+	b, err := json.Marshal(i.Rating)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to marshal rating: %w", err)
+	}
+	var header http.Header
+	if i.Mode != "" {
+		header = http.Header{EvaluationModeHeader: {string(i.Mode)}}
+	}
+
+	output := &ProPolyredAmendOutput{}
+	_, err = c.doJSON(ctx, &request{
+		method:      http.MethodPut,
+		path:        fmt.Sprintf("/propolyred/evaluations/%s/%d", i.SessionId, i.Phase),
+		body:        bytesBody(b),
+		contentType: "application/json; charset=UTF-8",
+		header:      header,
+		idempotent:  true,
+	}, output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to amend: %w", err)
 	}
+	return output, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(output.Message)
+type ProPolyredRetractInput struct {
+	SessionId string
+	// Phase is the number of the retracted phase, starting from 1.
+	Phase int
+}
+
+type ProPolyredRetractOutput struct {
+	// AssumedOptimal is the assumed optimal reduction ratio that is
+	// recomputed from the remaining history.
+	AssumedOptimal float64 `json:"optimal"`
+	Message        string  `json:"msg,omitempty"`
+}
+
+// ProPolyredRetract retracts all ratings of a past phase of a
+// propolyred session, which makes the phase unevaluated. The service
+// recomputes its optimizer state from the remaining history.
+func (c *Client) ProPolyredRetract(ctx context.Context, i *ProPolyredRetractInput) (*ProPolyredRetractOutput, error) {
+	output := &ProPolyredRetractOutput{}
+	_, err := c.doJSON(ctx, &request{
+		method:     http.MethodDelete,
+		path:       fmt.Sprintf("/propolyred/evaluations/%s/%d", i.SessionId, i.Phase),
+		idempotent: true,
+	}, output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retract: %w", err)
 	}
-	return output, err
+	return output, nil
 }
@@ -0,0 +1,115 @@
+package polyreduce_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestProPolyred(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	o, err := c.ProPolyredUpload(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	sid := o.SessionId
+
+	run, err := c.ProPolyredRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: sid})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if len(run.Phases) != 4 {
+		t.Fatalf("want 4 variants, got %d", len(run.Phases))
+	}
+
+	_, err = c.ProPolyredRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: sid})
+	if !errors.Is(err, polyreduce.ErrConflict) {
+		t.Fatalf("running an unevaluated session should conflict, got %v", err)
+	}
+
+	insp, err := c.ProPolyredInspect(ctx, &polyreduce.ProPolyredInspectInput{SessionId: sid})
+	if err != nil {
+		t.Fatalf("failed to inspect: %v", err)
+	}
+	if len(insp.Unevaluated) != 4 {
+		t.Fatalf("want 4 unevaluated variants, got %v", insp.Unevaluated)
+	}
+
+	d, err := c.ProPolyredDownload(ctx, &polyreduce.ProPolyredDownloadInput{
+		SessionId: sid,
+		PhaseId:   run.Phases[0],
+		Path:      filepath.Join(t.TempDir(), "variant.fbx"),
+	})
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if d.Format != polyreduce.FormatBinaryFBX {
+		t.Fatalf("unexpected format: %s", d.Format)
+	}
+
+	err = c.ProPolyredEvaluate(ctx, &polyreduce.ProPolyredEvaluateInput{
+		SessionId: sid,
+		Rating:    map[string]polyreduce.Rating{run.Phases[0]: polyreduce.Terrible, run.Phases[1]: polyreduce.Excellent, run.Phases[2]: polyreduce.Fair, run.Phases[3]: polyreduce.Poor},
+	})
+	if err != nil {
+		t.Fatalf("failed to evaluate: %v", err)
+	}
+
+	run2, err := c.ProPolyredRun(ctx, &polyreduce.ProPolyredRunInput{SessionId: sid})
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	ss, _ := s.Session(sid)
+	if run2.AssumedOptimal != ss.Configs[run.Phases[1]]["default"] {
+		t.Fatalf("the assumed optimal should follow the best rated variant, got %v", run2.AssumedOptimal)
+	}
+
+	cp, err := c.ProPolyredCopy(ctx, &polyreduce.ProPolyredCopyInput{SessionId: sid})
+	if err != nil {
+		t.Fatalf("failed to copy: %v", err)
+	}
+	if cp.SessionId == sid {
+		t.Fatalf("a copy should be a different session")
+	}
+
+	r, err := c.ProPolyredReset(ctx, &polyreduce.ProPolyredResetInput{SessionId: sid})
+	if err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	ss, _ = s.Session(r.SessionId)
+	if len(ss.Phases) != 0 {
+		t.Fatalf("a reset session should have no phases, got %d", len(ss.Phases))
+	}
+	cs, _ := s.Session(cp.SessionId)
+	if len(cs.Phases) != 2 {
+		t.Fatalf("a reset should not affect the copy, got %d phases", len(cs.Phases))
+	}
+}
+
+func TestProPolyred_EvaluateUnknownModel(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	o, err := c.ProPolyredUpload(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	err = c.ProPolyredEvaluate(ctx, &polyreduce.ProPolyredEvaluateInput{
+		SessionId: o.SessionId,
+		Rating:    map[string]polyreduce.Rating{"unknown": polyreduce.Fair},
+	})
+	var apiErr *polyreduce.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("want a bad request, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Rating is the rating of a variant on the scale of the study.
+type Rating int
+
+// The rating scale of the study. Skip is an explicit rating of a variant
+// that the rater did not want to judge, and it is submitted to the
+// service like any other rating.
+const (
+	Skip Rating = iota
+	Terrible
+	Poor
+	Fair
+	Good
+	Excellent
+)
+
+// Unrated is the state of a variant that has no rating at all. It is
+// never submitted to the service, and represents the negative scores
+// in the dataset.
+const Unrated Rating = -1
+
+var ratingLabels = [...]string{"Skip", "Terrible", "Poor", "Fair", "Good", "Excellent"}
+
+// Ratings returns all ratings of the scale from Skip to Excellent.
+func Ratings() []Rating {
+	return []Rating{Skip, Terrible, Poor, Fair, Good, Excellent}
+}
+
+// Valid reports whether the rating is on the scale of the study.
+func (r Rating) Valid() bool {
+	return r >= Skip && r <= Excellent
+}
+
+// String returns the label of the rating.
+func (r Rating) String() string {
+	switch {
+	case r.Valid():
+		return ratingLabels[r]
+	case r == Unrated:
+		return "Unrated"
+	}
+	return "Rating(" + strconv.Itoa(int(r)) + ")"
+}
+
+// ParseRating parses a rating from its label, e.g. "good", or from its
+// number, e.g. "4". Labels are case insensitive.
+func ParseRating(s string) (Rating, error) {
+	s = strings.TrimSpace(s)
+	for i, l := range ratingLabels {
+		if strings.EqualFold(s, l) {
+			return Rating(i), nil
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && Rating(n).Valid() {
+		return Rating(n), nil
+	}
+	return Unrated, fmt.Errorf("polyreduce: invalid rating %q, expect a number from %d to %d or one of %s",
+		s, Skip, Excellent, strings.Join(ratingLabels[:], ", "))
+}
+
+// MarshalJSON encodes the rating as its number.
+func (r Rating) MarshalJSON() ([]byte, error) {
+	if !r.Valid() && r != Unrated {
+		return nil, fmt.Errorf("polyreduce: invalid rating %d", int(r))
+	}
+	return []byte(strconv.Itoa(int(r))), nil
+}
+
+// UnmarshalJSON decodes a rating from its number or label. Any negative
+// number is decoded as Unrated.
+func (r *Rating) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		v, err := ParseRating(s)
+		if err != nil {
+			return err
+		}
+		*r = v
+		return nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	switch {
+	case f < 0:
+		*r = Unrated
+	case f == math.Trunc(f) && Rating(f).Valid():
+		*r = Rating(f)
+	default:
+		return fmt.Errorf("polyreduce: invalid rating %v", f)
+	}
+	return nil
+}
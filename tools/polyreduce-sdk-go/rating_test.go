@@ -0,0 +1,66 @@
+package polyreduce_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+)
+
+func TestParseRating(t *testing.T) {
+	tests := []struct {
+		in   string
+		want polyreduce.Rating
+		ok   bool
+	}{
+		{"skip", polyreduce.Skip, true},
+		{"Excellent", polyreduce.Excellent, true},
+		{" POOR ", polyreduce.Poor, true},
+		{"0", polyreduce.Skip, true},
+		{"3", polyreduce.Fair, true},
+		{"6", polyreduce.Unrated, false},
+		{"-1", polyreduce.Unrated, false},
+		{"unrated", polyreduce.Unrated, false},
+		{"2.5", polyreduce.Unrated, false},
+	}
+	for _, tt := range tests {
+		got, err := polyreduce.ParseRating(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseRating(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRating_JSON(t *testing.T) {
+	var m map[string]polyreduce.Rating
+	err := json.Unmarshal([]byte(`{"a":4,"b":-1,"c":"terrible","d":0,"e":-3.5}`), &m)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	want := map[string]polyreduce.Rating{
+		"a": polyreduce.Good,
+		"b": polyreduce.Unrated,
+		"c": polyreduce.Terrible,
+		"d": polyreduce.Skip,
+		"e": polyreduce.Unrated,
+	}
+	for k, v := range want {
+		if m[k] != v {
+			t.Fatalf("want %v, got %v", want, m)
+		}
+	}
+	b, err := json.Marshal(map[string]polyreduce.Rating{"a": polyreduce.Excellent})
+	if err != nil || string(b) != `{"a":5}` {
+		t.Fatalf("unexpected encoding %s: %v", b, err)
+	}
+
+	for _, in := range []string{`2.5`, `6`, `"great"`} {
+		var r polyreduce.Rating
+		if err := json.Unmarshal([]byte(in), &r); err == nil {
+			t.Errorf("%s should be an invalid rating", in)
+		}
+	}
+	if _, err := json.Marshal(polyreduce.Rating(9)); err == nil {
+		t.Errorf("an invalid rating should not be encoded")
+	}
+}
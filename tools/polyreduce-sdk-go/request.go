@@ -0,0 +1,195 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// request describes a single call to the polyreduce service.
+type request struct {
+	method string
+	// path is the API path relative to the base path, e.g. /ping.
+	path string
+	// body returns a fresh request body for every attempt of the call.
+	// A nil body means the request has no body.
+	body func() (io.Reader, error)
+	// contentLength is the length of the body, if known in advance.
+	contentLength int64
+	contentType   string
+	// header are additional headers of the request.
+	header http.Header
+	// idempotent marks the call as safe to be retried.
+	idempotent bool
+	// oneShot marks the body as not replayable, which prevents retries.
+	oneShot bool
+}
+
+// bytesBody returns a request body that can be sent many times.
+func bytesBody(b []byte) func() (io.Reader, error) {
+	return func() (io.Reader, error) { return bytes.NewReader(b), nil }
+}
+
+// url returns the absolute URL of the given API path.
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.endpoint, "/") + c.basePath + path
+}
+
+// do sends the request through the HTTP pipeline of the client and
+// passes a successful response to read, which must consume the body.
+// All client settings, such as credentials, timeouts and retries, are
+// applied here. A non-2xx response is reported as an *APIError.
+//
+// If read fails with an error that wraps an *interruptedError, the
+// response was cut short and the request is retried like a failed
+// attempt.
+func (c *Client) do(ctx context.Context, req *request, read func(resp *http.Response) error) error {
+	retry := retryAllowed(ctx, req)
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		transient := err != nil
+		if err == nil {
+			err = read(resp)
+			resp.Body.Close()
+			if err == nil {
+				return nil
+			}
+			transient = errors.As(err, new(*interruptedError))
+		}
+
+		ev := RetryEvent{
+			Endpoint: req.method + " " + c.basePath + req.path,
+			Attempt:  attempt,
+			Err:      err,
+			Retry:    retry && transient && attempt < c.retry.MaxAttempts && retryable(ctx, err),
+		}
+		if ev.Retry {
+			ev.Backoff = c.retry.backoff(attempt, err)
+		}
+		if c.retryHook != nil {
+			c.retryHook(ev)
+		}
+		if !ev.Retry {
+			return err
+		}
+
+		t := time.NewTimer(ev.Backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// send sends a single attempt of the request.
+func (c *Client) send(ctx context.Context, req *request) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+
+	var body io.Reader
+	if req.body != nil {
+		var err error
+		body, err = req.body()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, c.url(req.path), body)
+	if err != nil {
+		if rc, ok := body.(io.Closer); ok {
+			rc.Close()
+		}
+		cancel()
+		return nil, err
+	}
+	if req.contentLength > 0 {
+		r.ContentLength = req.contentLength
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+	if req.contentType != "" {
+		r.Header.Set("Content-Type", req.contentType)
+	}
+	r.Header.Set("User-Agent", c.userAgent)
+	if c.username != "" || c.password != "" {
+		r.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.hc.Do(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := newAPIError(resp)
+		resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	// The timeout covers reading the body, hence the context is
+	// canceled when the caller closes the body.
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// doJSON sends the request and parses the JSON response body into out.
+// The returned response is already closed and only its status and
+// headers are meaningful.
+func (c *Client) doJSON(ctx context.Context, req *request, out interface{}) (*http.Response, error) {
+	var resp *http.Response
+	err := c.do(ctx, req, func(r *http.Response) error {
+		resp = r
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return &interruptedError{n: int64(len(data)), err: err}
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("polyreduce: invalid response of %s %s%s: %w", req.method, c.basePath, req.path, err)
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// interruptedError is an error of a response body that was cut short
+// after n bytes.
+type interruptedError struct {
+	n   int64
+	err error
+}
+
+func (e *interruptedError) Error() string {
+	return fmt.Sprintf("polyreduce: response interrupted after %d bytes: %v", e.n, e.err)
+}
+
+func (e *interruptedError) Unwrap() error { return e.err }
+
+// cancelBody releases the context of a request once the response body
+// is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how a failed call is retried. A call is retried
+// if it failed because of a transport error or a status code that is
+// considered transient (429, 502, 503 and 504).
+//
+// Only idempotent operations are retried by default, i.e. the calls
+// that read a resource, such as Ping and the downloads, or that replace
+// or delete it as a whole. The HTTP method alone does not decide it:
+// ProPolyredEvaluate is a PUT, but it records a new evaluation on every
+// call and is not retried. Other operations, e.g. the uploads, the runs
+// and the evaluations, are retried only if their context is derived
+// from ContextWithRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a call, including
+	// the first one. A value less than two disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts. A Retry-After
+	// header sent by the service is respected even if it is longer.
+	MaxBackoff time.Duration
+	// Multiplier is the growth factor of the delay per attempt.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, of the delay that is
+	// randomized to avoid synchronized retries of many clients.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy of a client if no other policy
+// is configured.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// NoRetry is a retry policy that never retries.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// RetryEvent describes a failed attempt of a call.
+type RetryEvent struct {
+	// Endpoint is the method and path of the call.
+	Endpoint string
+	// Attempt is the number of the failed attempt, starting from 1.
+	Attempt int
+	// Err is the error of the failed attempt.
+	Err error
+	// Retry reports whether the call is going to be retried.
+	Retry bool
+	// Backoff is the delay before the next attempt if Retry is true.
+	Backoff time.Duration
+}
+
+// RetryHook observes retry decisions of a client.
+type RetryHook func(e RetryEvent)
+
+// WithRetryPolicy sets the retry policy of the client.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithRetryHook sets a hook that is called after every failed attempt.
+func WithRetryHook(h RetryHook) Option {
+	return func(c *Client) { c.retryHook = h }
+}
+
+type retryKey struct{}
+
+// ContextWithRetry returns a context that allows a call to be retried
+// even if the operation is not idempotent, e.g. PolyredRun.
+func ContextWithRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, true)
+}
+
+func retryAllowed(ctx context.Context, req *request) bool {
+	if req.oneShot {
+		return false
+	}
+	if req.idempotent {
+		return true
+	}
+	ok, _ := ctx.Value(retryKey{}).(bool)
+	return ok
+}
+
+// retryable reports whether the error of an attempt is transient.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var e *APIError
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// Any other error is caused by the transport.
+	return true
+}
+
+// backoff returns the delay after the given failed attempt.
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var e *APIError
+	if errors.As(err, &e) && e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(math.Max(p.Multiplier, 1), float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is
+// either a number of seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
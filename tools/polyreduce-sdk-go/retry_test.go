@@ -0,0 +1,61 @@
+package polyreduce_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestRetry(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	calls := 0
+	s.Handle(polyreducetest.RoutePing, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"version":"v1"}`))
+	}))
+
+	events := []polyreduce.RetryEvent{}
+	c := s.Client(polyreduce.WithRetryHook(func(e polyreduce.RetryEvent) {
+		events = append(events, e)
+	}))
+	_, err := c.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("ping should succeed after retries: %v", err)
+	}
+	if calls != 3 || len(events) != 2 || !events[1].Retry || events[1].Attempt != 2 {
+		t.Fatalf("unexpected retries: calls=%d, events=%+v", calls, events)
+	}
+}
+
+func TestRetry_NotIdempotent(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+
+	calls := 0
+	s.Handle(polyreducetest.RoutePolyredConfig, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	in := &polyreduce.PolyredConfigInput{ModelID: "any", ReductionRatio: map[string]float64{"default": 1}}
+	err := s.Client().PolyredConfig(context.Background(), in)
+	if !errors.Is(err, polyreduce.ErrServerBusy) || calls != 1 {
+		t.Fatalf("a non idempotent call should not be retried: calls=%d, err=%v", calls, err)
+	}
+
+	calls = 0
+	err = s.Client().PolyredConfig(polyreduce.ContextWithRetry(context.Background()), in)
+	if !errors.Is(err, polyreduce.ErrServerBusy) || calls != 3 {
+		t.Fatalf("an opted-in call should be retried: calls=%d, err=%v", calls, err)
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Session is a propolyred session. It remembers the phases produced by
+// the session, the ratings that were submitted and the variants that are
+// not yet evaluated, so that callers do not have to pass session and
+// phase IDs around by hand.
+//
+// A Session is serializable as JSON, see Session.Save and
+// Client.LoadSession. It is not safe for concurrent use.
+type Session struct {
+	// ID is the session ID, which is also the ID of the root model.
+	ID string `json:"id"`
+	// Phases are all phases of the session in the order they were run.
+	Phases []*Phase `json:"phases"`
+	// Unevaluated are the IDs of all variants that are not yet rated.
+	Unevaluated []string `json:"unevaluated"`
+
+	c *Client
+}
+
+// Phase is the result of a single run of a session.
+type Phase struct {
+	// IDs are the model IDs of the variants produced by the phase.
+	IDs []string `json:"ids"`
+	// AssumedOptimal is the assumed optimal reduction ratio reported by
+	// the service when the phase was produced.
+	AssumedOptimal float64 `json:"optimal"`
+	// Ratings are the submitted ratings of the variants.
+	Ratings map[string]Rating `json:"ratings,omitempty"`
+	// Mode is the mode of the evaluation of the phase.
+	Mode EvaluationMode `json:"mode,omitempty"`
+}
+
+// StartSession uploads a model and starts a new propolyred session.
+func (c *Client) StartSession(ctx context.Context, i *ProPolyredUploadInput) (*Session, error) {
+	o, err := c.ProPolyredUpload(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: o.SessionId, Unevaluated: []string{}, c: c}, nil
+}
+
+// Session returns the handle of an existing session with the given ID.
+// The history of the session is unknown to the handle, call Refresh to
+// fetch it.
+func (c *Client) Session(id string) *Session {
+	return &Session{ID: id, Unevaluated: []string{}, c: c}
+}
+
+// LoadSession loads a session that was saved by Session.Save, e.g. to
+// resume a study after a crash.
+func (c *Client) LoadSession(path string) (*Session, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	s := &Session{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	if s.ID == "" {
+		return nil, fmt.Errorf("failed to load session: %s has no session ID", path)
+	}
+	s.c = c
+	return s, nil
+}
+
+// Save writes the session as JSON to the given path. The file is
+// replaced atomically, so that a crash never leaves a partial session.
+func (s *Session) Save(path string) error {
+	if err := WriteJSONFile(path, s); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// WriteJSONFile writes v as indented JSON to the given path. The file is
+// replaced atomically through a unique temporary file, hence concurrent
+// writers never clobber each other's partial writes.
+func WriteJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Current returns the latest phase, or nil if the session never ran.
+func (s *Session) Current() *Phase {
+	if len(s.Phases) == 0 {
+		return nil
+	}
+	return s.Phases[len(s.Phases)-1]
+}
+
+// AssumedOptimal returns the assumed optimal reduction ratio of every
+// phase in the order they were run.
+func (s *Session) AssumedOptimal() []float64 {
+	h := make([]float64, len(s.Phases))
+	for i, p := range s.Phases {
+		h[i] = p.AssumedOptimal
+	}
+	return h
+}
+
+// Next runs the next phase of the session and returns it. The variants
+// of the latest phase must be rated before.
+func (s *Session) Next(ctx context.Context) (*Phase, error) {
+	o, err := s.c.ProPolyredRun(ctx, &ProPolyredRunInput{SessionId: s.ID})
+	if err != nil {
+		return nil, err
+	}
+	p := &Phase{
+		IDs:            o.Phases,
+		AssumedOptimal: o.AssumedOptimal,
+		Ratings:        map[string]Rating{},
+	}
+	s.Phases = append(s.Phases, p)
+	s.Unevaluated = append(s.Unevaluated, o.Phases...)
+	return p, nil
+}
+
+// Rate submits the ratings of variants of the current phase. Variants
+// that are left out stay unevaluated.
+func (s *Session) Rate(ctx context.Context, rating map[string]Rating) error {
+	return s.Evaluate(ctx, Scores(rating))
+}
+
+// Evaluate converts the evaluation of the current phase to ratings and
+// submits them.
+func (s *Session) Evaluate(ctx context.Context, e Evaluation) error {
+	p := s.Current()
+	if p == nil {
+		return errors.New("failed to evaluate: the session has no phase")
+	}
+	rating, err := e.Ratings(p.IDs)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate: %w", err)
+	}
+	err = s.c.ProPolyredEvaluate(ctx, &ProPolyredEvaluateInput{
+		SessionId: s.ID,
+		Rating:    rating,
+		Mode:      e.Mode(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.Ratings == nil {
+		p.Ratings = map[string]Rating{}
+	}
+	for id, r := range rating {
+		p.Ratings[id] = r
+	}
+	p.Mode = e.Mode()
+	unevaluated := s.Unevaluated[:0]
+	for _, id := range s.Unevaluated {
+		if _, ok := rating[id]; !ok {
+			unevaluated = append(unevaluated, id)
+		}
+	}
+	s.Unevaluated = unevaluated
+	return nil
+}
+
+// Refresh fetches the phases, the ratings and the unevaluated variants
+// of the session from the service.
+func (s *Session) Refresh(ctx context.Context) error {
+	h, err := s.c.ProPolyredEvaluations(ctx, &ProPolyredEvaluationsInput{SessionId: s.ID})
+	if err != nil {
+		return err
+	}
+	o, err := s.c.ProPolyredInspect(ctx, &ProPolyredInspectInput{SessionId: s.ID})
+	if err != nil {
+		return err
+	}
+
+	s.Phases = nil
+	for _, e := range h.Phases {
+		p := &Phase{
+			IDs:            e.IDs,
+			AssumedOptimal: e.AssumedOptimal,
+			Ratings:        e.Ratings,
+			Mode:           e.Mode,
+		}
+		if p.Ratings == nil {
+			p.Ratings = map[string]Rating{}
+		}
+		s.Phases = append(s.Phases, p)
+	}
+	s.Unevaluated = append([]string{}, o.Unevaluated...)
+	return nil
+}
+
+// Amend replaces the ratings of the phase with the given number,
+// starting from 1, by the evaluation. The service recomputes the assumed
+// optimal reduction ratio from the amended history.
+func (s *Session) Amend(ctx context.Context, phase int, e Evaluation) error {
+	p, err := s.phase(phase)
+	if err != nil {
+		return fmt.Errorf("failed to amend: %w", err)
+	}
+	rating, err := e.Ratings(p.IDs)
+	if err != nil {
+		return fmt.Errorf("failed to amend: %w", err)
+	}
+	_, err = s.c.ProPolyredAmend(ctx, &ProPolyredAmendInput{
+		SessionId: s.ID,
+		Phase:     phase,
+		Rating:    rating,
+		Mode:      e.Mode(),
+	})
+	if err != nil {
+		return err
+	}
+	s.setRatings(p, rating, e.Mode())
+	return nil
+}
+
+// Retract retracts all ratings of the phase with the given number,
+// starting from 1. The variants of the phase become unevaluated.
+func (s *Session) Retract(ctx context.Context, phase int) error {
+	p, err := s.phase(phase)
+	if err != nil {
+		return fmt.Errorf("failed to retract: %w", err)
+	}
+	_, err = s.c.ProPolyredRetract(ctx, &ProPolyredRetractInput{SessionId: s.ID, Phase: phase})
+	if err != nil {
+		return err
+	}
+	s.setRatings(p, nil, "")
+	return nil
+}
+
+// phase returns the phase with the given number, starting from 1.
+func (s *Session) phase(n int) (*Phase, error) {
+	if n < 1 || n > len(s.Phases) {
+		return nil, fmt.Errorf("session %s has no phase %d", s.ID, n)
+	}
+	return s.Phases[n-1], nil
+}
+
+// setRatings replaces the ratings of the phase, and updates the
+// unevaluated variants accordingly.
+func (s *Session) setRatings(p *Phase, rating map[string]Rating, mode EvaluationMode) {
+	p.Ratings = map[string]Rating{}
+	for id, r := range rating {
+		p.Ratings[id] = r
+	}
+	p.Mode = mode
+
+	unevaluated := []string{}
+	for _, id := range s.Unevaluated {
+		if !contains(p.IDs, id) {
+			unevaluated = append(unevaluated, id)
+		}
+	}
+	for _, id := range p.IDs {
+		if _, ok := p.Ratings[id]; !ok {
+			unevaluated = append(unevaluated, id)
+		}
+	}
+	s.Unevaluated = unevaluated
+}
+
+// DownloadPhase downloads the variant with the given ID to the given
+// path. The root model is downloaded if the ID is the session ID.
+func (s *Session) DownloadPhase(ctx context.Context, phaseID, path string) (*DownloadOutput, error) {
+	return s.c.ProPolyredDownload(ctx, &ProPolyredDownloadInput{
+		SessionId: s.ID,
+		PhaseId:   phaseID,
+		Path:      path,
+	})
+}
+
+// Reset resets the session to its root model and forgets its history.
+func (s *Session) Reset(ctx context.Context) error {
+	_, err := s.c.ProPolyredReset(ctx, &ProPolyredResetInput{SessionId: s.ID})
+	if err != nil {
+		return err
+	}
+	s.Phases = nil
+	s.Unevaluated = []string{}
+	return nil
+}
+
+// Fork copies the session on the service and returns the copy, which
+// shares the history of the session but evolves independently.
+func (s *Session) Fork(ctx context.Context) (*Session, error) {
+	o, err := s.c.ProPolyredCopy(ctx, &ProPolyredCopyInput{SessionId: s.ID})
+	if err != nil {
+		return nil, err
+	}
+	fork := &Session{
+		ID:          o.SessionId,
+		Unevaluated: append([]string{}, s.Unevaluated...),
+		c:           s.c,
+	}
+	for _, p := range s.Phases {
+		cp := &Phase{
+			IDs:            append([]string(nil), p.IDs...),
+			AssumedOptimal: p.AssumedOptimal,
+			Ratings:        map[string]Rating{},
+			Mode:           p.Mode,
+		}
+		for id, r := range p.Ratings {
+			cp.Ratings[id] = r
+		}
+		fork.Phases = append(fork.Phases, cp)
+	}
+	return fork, nil
+}
+
+// Rating returns the rating of the given variant, or Unrated if the
+// variant is not rated.
+func (p *Phase) Rating(id string) Rating {
+	if r, ok := p.Ratings[id]; ok {
+		return r
+	}
+	return Unrated
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
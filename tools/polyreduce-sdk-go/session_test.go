@@ -0,0 +1,147 @@
+package polyreduce_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestSession(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	ss, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	p, err := ss.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if len(ss.Unevaluated) != len(p.IDs) || ss.Current() != p {
+		t.Fatalf("the new phase should be unevaluated, got %v", ss.Unevaluated)
+	}
+	if _, err := ss.DownloadPhase(ctx, p.IDs[0], filepath.Join(t.TempDir(), "v.fbx")); err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	if err := ss.Rate(ctx, map[string]polyreduce.Rating{p.IDs[2]: polyreduce.Excellent}); err != nil {
+		t.Fatalf("failed to rate: %v", err)
+	}
+	if len(ss.Unevaluated) != len(p.IDs)-1 || p.Ratings[p.IDs[2]] != 5 {
+		t.Fatalf("the rating should be recorded, got %v and %v", ss.Unevaluated, p.Ratings)
+	}
+
+	// Resume the session from a file.
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := ss.Save(path); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	resumed, err := c.LoadSession(path)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if _, err := resumed.Next(ctx); err != nil {
+		t.Fatalf("failed to run a resumed session: %v", err)
+	}
+	err = resumed.Rate(ctx, map[string]polyreduce.Rating{p.IDs[0]: polyreduce.Good})
+	if err == nil {
+		t.Fatalf("a variant of a previous phase should not be rated")
+	}
+	err = resumed.Rate(ctx, map[string]polyreduce.Rating{resumed.Current().IDs[0]: polyreduce.Unrated})
+	if err == nil {
+		t.Fatalf("an unrated variant should not be submitted")
+	}
+	snap, _ := s.Session(ss.ID)
+	h := resumed.AssumedOptimal()
+	if len(h) != 2 || h[1] != snap.Configs[p.IDs[2]]["default"] {
+		t.Fatalf("unexpected assumed optimal history: %v", h)
+	}
+	if err := resumed.Refresh(ctx); err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+	if len(resumed.Unevaluated) != 2*len(p.IDs)-1 {
+		t.Fatalf("unexpected unevaluated variants: %v", resumed.Unevaluated)
+	}
+
+	fork, err := resumed.Fork(ctx)
+	if err != nil {
+		t.Fatalf("failed to fork: %v", err)
+	}
+	if fork.ID == resumed.ID || len(fork.Phases) != 2 {
+		t.Fatalf("a fork should be a different session with the same history")
+	}
+	if err := resumed.Reset(ctx); err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	if len(resumed.Phases) != 0 || len(fork.Phases) != 2 {
+		t.Fatalf("a reset should only clear the reset session")
+	}
+}
+
+func TestSession_AmendRetract(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	ss, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	p1, err := ss.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if err := ss.Evaluate(ctx, polyreduce.BestOfN{Best: p1.IDs[0]}); err != nil {
+		t.Fatalf("failed to evaluate: %v", err)
+	}
+	if _, err := ss.Next(ctx); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+
+	// Regret the evaluation of the first phase.
+	if err := ss.Amend(ctx, 1, polyreduce.BestOfN{Best: p1.IDs[3]}); err != nil {
+		t.Fatalf("failed to amend: %v", err)
+	}
+	h, err := c.ProPolyredEvaluations(ctx, &polyreduce.ProPolyredEvaluationsInput{SessionId: ss.ID})
+	if err != nil {
+		t.Fatalf("failed to list evaluations: %v", err)
+	}
+	snap, _ := s.Session(ss.ID)
+	if h.AssumedOptimal != snap.Configs[p1.IDs[3]]["default"] {
+		t.Fatalf("the optimal should be recomputed from the amended history, got %v", h.AssumedOptimal)
+	}
+	if len(h.Phases) != 2 || h.Phases[0].Ratings[p1.IDs[3]] != polyreduce.Excellent || h.Phases[0].Mode != polyreduce.ModeBestOfN {
+		t.Fatalf("unexpected history: %+v", h.Phases)
+	}
+
+	if err := ss.Retract(ctx, 1); err != nil {
+		t.Fatalf("failed to retract: %v", err)
+	}
+	if len(ss.Unevaluated) != 2*len(p1.IDs) || len(p1.Ratings) != 0 {
+		t.Fatalf("the retracted phase should be unevaluated, got %v", ss.Unevaluated)
+	}
+	if snap, _ := s.Session(ss.ID); snap.Optimal != polyreducetest.InitialOptimal {
+		t.Fatalf("the optimal should be recomputed without the retracted phase, got %v", snap.Optimal)
+	}
+	if err := ss.Retract(ctx, 3); err == nil {
+		t.Fatalf("a missing phase should not be retracted")
+	}
+
+	// A new handle fetches the history from the service.
+	handle := c.Session(ss.ID)
+	if err := handle.Refresh(ctx); err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+	if len(handle.Phases) != 2 || len(handle.Unevaluated) != 2*len(p1.IDs) {
+		t.Fatalf("unexpected refreshed session: %+v", handle)
+	}
+	if err := handle.Rate(ctx, map[string]polyreduce.Rating{handle.Current().IDs[1]: polyreduce.Fair}); err != nil {
+		t.Fatalf("failed to rate a refreshed session: %v", err)
+	}
+}
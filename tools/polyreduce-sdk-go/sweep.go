@@ -0,0 +1,279 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/mesh"
+)
+
+// SweepInput configures a reduction sweep, which reduces a model to each
+// of a series of reduction ratios.
+type SweepInput struct {
+	// ModelPath refers to an FBX file.
+	ModelPath string
+	// ModelID is an optional uploaded copy of the model, which the
+	// first reduction uses instead of uploading the model again.
+	ModelID string
+	// Ratios are the reduction ratios of all layers, see RatioRange.
+	Ratios []float64
+	// Layers are reduction ratios of specific layers that override the
+	// swept ratio, e.g. Wheel*=100 to keep the wheels unreduced. See
+	// ResolveReductionRatio.
+	Layers []LayerRatio
+	// Dir is the directory of the reduced models.
+	Dir string
+	// Name is the file name prefix of the reduced models, which are
+	// saved as <Name>_<ratio>.fbx. The file name of the model without
+	// extension and without a _0 suffix is used if empty, hence the
+	// reductions of teapot_0.fbx are saved as teapot_<ratio>.fbx.
+	Name string
+	// Concurrency is the number of ratios that are reduced at the same
+	// time, 1 if zero. Every concurrent reduction uploads its own copy
+	// of the model, since a model has a single configuration.
+	Concurrency int
+	// Progress is called after every ratio, one call at a time.
+	Progress func(r *SweepResult)
+}
+
+// SweepResult is the result of a single ratio of a sweep.
+type SweepResult struct {
+	// Ratio is the requested reduction ratio.
+	Ratio float64
+	// ModelID is the uploaded copy of the model that is reduced, empty
+	// if the ratio is skipped or the upload failed.
+	ModelID string
+	// Config is the reduction ratio per layer, nil if the ratio is
+	// skipped.
+	Config map[string]float64
+	// Path is the path of the reduced model.
+	Path string
+	// Faces is the achieved number of faces, or -1 if the reduced model
+	// cannot be read, e.g. an ASCII FBX file.
+	Faces int
+	// Skipped reports whether the reduced model already existed.
+	Skipped bool
+	// Err is the error of a failed ratio.
+	Err error
+}
+
+// SweepOutput is the result of a sweep.
+type SweepOutput struct {
+	// Results are the results of all ratios in the order of the ratios.
+	Results []*SweepResult
+}
+
+// RatioRange returns the ratios from from to to, inclusive, in steps of
+// step, e.g. RatioRange(1, 99, 1) for 1, 2, ..., 99.
+func RatioRange(from, to, step float64) ([]float64, error) {
+	if step <= 0 || from > to {
+		return nil, fmt.Errorf("polyreduce: invalid ratio range from %v to %v in steps of %v", from, to, step)
+	}
+	var ratios []float64
+	for i := 0; ; i++ {
+		// Multiply instead of accumulate to avoid rounding errors.
+		r := math.Round((from+float64(i)*step)*1e9) / 1e9
+		if r > to {
+			break
+		}
+		ratios = append(ratios, r)
+	}
+	return ratios, nil
+}
+
+// Sweep reduces a model to every ratio of a sweep and saves the reduced
+// models to a directory. Reduced models that already exist are skipped,
+// hence an interrupted sweep resumes when it runs again. A failed ratio
+// does not stop the other ratios, and is reported by its result and the
+// returned error.
+func (c *Client) Sweep(ctx context.Context, i *SweepInput) (*SweepOutput, error) {
+	if len(i.Ratios) == 0 {
+		return nil, errors.New("polyreduce: a sweep requires ratios")
+	}
+	seen := map[float64]bool{}
+	for _, r := range i.Ratios {
+		if r < 0 || r > 100 {
+			return nil, fmt.Errorf("polyreduce: invalid reduction ratio %v, expect 0 to 100", r)
+		}
+		if seen[r] {
+			return nil, fmt.Errorf("polyreduce: duplicate reduction ratio %v", r)
+		}
+		seen[r] = true
+	}
+	if err := os.MkdirAll(i.Dir, 0755); err != nil {
+		return nil, err
+	}
+	name := i.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(i.ModelPath), filepath.Ext(i.ModelPath))
+		name = strings.TrimSuffix(name, "_0")
+	}
+	concurrency := i.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	o := &SweepOutput{Results: make([]*SweepResult, len(i.Ratios))}
+	jobs := make(chan int)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	// The uploaded copy of the model goes to the first reduction that
+	// needs a model.
+	models := make(chan string, 1)
+	if i.ModelID != "" {
+		models <- i.ModelID
+	}
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := &sweeper{c: c, in: i, models: models}
+			for j := range jobs {
+				r := &SweepResult{
+					Ratio: i.Ratios[j],
+					Path:  filepath.Join(i.Dir, name+"_"+formatRatio(i.Ratios[j])+".fbx"),
+				}
+				s.reduce(ctx, r)
+				mu.Lock()
+				o.Results[j] = r
+				if i.Progress != nil {
+					i.Progress(r)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for j := range i.Ratios {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	var first error
+	for _, r := range o.Results {
+		if r.Err != nil {
+			if first == nil {
+				first = r.Err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return o, fmt.Errorf("polyreduce: %d of %d ratios failed: %w", failed, len(o.Results), first)
+	}
+	return o, nil
+}
+
+// sweeper reduces the ratios of a sweep one after another, using its own
+// copy of the model.
+type sweeper struct {
+	c      *Client
+	in     *SweepInput
+	models chan string
+	model  string
+	layers []string
+}
+
+func (s *sweeper) reduce(ctx context.Context, r *SweepResult) {
+	if _, err := os.Stat(r.Path); err == nil {
+		r.Skipped = true
+		r.Faces = faces(r.Path)
+		return
+	}
+	if err := ctx.Err(); err != nil {
+		r.Err = err
+		return
+	}
+
+	if s.model == "" {
+		select {
+		case s.model = <-s.models:
+		default:
+			o, err := s.c.PolyredUpload(ctx, &PolyredUploadInput{ModelPath: s.in.ModelPath})
+			if err != nil {
+				r.Err = fmt.Errorf("failed to upload: %w", err)
+				return
+			}
+			s.model = o.ModelId
+		}
+	}
+	r.ModelID = s.model
+	ratios := append([]LayerRatio{{Pattern: "*", Ratio: r.Ratio}}, s.in.Layers...)
+	if s.layers == nil {
+		l, err := s.c.ModelLayers(ctx, s.model, s.in.ModelPath, ratios)
+		if err != nil {
+			r.Err = fmt.Errorf("failed to list layers: %w", err)
+			return
+		}
+		s.layers = l
+	}
+	config, err := ResolveReductionRatio(s.layers, ratios)
+	if err != nil {
+		r.Err = err
+		return
+	}
+	r.Config = config
+	err = s.c.PolyredConfig(ctx, &PolyredConfigInput{ModelID: s.model, ReductionRatio: config})
+	if err != nil {
+		r.Err = fmt.Errorf("failed to config: %w", err)
+		return
+	}
+	if err := s.c.PolyredRun(ctx, &PolyredRunInput{ModelID: s.model}); err != nil {
+		r.Err = fmt.Errorf("failed to run: %w", err)
+		return
+	}
+	_, err = s.c.PolyredDownload(ctx, &DownloadInput{ModelID: s.model, Path: r.Path})
+	if err != nil {
+		r.Err = fmt.Errorf("failed to download: %w", err)
+		return
+	}
+	r.Faces = faces(r.Path)
+}
+
+// faces returns the number of faces of a model, or -1 if the model
+// cannot be read.
+func faces(path string) int {
+	m, err := mesh.Load(path)
+	if err != nil {
+		return -1
+	}
+	return m.Faces()
+}
+
+// WriteCSV writes the manifest of a sweep as CSV with the columns ratio,
+// faces, path and error. The faces of a model that cannot be read are
+// left empty.
+func (o *SweepOutput) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"ratio", "faces", "path", "error"})
+	for _, r := range o.Results {
+		faces, path, msg := "", r.Path, ""
+		if r.Faces >= 0 && r.Err == nil {
+			faces = strconv.Itoa(r.Faces)
+		}
+		if r.Err != nil {
+			path, msg = "", r.Err.Error()
+		}
+		cw.Write([]string{formatRatio(r.Ratio), faces, path, msg})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatRatio(r float64) string {
+	return strconv.FormatFloat(r, 'f', -1, 64)
+}
@@ -0,0 +1,123 @@
+package polyreduce_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestRatioRange(t *testing.T) {
+	got, err := polyreduce.RatioRange(0.1, 0.5, 0.1)
+	if err != nil {
+		t.Fatalf("failed to create range: %v", err)
+	}
+	if want := []float64{0.1, 0.2, 0.3, 0.4, 0.5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got, _ := polyreduce.RatioRange(1, 99, 1); len(got) != 99 || got[98] != 99 {
+		t.Fatalf("unexpected range: %v", got)
+	}
+	for _, r := range [][3]float64{{1, 99, 0}, {50, 10, 1}, {1, 2, -1}} {
+		if _, err := polyreduce.RatioRange(r[0], r[1], r[2]); err == nil {
+			t.Fatalf("range %v should be rejected", r)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"Body", "Wheel"}
+
+	ctx := context.Background()
+	c := s.Client(polyreduce.WithPollInterval(time.Millisecond))
+	dir := t.TempDir()
+	in := &polyreduce.SweepInput{
+		ModelPath:   "testdata/monkey.fbx",
+		Ratios:      []float64{10, 20, 30, 40, 50},
+		Layers:      []polyreduce.LayerRatio{{Pattern: "Wheel", Ratio: 100}},
+		Dir:         dir,
+		Concurrency: 2,
+	}
+	o, err := c.Sweep(ctx, in)
+	if err != nil {
+		t.Fatalf("failed to sweep: %v", err)
+	}
+	for i, r := range o.Results {
+		if r.Ratio != in.Ratios[i] || r.Skipped || r.Faces != 7872 {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+		if want := map[string]float64{"Body": r.Ratio, "Wheel": 100}; !reflect.DeepEqual(r.Config, want) {
+			t.Fatalf("want config %v, got %v", want, r.Config)
+		}
+		if _, err := os.Stat(r.Path); err != nil {
+			t.Fatalf("the reduced model should exist: %v", err)
+		}
+	}
+	if o.Results[1].Path != filepath.Join(dir, "monkey_20.fbx") {
+		t.Fatalf("unexpected path: %s", o.Results[1].Path)
+	}
+
+	// Resume the sweep, which reduces only the missing ratio, and fail
+	// a ratio without failing the others.
+	if err := os.Remove(o.Results[2].Path); err != nil {
+		t.Fatal(err)
+	}
+	in.Ratios = append(in.Ratios, 60)
+	s.Inject(polyreducetest.RoutePolyredDownload, polyreducetest.Fault{Status: http.StatusNotFound, Times: 1})
+	calls := 0
+	in.Progress = func(r *polyreduce.SweepResult) { calls++ }
+	in.Concurrency = 1
+	up, err := c.PolyredUpload(ctx, &polyreduce.PolyredUploadInput{ModelPath: in.ModelPath})
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	in.ModelID = up.ModelId
+	o, err = c.Sweep(ctx, in)
+	if err == nil || !strings.Contains(err.Error(), "1 of 6 ratios failed") {
+		t.Fatalf("the sweep should report the failed ratio, got: %v", err)
+	}
+	if calls != 6 {
+		t.Fatalf("progress should be reported for every ratio, got %d calls", calls)
+	}
+	skipped := 0
+	for _, r := range o.Results {
+		if r.Skipped {
+			skipped++
+		}
+	}
+	if skipped != 4 || o.Results[2].Err == nil || o.Results[5].Err != nil || o.Results[5].Faces != 7872 || o.Results[5].ModelID != up.ModelId {
+		t.Fatalf("unexpected results: %+v", o.Results)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := o.WriteCSV(buf); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 7 || lines[0] != "ratio,faces,path,error" {
+		t.Fatalf("unexpected manifest:\n%s", buf)
+	}
+	if want := "10,7872," + filepath.Join(dir, "monkey_10.fbx") + ","; lines[1] != want {
+		t.Fatalf("want manifest line %q, got %q", want, lines[1])
+	}
+	if !strings.HasPrefix(lines[3], "30,,,failed to download") {
+		t.Fatalf("a failed ratio should be reported, got %q", lines[3])
+	}
+
+	for _, ratios := range [][]float64{nil, {10, 10}, {120}} {
+		in.Ratios = ratios
+		if _, err := c.Sweep(ctx, in); err == nil {
+			t.Fatalf("ratios %v should be rejected", ratios)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// BaseConfig is the base.json of a session in the dataset layout.
+type BaseConfig struct {
+	// Root is the ID of the root model, which is the session ID.
+	Root string `json:"root"`
+	// Layers are the names of all meshes of the model.
+	Layers []string `json:"layers"`
+	// Variants are the ratings of all variants of the session, Unrated
+	// variants are stored as a negative number.
+	Variants map[string]Rating `json:"variants"`
+}
+
+// SyncOutput describes the result of Session.Sync.
+type SyncOutput struct {
+	// Dir is the directory of the session.
+	Dir string
+	// Downloaded are the IDs of the models that were downloaded.
+	Downloaded []string
+	// Existing are the IDs of the models that were already synced.
+	Existing []string
+}
+
+// Sync mirrors the session into the dataset layout under the given
+// directory, e.g. dataset/sessions:
+//
+//	<dir>/<session-id>/base.json
+//	<dir>/<session-id>/<model-id>.json
+//	<dir>/<session-id>/<model-id>.fbx
+//
+// The root model and every variant are downloaded, and a variant is
+// accompanied with its reduction ratio per layer. Sync is incremental:
+// models that were already synced are not downloaded again, whereas
+// base.json is always rewritten to reflect the latest ratings.
+func (s *Session) Sync(ctx context.Context, dir string) (*SyncOutput, error) {
+	if err := s.Refresh(ctx); err != nil {
+		return nil, fmt.Errorf("failed to sync: %w", err)
+	}
+
+	o := &SyncOutput{Dir: filepath.Join(dir, s.ID)}
+	if err := os.MkdirAll(o.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to sync: %w", err)
+	}
+
+	base := &BaseConfig{Root: s.ID, Layers: []string{}, Variants: map[string]Rating{}}
+	layers := map[string]bool{}
+	ids := []string{s.ID}
+	for _, p := range s.Phases {
+		for _, id := range p.IDs {
+			base.Variants[id] = p.Rating(id)
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range ids {
+		if id != s.ID {
+			config, err := s.syncConfig(ctx, o.Dir, id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to sync config of model %s: %w", id, err)
+			}
+			for l := range config {
+				layers[l] = true
+			}
+		}
+
+		path := filepath.Join(o.Dir, id+".fbx")
+		if _, err := os.Stat(path); err == nil {
+			o.Existing = append(o.Existing, id)
+			continue
+		}
+		if _, err := s.DownloadPhase(ctx, id, path); err != nil {
+			return nil, fmt.Errorf("failed to sync model %s: %w", id, err)
+		}
+		o.Downloaded = append(o.Downloaded, id)
+	}
+
+	for l := range layers {
+		base.Layers = append(base.Layers, l)
+	}
+	sort.Strings(base.Layers)
+	if err := WriteJSONFile(filepath.Join(o.Dir, "base.json"), base); err != nil {
+		return nil, fmt.Errorf("failed to sync: %w", err)
+	}
+	return o, nil
+}
+
+// syncConfig returns the reduction configuration of a variant, which is
+// fetched from the service unless it was already synced.
+func (s *Session) syncConfig(ctx context.Context, dir, id string) (map[string]float64, error) {
+	path := filepath.Join(dir, id+".json")
+	b, err := os.ReadFile(path)
+	if err == nil {
+		var config map[string]float64
+		if err := json.Unmarshal(b, &config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	o, err := s.c.ProPolyredModelConfig(ctx, &ProPolyredModelConfigInput{SessionId: s.ID, ModelId: id})
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteJSONFile(path, o.ReductionRatio); err != nil {
+		return nil, err
+	}
+	return o.ReductionRatio, nil
+}
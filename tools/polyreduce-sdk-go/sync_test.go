@@ -0,0 +1,74 @@
+package polyreduce_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go/polyreducetest"
+)
+
+func TestSession_Sync(t *testing.T) {
+	s := polyreducetest.NewServer()
+	defer s.Close()
+	s.Layers = []string{"body", "head"}
+	c := s.Client()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	ss, err := c.StartSession(ctx, &polyreduce.ProPolyredUploadInput{ModelPath: testModel})
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	p1, err := ss.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if err := ss.Rate(ctx, map[string]polyreduce.Rating{p1.IDs[0]: polyreduce.Good}); err != nil {
+		t.Fatalf("failed to rate: %v", err)
+	}
+
+	o, err := c.Session(ss.ID).Sync(ctx, dir)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(o.Downloaded) != 1+len(p1.IDs) || len(o.Existing) != 0 {
+		t.Fatalf("unexpected sync: %+v", o)
+	}
+
+	base := &polyreduce.BaseConfig{}
+	b, err := os.ReadFile(filepath.Join(dir, ss.ID, "base.json"))
+	if err != nil {
+		t.Fatalf("failed to read base.json: %v", err)
+	}
+	if err := json.Unmarshal(b, base); err != nil {
+		t.Fatalf("failed to parse base.json: %v", err)
+	}
+	if base.Root != ss.ID || !reflect.DeepEqual(base.Layers, s.Layers) ||
+		base.Variants[p1.IDs[0]] != polyreduce.Good || base.Variants[p1.IDs[1]] != polyreduce.Unrated {
+		t.Fatalf("unexpected base.json: %s", b)
+	}
+	var config map[string]float64
+	b, _ = os.ReadFile(filepath.Join(dir, ss.ID, p1.IDs[0]+".json"))
+	snap, _ := s.Session(ss.ID)
+	if err := json.Unmarshal(b, &config); err != nil || !reflect.DeepEqual(config, snap.Configs[p1.IDs[0]]) {
+		t.Fatalf("unexpected config: %s", b)
+	}
+
+	// A second sync only fetches the new phase.
+	p2, err := ss.Next(ctx)
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	o, err = ss.Sync(ctx, dir)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if !reflect.DeepEqual(o.Downloaded, p2.IDs) || len(o.Existing) != 1+len(p1.IDs) {
+		t.Fatalf("unexpected incremental sync: %+v", o)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright © 2022 The poly.red Authors. All rights reserved.
+// The use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package polyreduce
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PolyredUploadReader uploads an FBX model read from r to the polyreduce
+// service using plain polyred service. The name is the file name of the
+// model and size is the number of bytes of the model, or -1 if unknown.
+//
+// The model is streamed to the service, hence the memory consumption is
+// independent of the model size. The progress of the upload can be
+// observed by reading from r.
+func (c *Client) PolyredUploadReader(ctx context.Context, name string, r io.Reader, size int64) (*PolyredUploadOutput, error) {
+	req, err := newUploadRequest("/polyred/upload", name, readerOnce(r), size, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.oneShot = true
+
+	output := &PolyredUploadOutput{}
+	_, err = c.doJSON(ctx, req, output)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// ProPolyredUploadReader uploads an FBX model read from r to the
+// polyreduce service and creates a propolyred session. The name is the
+// file name of the model and size is the number of bytes of the model,
+// or -1 if unknown.
+//
+// The model is streamed to the service, hence the memory consumption is
+// independent of the model size. The progress of the upload can be
+// observed by reading from r.
+func (c *Client) ProPolyredUploadReader(ctx context.Context, name string, r io.Reader, size int64) (*ProPolyredUploadOutput, error) {
+	req, err := newUploadRequest("/propolyred/upload", name, readerOnce(r), size, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.oneShot = true
+
+	output := &ProPolyredUploadOutput{}
+	_, err = c.doJSON(ctx, req, output)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// uploadFile creates an upload request of the model at the given path.
+// The file is reopened for every attempt of the request.
+func uploadFile(path, modelPath string, progress ProgressFunc) (*request, error) {
+	err := checkModelName(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", modelPath)
+	}
+
+	open := func() (io.ReadCloser, error) { return os.Open(modelPath) }
+	return newUploadRequest(path, filepath.Base(modelPath), open, fi.Size(), progress)
+}
+
+// checkModelName checks whether the given file name refers to a model
+// format that is supported by the service.
+func checkModelName(name string) error {
+	if !strings.HasSuffix(strings.ToLower(name), ".fbx") {
+		return errors.New("only .FBX model is supported")
+	}
+	return nil
+}
+
+// readerOnce returns an opener that returns r on the first call. The
+// reader is owned by the caller and is never closed.
+func readerOnce(r io.Reader) func() (io.ReadCloser, error) {
+	used := false
+	return func() (io.ReadCloser, error) {
+		if used {
+			return nil, errors.New("polyreduce: upload body cannot be sent twice")
+		}
+		used = true
+		return ioutil.NopCloser(r), nil
+	}
+}
+
+// newUploadRequest creates a multipart request that uploads an FBX model
+// as the form file "file". The multipart body is produced while it is
+// being sent through an io.Pipe, thus the model is never fully loaded
+// into memory. The progress reports the number of model bytes sent.
+func newUploadRequest(path, name string, open func() (io.ReadCloser, error), size int64, progress ProgressFunc) (*request, error) {
+	err := checkModelName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a fixed boundary for all attempts so that the length of the
+	// body can be computed in advance if the model size is known.
+	boundary := multipart.NewWriter(nil).Boundary()
+	head := new(bytes.Buffer)
+	mw := multipart.NewWriter(head)
+	mw.SetBoundary(boundary)
+	_, err = mw.CreateFormFile("file", name)
+	if err != nil {
+		return nil, err
+	}
+	tail := "\r\n--" + boundary + "--\r\n"
+
+	req := &request{
+		method:      http.MethodPost,
+		path:        path,
+		contentType: mw.FormDataContentType(),
+	}
+	if size >= 0 {
+		req.contentLength = int64(head.Len()) + size + int64(len(tail))
+	}
+	req.body = func() (io.Reader, error) {
+		f, err := open()
+		if err != nil {
+			return nil, err
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			defer f.Close()
+
+			w := multipart.NewWriter(pw)
+			w.SetBoundary(boundary)
+			part, err := w.CreateFormFile("file", name)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			n, err := io.Copy(part, withProgress(f, size, progress))
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if size >= 0 && n != size {
+				pw.CloseWithError(fmt.Errorf("polyreduce: model size mismatch, expect %d bytes but read %d bytes", size, n))
+				return
+			}
+			pw.CloseWithError(w.Close())
+		}()
+		return pr, nil
+	}
+	return req, nil
+}
@@ -16,15 +16,18 @@ package main
 import (
 	"fmt"
 	"os"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
 )
 
 func main() {
 	all := [][]int{}
-	for i := 0; i < 6; i++ {
-		for j := 0; j < 6; j++ {
-			for k := 0; k < 6; k++ {
-				for l := 0; l < 6; l++ {
-					all = append(all, []int{i, j, k, l})
+	scale := polyreduce.Ratings()
+	for _, i := range scale {
+		for _, j := range scale {
+			for _, k := range scale {
+				for _, l := range scale {
+					all = append(all, []int{int(i), int(j), int(k), int(l)})
 				}
 			}
 		}
@@ -7,6 +7,10 @@
 // This script extracts the configuration files and generates the
 // corresponding csv file that includes the reduction_ratio and associated
 // human rating. The reduction ratio is an average of an optimization step.
+//
+// Variants that were never rated, i.e. with a negative score, are assigned
+// a random rating from Skip to Fair, and scores above Excellent are clamped
+// to Excellent.
 package main
 
 import (
@@ -17,6 +21,8 @@ import (
 	"log"
 	"math/rand"
 	"os"
+
+	"changkun.de/x/infloop/tools/polyreduce-sdk-go"
 )
 
 type BaseConf struct {
@@ -59,7 +65,7 @@ func main() {
 		var conf BaseConf
 		err = json.Unmarshal(b, &conf)
 		if err != nil {
-			panic(err)
+			panic(fmt.Errorf("%s: %w", base, err))
 		}
 
 		for id, score := range conf.Variants {
@@ -82,8 +88,8 @@ func main() {
 			if all < 0 {
 				all = 0
 			}
-			if score < 0 {
-				score = float64(rand.Intn(4))
+			if score < float64(polyreduce.Skip) { // Unrated
+				score = float64(rand.Intn(int(polyreduce.Good)))
 			}
 			variants = append(variants, detail{
 				ID:             id,
@@ -99,8 +105,8 @@ func main() {
 	}
 	f.WriteString("reduction_ratio,rating\n")
 	for _, y := range variants {
-		if y.Rating > 5 {
-			y.Rating = 5
+		if y.Rating > float64(polyreduce.Excellent) {
+			y.Rating = float64(polyreduce.Excellent)
 		}
 		if y.ReductionRatio == 0 || y.ReductionRatio > 100 {
 			continue